@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const nixSystemProfile = "/nix/var/nix/profiles/system"
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Deletes old system generations and collects nix store garbage",
+	Long: `Deletes the given NixOS system generations, then runs the nix
+garbage collector. The currently active generation can never be deleted.
+
+Example:
+  nix gc --delete-generations 12,13,14`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		generationsFlag, _ := cmd.Flags().GetString("delete-generations")
+
+		current, err := currentSystemGeneration()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading current system generation: %v\n", err)
+			os.Exit(1)
+		}
+
+		generations := []string{}
+		for _, g := range strings.Split(generationsFlag, ",") {
+			if g == "" {
+				continue
+			}
+
+			number, err := strconv.Atoi(g)
+			if err != nil || number <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid generation %q\n", g)
+				os.Exit(1)
+			}
+
+			if number == current {
+				fmt.Fprintf(os.Stderr, "Error: refusing to delete the current system generation %d\n", number)
+				os.Exit(1)
+			}
+
+			generations = append(generations, strconv.Itoa(number))
+		}
+
+		if len(generations) > 0 {
+			fmt.Printf("Deleting system generations: %s\n", strings.Join(generations, " "))
+
+			if !dryRun {
+				deleteArgs := append([]string{"--profile", nixSystemProfile, "--delete-generations"}, generations...)
+				runOrExit("nix-env", deleteArgs...)
+			}
+		}
+
+		// In a dry run the generations are still there, so work out
+		// what deleting them would free as well.
+		pending := []string{}
+		if dryRun {
+			pending = generations
+		}
+
+		dead, err := deadStorePaths(pending)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding dead store paths: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Sizes per path, so dogeboxd can report progress in bytes.
+		var size int64
+		for path, pathSize := range dead {
+			fmt.Printf("Reclaimable path: %s %d\n", path, pathSize)
+			size += pathSize
+		}
+		fmt.Printf("Reclaimable: %d store paths, %d bytes\n", len(dead), size)
+
+		if dryRun {
+			return
+		}
+
+		runOrExit("nix-store", "--gc")
+
+		// Remove boot entries for the generations we just deleted.
+		if len(generations) > 0 {
+			runOrExit(filepath.Join(nixSystemProfile, "bin", "switch-to-configuration"), "boot")
+		}
+	},
+}
+
+func currentSystemGeneration() (int, error) {
+	link, err := os.Readlink(nixSystemProfile)
+	if err != nil {
+		return 0, err
+	}
+
+	var number int
+	if _, err := fmt.Sscanf(filepath.Base(link), "system-%d-link", &number); err != nil {
+		return 0, fmt.Errorf("unexpected system profile link %q", link)
+	}
+
+	return number, nil
+}
+
+/* deadStorePaths returns the store paths the garbage collector
+ * would delete, and their size in bytes, as if the pending system
+ * generations had already been deleted.
+ */
+func deadStorePaths(pending []string) (map[string]int64, error) {
+	dead := []string{}
+
+	out, err := exec.Command("nix-store", "--gc", "--print-dead").Output()
+	if err != nil {
+		return nil, err
+	}
+	dead = append(dead, storePaths(out)...)
+
+	if len(pending) > 0 {
+		pendingLinks := map[string]bool{}
+		for _, g := range pending {
+			pendingLinks[fmt.Sprintf("%s-%s-link", nixSystemProfile, g)] = true
+		}
+
+		out, err := exec.Command("nix-store", "--gc", "--print-roots").Output()
+		if err != nil {
+			return nil, err
+		}
+
+		// The pending generations' closures, less anything another root keeps alive.
+		pendingRoots, otherRoots := []string{}, []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			link, target, ok := strings.Cut(line, " -> ")
+			if !ok || !strings.HasPrefix(target, "/nix/store/") {
+				continue
+			}
+			if pendingLinks[link] {
+				pendingRoots = append(pendingRoots, target)
+			} else {
+				otherRoots = append(otherRoots, target)
+			}
+		}
+
+		freed, err := storeClosure(pendingRoots)
+		if err != nil {
+			return nil, err
+		}
+		kept, err := storeClosure(otherRoots)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range freed {
+			if _, ok := slices.BinarySearch(kept, path); !ok {
+				dead = append(dead, path)
+			}
+		}
+	}
+
+	sizes := map[string]int64{}
+	for _, path := range dead {
+		var size int64
+		filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+		sizes[path] = size
+	}
+
+	return sizes, nil
+}
+
+// storeClosure returns every store path the given paths depend on, and themselves, sorted.
+func storeClosure(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return []string{}, nil
+	}
+
+	out, err := exec.Command("nix-store", append([]string{"--query", "--requisites"}, paths...)...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	closure := storePaths(out)
+	slices.Sort(closure)
+	return slices.Compact(closure), nil
+}
+
+func storePaths(out []byte) []string {
+	paths := []string{}
+	for _, path := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if strings.HasPrefix(path, "/nix/store/") {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func runOrExit(name string, args ...string) {
+	execCmd := exec.Command(name, args...)
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = os.Stderr
+
+	if err := execCmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	nixCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().String("delete-generations", "", "Comma separated system generations to delete")
+	gcCmd.Flags().Bool("dry-run", false, "Only report what would be deleted")
+}
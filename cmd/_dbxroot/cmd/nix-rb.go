@@ -12,7 +12,13 @@ var rbCmd = &cobra.Command{
 	Use:   "rb",
 	Short: "Executes nixos-rebuild boot",
 	Run: func(cmd *cobra.Command, args []string) {
-		execCmd := exec.Command("nixos-rebuild", "boot", "-I", "nixos-config=/etc/nixos/configuration.nix")
+		rebuildArgs := []string{"boot", "-I", "nixos-config=/etc/nixos/configuration.nix"}
+		if jsonLog, _ := cmd.Flags().GetBool("json-log"); jsonLog {
+			rebuildArgs = append(rebuildArgs, "--log-format", "internal-json")
+		}
+		rebuildArgs = append(rebuildArgs, mirrorSubstituterOptions(cmd)...)
+
+		execCmd := exec.Command("nixos-rebuild", rebuildArgs...)
 		execCmd.Stdout = os.Stdout
 		execCmd.Stderr = os.Stderr
 
@@ -26,4 +32,8 @@ var rbCmd = &cobra.Command{
 
 func init() {
 	nixCmd.AddCommand(rbCmd)
+
+	rbCmd.Flags().Bool("json-log", false, "Output nix's internal-json log format, for parsing build progress")
+	rbCmd.Flags().StringArray("substituter", []string{}, "Extra binary cache to substitute from, ie. an imported mirror")
+	rbCmd.Flags().StringArray("trusted-public-key", []string{}, "Public key the extra binary caches are signed with")
 }
@@ -12,7 +12,13 @@ var rsCmd = &cobra.Command{
 	Use:   "rs",
 	Short: "Executes nixos-rebuild switch",
 	Run: func(cmd *cobra.Command, args []string) {
-		execCmd := exec.Command("nixos-rebuild", "switch", "-I", "nixos-config=/etc/nixos/configuration.nix")
+		rebuildArgs := []string{"switch", "-I", "nixos-config=/etc/nixos/configuration.nix"}
+		if jsonLog, _ := cmd.Flags().GetBool("json-log"); jsonLog {
+			rebuildArgs = append(rebuildArgs, "--log-format", "internal-json")
+		}
+		rebuildArgs = append(rebuildArgs, mirrorSubstituterOptions(cmd)...)
+
+		execCmd := exec.Command("nixos-rebuild", rebuildArgs...)
 		execCmd.Stdout = os.Stdout
 		execCmd.Stderr = os.Stderr
 
@@ -26,4 +32,8 @@ var rsCmd = &cobra.Command{
 
 func init() {
 	nixCmd.AddCommand(rsCmd)
+
+	rsCmd.Flags().Bool("json-log", false, "Output nix's internal-json log format, for parsing build progress")
+	rsCmd.Flags().StringArray("substituter", []string{}, "Extra binary cache to substitute from, ie. an imported mirror")
+	rsCmd.Flags().StringArray("trusted-public-key", []string{}, "Public key the extra binary caches are signed with")
 }
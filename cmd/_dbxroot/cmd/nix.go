@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
 )
 
@@ -12,6 +16,38 @@ var nixCmd = &cobra.Command{
 	},
 }
 
+// mirrorSubstituterOptions turns --substituter and --trusted-public-key
+// into nixos-rebuild options. Only local (file://) caches are accepted.
+func mirrorSubstituterOptions(cmd *cobra.Command) []string {
+	substituters, _ := cmd.Flags().GetStringArray("substituter")
+	keys, _ := cmd.Flags().GetStringArray("trusted-public-key")
+
+	if len(substituters) == 0 {
+		return []string{}
+	}
+
+	for _, s := range substituters {
+		if !strings.HasPrefix(s, "file:///") || strings.ContainsAny(s, " \t\n?") {
+			fmt.Fprintf(os.Stderr, "Error: invalid substituter %q, only local file:// caches are allowed\n", s)
+			os.Exit(1)
+		}
+	}
+
+	for _, k := range keys {
+		if !strings.Contains(k, ":") || strings.ContainsAny(k, " \t\n") {
+			fmt.Fprintf(os.Stderr, "Error: invalid public key %q\n", k)
+			os.Exit(1)
+		}
+	}
+
+	options := []string{"--option", "extra-substituters", strings.Join(substituters, " ")}
+	if len(keys) > 0 {
+		options = append(options, "--option", "extra-trusted-public-keys", strings.Join(keys, " "))
+	}
+
+	return options
+}
+
 func init() {
 	rootCmd.AddCommand(nixCmd)
 }
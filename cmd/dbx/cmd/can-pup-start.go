@@ -64,7 +64,7 @@ var canPupStartCmd = &cobra.Command{
 			return
 		}
 
-		sourceManager := source.NewSourceManager(dogeboxd.ServerConfig{}, sm, pupManager)
+		sourceManager := source.NewSourceManager(dogeboxd.ServerConfig{}, sm, store, pupManager)
 		pupManager.SetSourceManager(sourceManager)
 
 		canStart, err := pupManager.CanPupStart(pupId)
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	source "github.com/dogeorg/dogeboxd/pkg/sources"
+	"github.com/spf13/cobra"
+)
+
+// Logos are inlined as base64 into every store listing, so keep them small.
+const lintMaxLogoSize = 256 * 1024
+
+type lintIssue struct {
+	Severity string `json:"severity"` // error or warning
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+type lintReport struct {
+	PupDir          string      `json:"pupDir"`
+	ManifestVersion int         `json:"manifestVersion"`
+	OK              bool        `json:"ok"`
+	Issues          []lintIssue `json:"issues"`
+}
+
+func (r *lintReport) add(severity, check, path, format string, args ...any) {
+	r.Issues = append(r.Issues, lintIssue{Severity: severity, Check: check, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *lintReport) hasIssueAt(path string) bool {
+	for _, issue := range r.Issues {
+		if issue.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+var devPupLintCmd = &cobra.Command{
+	Use:   "lint [pupDir]",
+	Short: "Check a pup for problems before publishing it",
+	Long: `Check a pup's manifest against the manifest schema and the rules
+dogeboxd enforces: semver of interfaces and dependencies, ports that
+collide across exposes, config field sanity, the nix file hash and
+the logo. Version 2 manifests must pass every check, for version 1
+manifests the stricter checks are reported as warnings.
+
+Exits non-zero if any errors are found. Use --json for machine-readable
+output, or --schema to print the manifest JSON Schema.
+
+The default source of each dependency is fetched to check it provides
+the interface, use --offline to skip that.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if printSchema, _ := cmd.Flags().GetBool("schema"); printSchema {
+			fmt.Fprint(cmd.OutOrStdout(), string(dogeboxd.ManifestSchema))
+			return
+		}
+
+		pupDir, _ := cmd.Flags().GetString("pupDir")
+		if len(args) > 0 {
+			pupDir = args[0]
+		}
+
+		if pupDir == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				log.Fatalf("Error getting current working directory: %v", err)
+			}
+			pupDir = cwd
+		}
+
+		offline, _ := cmd.Flags().GetBool("offline")
+		report := lintPup(pupDir, offline)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			out, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Error marshalling report: %v", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		} else {
+			for _, issue := range report.Issues {
+				location := issue.Check
+				if issue.Path != "" {
+					location += " " + issue.Path
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%-7s [%s] %s\n", issue.Severity, location, issue.Message)
+			}
+			if report.OK {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: ok (%d warnings)\n", pupDir, len(report.Issues))
+			}
+		}
+
+		if !report.OK {
+			os.Exit(1)
+		}
+	},
+}
+
+func lintPup(pupDir string, offline bool) (report lintReport) {
+	report = lintReport{PupDir: pupDir, Issues: []lintIssue{}}
+
+	defer func() {
+		report.OK = true
+		for _, issue := range report.Issues {
+			if issue.Severity == "error" {
+				report.OK = false
+			}
+		}
+	}()
+
+	manifestData, err := os.ReadFile(filepath.Join(pupDir, "manifest.json"))
+	if err != nil {
+		report.add("error", "manifest", "", "can't read manifest.json: %v", err)
+		return report
+	}
+
+	var manifest dogeboxd.PupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		report.add("error", "manifest", "", "can't parse manifest.json: %v", err)
+		return report
+	}
+	report.ManifestVersion = manifest.ManifestVersion
+
+	// Version 1 manifests predate these rules, so only nag about them.
+	strict := "warning"
+	if manifest.ManifestVersion >= 2 {
+		strict = "error"
+	} else {
+		report.add("warning", "manifest", "/manifestVersion", "manifestVersion 1 is only loosely checked, consider moving to 2")
+	}
+
+	issues, err := dogeboxd.ValidateManifestSchema(manifestData)
+	if err != nil {
+		report.add("error", "schema", "", "%v", err)
+	}
+	for _, issue := range issues {
+		report.add(strict, "schema", issue.Path, "%s", issue.Message)
+	}
+
+	strictIssues := manifest.StrictIssues()
+
+	// For version 2, Validate fails on the first strict issue, which is reported below anyway.
+	if err := manifest.Validate(); err != nil && (len(strictIssues) == 0 || err.Error() != strictIssues[0].String()) {
+		report.add("error", "manifest", "", "%v", err)
+	}
+
+	for _, issue := range strictIssues {
+		// The schema already catches some of these, once is enough.
+		if report.hasIssueAt(issue.Path) {
+			continue
+		}
+		report.add(strict, lintCheckFor(issue.Path), issue.Path, "%s", issue.Message)
+	}
+
+	lintPorts(&report, manifest)
+	lintDependencies(&report, manifest, strict, offline)
+	lintNixFile(&report, pupDir, manifest)
+	lintLogo(&report, pupDir, manifest)
+
+	return report
+}
+
+// lintCheckFor names the check a StrictIssues path belongs to.
+func lintCheckFor(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/container/exposes"):
+		return "ports"
+	case strings.HasPrefix(path, "/config"):
+		return "config"
+	case strings.HasPrefix(path, "/dependencies"), strings.HasPrefix(path, "/interfaces"), path == "/meta/version":
+		return "semver"
+	}
+	return "manifest"
+}
+
+func lintPorts(report *lintReport, manifest dogeboxd.PupManifest) {
+	exposed := map[int]bool{}
+	for _, expose := range manifest.Container.Exposes {
+		exposed[expose.Port] = true
+	}
+
+	// A permission group port nothing listens on can never be reached.
+	for i, iface := range manifest.Interfaces {
+		for j, group := range iface.PermissionGroups {
+			if group.Port != 0 && !exposed[group.Port] {
+				report.add("warning", "ports", fmt.Sprintf("/interfaces/%d/permissionGroups/%d/port", i, j), "port %d isn't in container.exposes", group.Port)
+			}
+		}
+	}
+}
+
+func lintDependencies(report *lintReport, manifest dogeboxd.PupManifest, strict string, offline bool) {
+	for i, dep := range manifest.Dependencies {
+		path := fmt.Sprintf("/dependencies/%d", i)
+
+		for _, iface := range manifest.Interfaces {
+			if iface.Name == dep.InterfaceName {
+				report.add("warning", "dependencies", path+"/interfaceName", "pup depends on interface %s, which it provides itself", dep.InterfaceName)
+			}
+		}
+
+		// Without a default source, a user with no provider installed has nowhere to get one.
+		if !dep.Optional && dep.DefaultSource.PupName == "" {
+			report.add("warning", "dependencies", path+"/source", "no default source for required interface %s", dep.InterfaceName)
+		}
+
+		if !offline && dep.DefaultSource.SourceLocation != "" && dep.DefaultSource.PupName != "" {
+			lintDefaultSource(report, path+"/source", dep, strict)
+		}
+	}
+}
+
+// lintDefaultSource checks a dependency's default source pup exists and provides the interface.
+func lintDefaultSource(report *lintReport, path string, dep dogeboxd.PupManifestDependency, strict string) {
+	src := dep.DefaultSource
+
+	list, err := source.ListLocation(src.SourceLocation)
+	if err != nil {
+		report.add("warning", "dependencies", path+"/sourceLocation", "can't list %s, use --offline to skip this: %v", src.SourceLocation, err)
+		return
+	}
+
+	constraint, err := semver.NewConstraint(dep.InterfaceVersion)
+	if err != nil {
+		// already reported by StrictIssues
+		return
+	}
+
+	found := false
+	for _, pup := range list.Pups {
+		if pup.Name != src.PupName || (src.PupVersion != "" && pup.Version != src.PupVersion) {
+			continue
+		}
+		found = true
+
+		for _, iface := range pup.Manifest.Interfaces {
+			ver, err := semver.NewVersion(iface.Version)
+			if err == nil && iface.Name == dep.InterfaceName && constraint.Check(ver) {
+				return
+			}
+		}
+	}
+
+	name := strings.TrimSpace(src.PupName + " " + src.PupVersion)
+	if !found {
+		report.add(strict, "dependencies", path+"/pupName", "%s doesn't list %s", src.SourceLocation, name)
+		return
+	}
+	report.add(strict, "dependencies", path+"/pupName", "%s doesn't provide interface %s %s", name, dep.InterfaceName, dep.InterfaceVersion)
+}
+
+func lintNixFile(report *lintReport, pupDir string, manifest dogeboxd.PupManifest) {
+	if manifest.Container.Build.NixFile == "" {
+		return
+	}
+
+	nixFile, err := os.ReadFile(filepath.Join(pupDir, manifest.Container.Build.NixFile))
+	if err != nil {
+		report.add("error", "nix", "/container/build/nixFile", "can't read %s: %v", manifest.Container.Build.NixFile, err)
+		return
+	}
+
+	if fmt.Sprintf("%x", sha256.Sum256(nixFile)) != manifest.Container.Build.NixFileSha256 {
+		report.add("error", "nix", "/container/build/nixFileSha256", "doesn't match %s, run `dbx dev pup update-hash`", manifest.Container.Build.NixFile)
+	}
+}
+
+func lintLogo(report *lintReport, pupDir string, manifest dogeboxd.PupManifest) {
+	if manifest.Meta.LogoPath == "" {
+		report.add("warning", "logo", "/meta/logoPath", "pup has no logo")
+		return
+	}
+
+	logo, err := os.ReadFile(filepath.Join(pupDir, manifest.Meta.LogoPath))
+	if err != nil {
+		report.add("error", "logo", "/meta/logoPath", "can't read %s: %v", manifest.Meta.LogoPath, err)
+		return
+	}
+
+	// Only these are turned into store logos, see utils.ImageBytesToWebBase64.
+	expected := ""
+	switch strings.ToLower(filepath.Ext(manifest.Meta.LogoPath)) {
+	case ".png":
+		expected = "image/png"
+	case ".jpg", ".jpeg":
+		expected = "image/jpeg"
+	default:
+		report.add("error", "logo", "/meta/logoPath", "logo must be a .png, .jpg or .jpeg file")
+		return
+	}
+
+	if actual := http.DetectContentType(logo); actual != expected {
+		report.add("error", "logo", "/meta/logoPath", "%s looks like %s, not %s", manifest.Meta.LogoPath, actual, expected)
+	}
+
+	if len(logo) > lintMaxLogoSize {
+		report.add("warning", "logo", "/meta/logoPath", "logo is %d KB, keep it under %d KB", len(logo)/1024, lintMaxLogoSize/1024)
+	}
+}
+
+func init() {
+	devPupLintCmd.Flags().StringP("pupDir", "p", "", "Directory of the pup you want to lint")
+	devPupLintCmd.Flags().Bool("json", false, "Print the report as JSON")
+	devPupLintCmd.Flags().Bool("schema", false, "Print the manifest JSON Schema and exit")
+	devPupLintCmd.Flags().Bool("offline", false, "Don't fetch dependencies' default sources to check them")
+	devPupCmd.AddCommand(devPupLintCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var devPupPackCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "Package a pup into an archive that can be sideloaded",
+	Long: `Package a pup directory into a gzipped tarball with manifest.json
+at its root, ready to upload to POST /pup/sideload or copy to a USB stick.
+Hidden files and directories (like .git) are left out.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pupDir, _ := cmd.Flags().GetString("pupDir")
+		out, _ := cmd.Flags().GetString("out")
+
+		if pupDir == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				log.Fatalf("Error getting current working directory: %v", err)
+			}
+			pupDir = cwd
+		}
+
+		manifestFile, err := os.ReadFile(filepath.Join(pupDir, "manifest.json"))
+		if err != nil {
+			log.Fatalf("Error reading manifest file: %v", err)
+		}
+
+		var manifest dogeboxd.PupManifest
+		if err := json.Unmarshal(manifestFile, &manifest); err != nil {
+			log.Fatalf("Error unmarshalling manifest file: %v", err)
+		}
+
+		if err := manifest.Validate(); err != nil {
+			log.Fatalf("Invalid manifest: %v", err)
+		}
+
+		nixFile, err := os.ReadFile(filepath.Join(pupDir, manifest.Container.Build.NixFile))
+		if err != nil {
+			log.Fatalf("Error reading nix file: %v", err)
+		}
+
+		if fmt.Sprintf("%x", sha256.Sum256(nixFile)) != manifest.Container.Build.NixFileSha256 {
+			log.Fatalf("Nix file hash does not match manifest, run `dbx dev pup update-hash` first")
+		}
+
+		if manifest.Meta.LogoPath != "" {
+			if _, err := os.Stat(filepath.Join(pupDir, manifest.Meta.LogoPath)); err != nil {
+				log.Fatalf("Logo file %s not found", manifest.Meta.LogoPath)
+			}
+		}
+
+		if out == "" {
+			out = fmt.Sprintf("%s-%s.tar.gz", manifest.Meta.Name, manifest.Meta.Version)
+		}
+
+		if err := packPup(pupDir, out); err != nil {
+			os.Remove(out)
+			log.Fatalf("Error packing pup: %v", err)
+		}
+
+		cmd.Printf("Wrote %s\n", out)
+	},
+}
+
+func packPup(pupDir, out string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	outAbs, _ := filepath.Abs(out)
+
+	return utils.WriteTarGz(pupDir, f, func(rel string, info os.FileInfo) bool {
+		if strings.HasPrefix(info.Name(), ".") {
+			return true
+		}
+
+		// Don't pack the archive into itself if it's written inside the pup.
+		abs, _ := filepath.Abs(filepath.Join(pupDir, rel))
+		return abs == outAbs
+	})
+}
+
+func init() {
+	devPupPackCmd.Flags().StringP("pupDir", "p", "", "Directory of the pup you want to pack")
+	devPupPackCmd.Flags().StringP("out", "o", "", "Archive to write, defaults to <name>-<version>.tar.gz")
+	devPupCmd.AddCommand(devPupPackCmd)
+}
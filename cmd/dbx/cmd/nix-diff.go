@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dogeorg/dogeboxd/pkg/system"
+	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/spf13/cobra"
+)
+
+var nixDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the differences between two nix generations.",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetInt("from")
+		to, _ := cmd.Flags().GetInt("to")
+
+		nixManager := nix.NewNixManager(nixConfigFromFlags(cmd), nil, system.NewDBXRoot())
+
+		files, err := nixManager.DiffGenerations(from, to)
+		if err != nil {
+			log.Printf("Failed to diff nix generations: %v", err)
+			os.Exit(1)
+		}
+
+		if len(files) == 0 {
+			log.Printf("Generations %d and %d are identical.", from, to)
+			os.Exit(0)
+		}
+
+		for _, file := range files {
+			fmt.Print(file.Diff)
+		}
+	},
+}
+
+func init() {
+	nixDiffCmd.Flags().IntP("from", "f", 0, "generation to diff from")
+	nixDiffCmd.Flags().IntP("to", "t", 0, "generation to diff to")
+	nixDiffCmd.MarkFlagRequired("from")
+	nixDiffCmd.MarkFlagRequired("to")
+	nixCmd.AddCommand(nixDiffCmd)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/pup"
+	"github.com/dogeorg/dogeboxd/pkg/system"
+	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/spf13/cobra"
+)
+
+var nixDryRunCmd = &cobra.Command{
+	Use:   "dry-run",
+	Short: "Show the nix changes a pup action would make, without applying them.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config := nixConfigFromFlags(cmd)
+		pupId, _ := cmd.Flags().GetString("pup-id")
+		action, _ := cmd.Flags().GetString("action")
+		evaluate, _ := cmd.Flags().GetBool("evaluate")
+
+		var a dogeboxd.Action
+		switch action {
+		case "uninstall":
+			a = dogeboxd.UninstallPup{PupID: pupId}
+		case "purge":
+			a = dogeboxd.PurgePup{PupID: pupId}
+		case "enable":
+			a = dogeboxd.EnablePup{PupID: pupId}
+		case "disable":
+			a = dogeboxd.DisablePup{PupID: pupId}
+		default:
+			log.Printf("Unknown pup action %q, must be one of: uninstall, purge, enable, disable", action)
+			os.Exit(1)
+		}
+
+		store, err := dogeboxd.NewStoreManager(fmt.Sprintf("%s/dogebox.db", config.DataDir))
+		if err != nil {
+			log.Println("couldn't open store-manager db", err)
+			os.Exit(1)
+		}
+		sm := system.NewStateManager(store)
+
+		// Ideally we wouldn't have to init all these things.
+		systemMonitor := system.NewSystemMonitor(config)
+
+		pupManager, err := pup.NewPupManager(config.DataDir, config.TmpDir, systemMonitor)
+		if err != nil {
+			log.Println("Failed to load PupManager: ", err)
+			os.Exit(1)
+		}
+
+		nixManager := nix.NewNixManager(config, pupManager, system.NewDBXRoot())
+		systemUpdater := system.NewSystemUpdater(config, nil, nixManager, nil, pupManager, sm, nil, system.NewDBXRoot())
+
+		result, err := systemUpdater.DryRunAction(a, dogeboxd.NixPatchDryRunOptions{Evaluate: evaluate}, dogeboxd.NewConsoleSubLogger(pupId, "dry-run"))
+		if err != nil {
+			log.Printf("Failed to dry run %s: %v", action, err)
+			os.Exit(1)
+		}
+
+		if len(result.Files) == 0 {
+			log.Println("No nix files would change.")
+			os.Exit(0)
+		}
+
+		failedEval := false
+		for _, file := range result.Files {
+			fmt.Print(file.Diff)
+			if file.EvalError != "" {
+				failedEval = true
+				log.Printf("Evaluation of %s failed: %s", file.Filename, file.EvalError)
+			}
+		}
+
+		if result.EvalError != "" {
+			failedEval = true
+			log.Printf("Evaluation of the system configuration failed: %s", result.EvalError)
+		}
+
+		if failedEval {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	nixDryRunCmd.Flags().StringP("pup-id", "p", "", "id of the pup to act on")
+	nixDryRunCmd.Flags().StringP("action", "a", "", "pup action to dry run (uninstall, purge, enable, disable)")
+	nixDryRunCmd.Flags().BoolP("evaluate", "e", false, "Also parse changed files and evaluate the system configuration")
+	nixDryRunCmd.MarkFlagRequired("pup-id")
+	nixDryRunCmd.MarkFlagRequired("action")
+	nixCmd.AddCommand(nixDryRunCmd)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	_ "embed"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dogeorg/dogeboxd/pkg/system"
+	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/spf13/cobra"
+)
+
+var nixGenerationsCmd = &cobra.Command{
+	Use:   "generations",
+	Short: "List recorded generations of the dogebox nix configuration.",
+	Run: func(cmd *cobra.Command, args []string) {
+		nixManager := nix.NewNixManager(nixConfigFromFlags(cmd), nil, system.NewDBXRoot())
+
+		generations, err := nixManager.ListGenerations()
+		if err != nil {
+			log.Printf("Failed to list nix generations: %v", err)
+			os.Exit(1)
+		}
+
+		if len(generations) == 0 {
+			log.Println("No nix generations recorded yet.")
+			os.Exit(0)
+		}
+
+		for _, g := range generations {
+			job := g.JobID
+			if job == "" {
+				job = "-"
+			}
+
+			cmd.Printf("%4d  %s  nixos:%-4d  job:%s  %s\n", g.Number, g.Created.Format("2006-01-02 15:04:05"), g.NixOSGeneration, job, strings.Join(g.Operations, ", "))
+		}
+	},
+}
+
+func init() {
+	nixCmd.AddCommand(nixGenerationsCmd)
+}
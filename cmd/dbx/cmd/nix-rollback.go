@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	_ "embed"
+	"log"
+	"os"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/system"
+	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/spf13/cobra"
+)
+
+var nixRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore a previous nix generation and rebuild.",
+	Run: func(cmd *cobra.Command, args []string) {
+		generation, _ := cmd.Flags().GetInt("generation")
+
+		nixManager := nix.NewNixManager(nixConfigFromFlags(cmd), nil, system.NewDBXRoot())
+
+		patch := nixManager.NewPatch(dogeboxd.NewConsoleSubLogger("internal", "rollback"))
+		nixManager.RestoreGeneration(patch, generation)
+
+		if err := patch.Apply(); err != nil {
+			log.Printf("Failed to roll back to generation %d: %v", generation, err)
+			os.Exit(1)
+		}
+
+		log.Printf("Rolled back to generation %d.", generation)
+	},
+}
+
+func init() {
+	nixRollbackCmd.Flags().IntP("generation", "g", 0, "generation to roll back to")
+	nixRollbackCmd.MarkFlagRequired("generation")
+	nixCmd.AddCommand(nixRollbackCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/spf13/cobra"
+)
+
+var nixCmd = &cobra.Command{
+	Use:   "nix",
+	Short: "Inspect and manage the dogebox nix configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// nixConfigFromFlags builds just enough of a ServerConfig
+// for a NixManager to find its files.
+func nixConfigFromFlags(cmd *cobra.Command) dogeboxd.ServerConfig {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	nixDir, _ := cmd.Flags().GetString("nix-dir")
+	containerLogDir, _ := cmd.Flags().GetString("container-log-dir")
+
+	return dogeboxd.ServerConfig{
+		DataDir:         dataDir,
+		TmpDir:          filepath.Join(dataDir, "tmp"),
+		NixDir:          nixDir,
+		ContainerLogDir: containerLogDir,
+	}
+}
+
+func init() {
+	nixCmd.PersistentFlags().StringP("data-dir", "d", "/opt/dogebox", "dogebox data dir")
+	nixCmd.PersistentFlags().StringP("nix-dir", "n", "/etc/nixos/dogebox", "dogebox nix dir")
+	nixCmd.PersistentFlags().String("container-log-dir", "/var/log/containers", "container log dir")
+	rootCmd.AddCommand(nixCmd)
+}
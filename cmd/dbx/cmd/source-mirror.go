@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/pup"
+	source "github.com/dogeorg/dogeboxd/pkg/sources"
+	"github.com/dogeorg/dogeboxd/pkg/system"
+	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var sourceMirrorExportCmd = &cobra.Command{
+	Use:   "export <sourceId> <dest>",
+	Short: "Export a source as a mirror bundle for an offline box",
+	Long: `Export a source, or some of its pups, as a mirror bundle that
+can be imported on a box without internet access. Every pup version
+is downloaded and verified as it would be for an install.
+
+With --closures, each pup is also built and its nix closure copied
+into the bundle, so the importing box doesn't need a binary cache.
+
+Example:
+  dbx source mirror export dogeorg ./dogeorg-mirror --pup dogecoin-core@1.14.9 --pup identity`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dataDir, _ := cmd.Flags().GetString("data-dir")
+		pupFlags, _ := cmd.Flags().GetStringArray("pup")
+		closures, _ := cmd.Flags().GetBool("closures")
+		archive, _ := cmd.Flags().GetBool("archive")
+
+		sourceID, dest := args[0], args[1]
+
+		pups := map[string][]string{}
+		for _, p := range pupFlags {
+			name, version, _ := strings.Cut(p, "@")
+			if version == "" {
+				pups[name] = []string{}
+			} else {
+				pups[name] = append(pups[name], version)
+			}
+		}
+
+		store, err := dogeboxd.NewStoreManager(fmt.Sprintf("%s/dogebox.db", dataDir))
+		if err != nil {
+			log.Printf("Couldn't open store-manager db: %v", err)
+			os.Exit(1)
+		}
+		sm := system.NewStateManager(store)
+
+		pupManager, err := pup.NewPupManager(dataDir, "/tmp", system.NewSystemMonitor(dogeboxd.ServerConfig{}))
+		if err != nil {
+			log.Printf("Failed to load PupManager: %v", err)
+			os.Exit(1)
+		}
+
+		sourceManager := source.NewSourceManager(dogeboxd.ServerConfig{}, sm, store, pupManager)
+		pupManager.SetSourceManager(sourceManager)
+
+		bundleDir := dest
+		if archive {
+			tmp, err := os.MkdirTemp("", "mirror-")
+			if err != nil {
+				log.Printf("Failed to create temp dir: %v", err)
+				os.Exit(1)
+			}
+			defer os.RemoveAll(tmp)
+			bundleDir = filepath.Join(tmp, sourceID)
+		}
+
+		bundle, err := sourceManager.ExportMirror(dogeboxd.MirrorExportOptions{
+			SourceID: sourceID,
+			Pups:     pups,
+			Dest:     bundleDir,
+		})
+		if err != nil {
+			log.Printf("Failed to export mirror: %v", err)
+			os.Exit(1)
+		}
+
+		if closures {
+			config := dogeboxd.ServerConfig{DataDir: dataDir}
+			nixManager := nix.NewNixManager(config, nil, system.NewDBXRoot())
+
+			if err := nixManager.ExportMirrorClosures(bundleDir, dogeboxd.NewConsoleSubLogger("internal", "mirror")); err != nil {
+				log.Printf("Failed to export closures: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		if archive {
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Printf("Failed to create archive: %v", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			if err := utils.WriteTarGz(bundleDir, f, nil); err != nil {
+				log.Printf("Failed to write archive: %v", err)
+				os.Remove(dest)
+				os.Exit(1)
+			}
+		}
+
+		log.Printf("Exported %d pups from %s to %s", len(bundle.Pups), sourceID, dest)
+	},
+}
+
+var sourceMirrorImportCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Import a mirror bundle, a directory or .tar.gz",
+	Long: `Copy a mirror bundle into the data dir. dogeboxd adds it as a
+source the next time it starts, and uses any nix closures in it when
+installing pups. To import into a running dogeboxd, use the
+POST /source/mirror/import API instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dataDir, _ := cmd.Flags().GetString("data-dir")
+
+		bundle, err := source.StageMirror(dataDir, args[0])
+		if err != nil {
+			log.Printf("Failed to import mirror: %v", err)
+			os.Exit(1)
+		}
+
+		log.Printf("Imported mirror %s with %d pups, restart dogeboxd to use it.", bundle.ID, len(bundle.Pups))
+	},
+}
+
+func init() {
+	sourceMirrorExportCmd.Flags().StringArray("pup", []string{}, "pup to include as name or name@version, repeatable (default: every pup)")
+	sourceMirrorExportCmd.Flags().Bool("closures", false, "build each pup and include its nix closure")
+	sourceMirrorExportCmd.Flags().Bool("archive", false, "write a .tar.gz to dest rather than a directory")
+	sourceMirrorCmd.AddCommand(sourceMirrorExportCmd)
+	sourceMirrorCmd.AddCommand(sourceMirrorImportCmd)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var sourceCmd = &cobra.Command{
+	Use:   "source",
+	Short: "Work with pup sources",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var sourceMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Export and import offline source mirrors",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	sourceCmd.PersistentFlags().StringP("data-dir", "d", "/opt/dogebox", "dogebox data dir")
+	sourceCmd.AddCommand(sourceMirrorCmd)
+	rootCmd.AddCommand(sourceCmd)
+}
@@ -27,6 +27,7 @@ func main() {
 	var forcedRecovery bool
 	var dangerousDevMode bool
 	var disableReflector bool
+	var simulate bool
 
 	flag.IntVar(&port, "port", 8080, "REST API Port")
 	flag.StringVar(&bind, "addr", "127.0.0.1", "Address to bind to")
@@ -39,6 +40,7 @@ func main() {
 	flag.BoolVar(&forcedRecovery, "force-recovery", false, "Force recovery mode")
 	flag.BoolVar(&dangerousDevMode, "danger-dev", false, "Enable dangerous development mode")
 	flag.BoolVar(&disableReflector, "disable-reflector", false, "Disable submitting to reflector")
+	flag.BoolVar(&simulate, "simulate", false, "Simulate nix, systemd and _dbxroot, for development off a real dogebox")
 	flag.BoolVar(&verbose, "v", false, "Be verbose")
 	flag.BoolVar(&help, "h", false, "Get help")
 	flag.Parse()
@@ -109,6 +111,12 @@ func main() {
 		log.Println("********************************************************************************")
 	}
 
+	if simulate {
+		log.Println("********************************************************************************")
+		log.Println("***************************** SIMULATE MODE ************************************")
+		log.Println("********************************************************************************")
+	}
+
 	config := dogeboxd.ServerConfig{
 		Port:             port,
 		Bind:             bind,
@@ -123,6 +131,7 @@ func main() {
 		InternalPort:     internalPort,
 		DevMode:          dangerousDevMode,
 		DisableReflector: disableReflector,
+		Simulate:         simulate,
 	}
 
 	srv := Server(stateManager, store, config)
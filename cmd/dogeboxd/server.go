@@ -13,6 +13,7 @@ import (
 	"github.com/dogeorg/dogeboxd/pkg/system/lifecycle"
 	"github.com/dogeorg/dogeboxd/pkg/system/network"
 	"github.com/dogeorg/dogeboxd/pkg/system/nix"
+	"github.com/dogeorg/dogeboxd/pkg/system/simulated"
 	"github.com/dogeorg/dogeboxd/pkg/web"
 )
 
@@ -33,8 +34,35 @@ func Server(sm dogeboxd.StateManager, store *dogeboxd.StoreManager, config dogeb
 	}
 }
 
+// The real and simulated SystemMonitors both need to run as services.
+type systemMonitorService interface {
+	dogeboxd.SystemMonitor
+	conductor.Service
+}
+
 func (t server) Start() {
-	systemMonitor := system.NewSystemMonitor(t.config)
+	var (
+		systemMonitor    systemMonitorService
+		journalReader    dogeboxd.JournalReader
+		lifecycleManager dogeboxd.LifecycleManager
+		dbxRoot          dogeboxd.DBXRoot
+		runtime          *simulated.Runtime
+	)
+
+	// In simulate mode, nothing touches systemd, nix or _dbxroot,
+	// so we can run anywhere without a NixOS host underneath us.
+	if t.config.Simulate {
+		runtime = simulated.NewRuntime(t.config)
+		systemMonitor = simulated.NewSystemMonitor(runtime)
+		journalReader = simulated.NewJournalReader()
+		lifecycleManager = simulated.NewLifecycleManager()
+		dbxRoot = simulated.NewDBXRoot(t.config, runtime)
+	} else {
+		systemMonitor = system.NewSystemMonitor(t.config)
+		journalReader = system.NewJournalReader(t.config)
+		lifecycleManager = lifecycle.NewLifecycleManager(t.config)
+		dbxRoot = system.NewDBXRoot()
+	}
 
 	pups, err := pup.NewPupManager(t.config.DataDir, t.config.TmpDir, systemMonitor)
 	if err != nil {
@@ -44,16 +72,14 @@ func (t server) Start() {
 	// Set up a doge key manager connection
 	dkm := dogeboxd.NewDKMManager()
 
-	sourceManager := source.NewSourceManager(t.config, t.sm, pups)
+	sourceManager := source.NewSourceManager(t.config, t.sm, t.store, pups)
 	pups.SetSourceManager(sourceManager)
-	nixManager := nix.NewNixManager(t.config, pups)
+	nixManager := nix.NewNixManager(t.config, pups, dbxRoot)
 
 	// Set up our system interfaces so we can talk to the host OS
 	networkManager := network.NewNetworkManager(nixManager, t.sm)
-	lifecycleManager := lifecycle.NewLifecycleManager(t.config)
 
-	systemUpdater := system.NewSystemUpdater(t.config, networkManager, nixManager, sourceManager, pups, t.sm, dkm)
-	journalReader := system.NewJournalReader(t.config)
+	systemUpdater := system.NewSystemUpdater(t.config, networkManager, nixManager, sourceManager, pups, t.sm, dkm, dbxRoot)
 	logtailer := system.NewLogTailer(t.config)
 
 	/* ----------------------------------------------------------------------- */
@@ -79,8 +105,9 @@ func (t server) Start() {
 
 	wsh := web.NewWSRelay(t.config, dbx.Changes)
 	adminRouter := web.NewAdminRouter(t.config, pups)
-	rest := web.RESTAPI(t.config, t.sm, dbx, pups, sourceManager, lifecycleManager, nixManager, dkm, wsh)
-	internalRouter := web.NewInternalRouter(t.config, dbx, pups, dkm)
+	routerLog := web.NewRouterLog()
+	rest := web.RESTAPI(t.config, t.sm, dbx, pups, sourceManager, lifecycleManager, nixManager, dkm, wsh, routerLog)
+	internalRouter := web.NewInternalRouter(t.config, dbx, pups, dkm, routerLog)
 	ui := dogeboxd.ServeUI(t.config)
 
 	/* ----------------------------------------------------------------------- */
@@ -110,6 +137,12 @@ func (t server) Start() {
 		c.Service("Pup Manager", pups)
 		c.Service("Internal Router", internalRouter)
 		c.Service("Admin Router", adminRouter)
+		c.Service("Garbage Collection Scheduler", system.NewGarbageCollectionScheduler(t.sm, dbx))
+		c.Service("Source Refresher", source.NewSourceRefresher(sourceManager, t.sm, dbx))
+	}
+
+	if t.config.Simulate {
+		c.Service("Simulated Runtime", runtime)
 	}
 
 	// c.Service("Watcher", NewWatcher(t.state, t.config.PupDir))
@@ -19,7 +19,9 @@ type SubLogger interface {
 	Err(msg string)
 	Errf(msg string, a ...any)
 	Progress(p int) SubLogger
+	BuildProgress(p *NixBuildProgress) SubLogger // nil once the build is finished
 	LogCmd(cmd *exec.Cmd)
+	JobID() string // empty if not logging on behalf of a job
 }
 
 type actionLogger struct {
@@ -48,7 +50,7 @@ func (t *actionLogger) Progress(p int) *actionLogger {
 func (t *actionLogger) Step(step string) *stepLogger {
 	s, ok := t.Steps[step]
 	if !ok {
-		t.Steps[step] = &stepLogger{t, step, 0, time.Now()}
+		t.Steps[step] = &stepLogger{t, step, 0, nil, time.Now()}
 		s = t.Steps[step]
 	}
 	return s
@@ -58,6 +60,7 @@ type stepLogger struct {
 	l        *actionLogger
 	step     string
 	progress int
+	build    *NixBuildProgress
 	start    time.Time
 }
 
@@ -70,6 +73,7 @@ func (t *stepLogger) log(msg string, err bool) {
 		Msg:       msg,
 		Error:     err,
 		StepTaken: time.Since(t.start),
+		Build:     t.build,
 	}
 	symbol := "✔️"
 	if p.Error {
@@ -84,6 +88,11 @@ func (t *stepLogger) Progress(p int) SubLogger {
 	return t
 }
 
+func (t *stepLogger) BuildProgress(p *NixBuildProgress) SubLogger {
+	t.build = p
+	return t
+}
+
 func (t *stepLogger) Log(msg string) {
 	t.log(msg, false)
 }
@@ -100,6 +109,10 @@ func (t *stepLogger) Errf(msg string, a ...any) {
 	t.log(fmt.Sprintf(msg, a...), true)
 }
 
+func (t *stepLogger) JobID() string {
+	return t.l.Job.ID
+}
+
 func (t *stepLogger) LogCmd(cmd *exec.Cmd) {
 	cmd.Stdout = NewLineWriter(func(s string) {
 		t.log(s, false)
@@ -141,6 +154,10 @@ func (t *ConsoleSubLogger) Progress(p int) SubLogger {
 	return t
 }
 
+func (t *ConsoleSubLogger) BuildProgress(p *NixBuildProgress) SubLogger {
+	return t
+}
+
 func (t *ConsoleSubLogger) Log(msg string) {
 	t.log(msg, false)
 }
@@ -157,6 +174,10 @@ func (t *ConsoleSubLogger) Errf(msg string, a ...any) {
 	t.log(fmt.Sprintf(msg, a...), true)
 }
 
+func (t *ConsoleSubLogger) JobID() string {
+	return ""
+}
+
 func (t *ConsoleSubLogger) LogCmd(cmd *exec.Cmd) {
 	cmd.Stdout = NewLineWriter(func(s string) {
 		t.log(s, false)
@@ -14,6 +14,7 @@ type ServerConfig struct {
 	UiPort           int
 	DevMode          bool
 	DisableReflector bool
+	Simulate         bool
 }
 
 func GetSystemEnvironmentVariablesForContainer() map[string]string {
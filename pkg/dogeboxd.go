@@ -246,7 +246,7 @@ func (t Dogeboxd) jobDispatcher(j Job) {
 
 	// System actions
 	case InstallPup:
-		t.createPupFromManifest(j, a.PupName, a.PupVersion, a.SourceId)
+		t.createPupFromManifest(j, a.PupName, a.PupVersion, a.SourceId, a.Grants)
 	case UninstallPup:
 		t.sendSystemJobWithPupDetails(j, a.PupID)
 	case PurgePup:
@@ -263,6 +263,12 @@ func (t Dogeboxd) jobDispatcher(j Job) {
 	case UpdatePupProviders:
 		t.updatePupProviders(j, a)
 
+	case UpdatePupGrants:
+		t.updatePupGrants(j, a)
+
+	case UpdatePupRateLimit:
+		t.updatePupRateLimit(j, a)
+
 	case UpdatePupHooks:
 		t.updatePupHooks(j, a)
 
@@ -282,6 +288,27 @@ func (t Dogeboxd) jobDispatcher(j Job) {
 	case RemoveSSHKey:
 		t.enqueue(j)
 
+	case RollbackNixGeneration:
+		t.enqueue(j)
+
+	case WriteCustomNixModule:
+		t.enqueue(j)
+
+	case RemoveCustomNixModule:
+		t.enqueue(j)
+
+	case EnableCustomNixModules:
+		t.enqueue(j)
+
+	case DisableCustomNixModules:
+		t.enqueue(j)
+
+	case CollectGarbage:
+		t.enqueue(j)
+
+	case ExportSourceMirror:
+		t.enqueue(j)
+
 	// Pup router actions
 	case UpdateMetrics:
 		t.Pups.UpdateMetrics(a)
@@ -298,7 +325,7 @@ func (t Dogeboxd) jobDispatcher(j Job) {
 *
 * Future: support multiple pup instances per manifest
  */
-func (t *Dogeboxd) createPupFromManifest(j Job, pupName, pupVersion, sourceId string) {
+func (t *Dogeboxd) createPupFromManifest(j Job, pupName, pupVersion, sourceId string, grants map[string][]string) {
 	// Fetch the correct manifest from the source manager
 	manifest, source, err := t.sources.GetSourceManifest(sourceId, pupName, pupVersion)
 	if err != nil {
@@ -315,6 +342,15 @@ func (t *Dogeboxd) createPupFromManifest(j Job, pupName, pupVersion, sourceId st
 		return
 	}
 
+	// record what the user approved while installing
+	for iface, groups := range grants {
+		if _, err := t.Pups.UpdatePup(pupID, SetPupGrants(iface, groups)); err != nil {
+			j.Err = fmt.Sprintf("Couldn't grant permissions: %s", err)
+			t.sendFinishedJob("action", j)
+			return
+		}
+	}
+
 	// send the job off to the SystemUpdater to install
 	t.sendSystemJobWithPupDetails(j, pupID)
 }
@@ -380,6 +416,54 @@ func (t *Dogeboxd) updatePupProviders(j Job, u UpdatePupProviders) {
 	t.sendFinishedJob("action", j)
 }
 
+/* Handle an UpdatePupGrants action. The internal router checks
+ * grants on every request, but which pups may open TCP connections
+ * to each other is baked into the container config, so regenerate it.
+ */
+func (t *Dogeboxd) updatePupGrants(j Job, u UpdatePupGrants) {
+	log := j.Logger.Step("update grants")
+
+	update := SetPupGrants(u.Interface, u.PermissionGroups)
+	if u.Revoke {
+		update = RevokePupGrants(u.Interface, u.PermissionGroups)
+	}
+
+	pupState, err := t.Pups.UpdatePup(u.PupID, update)
+	if err != nil {
+		j.Err = fmt.Sprintf("Couldnt update: %s", u.PupID)
+		t.sendFinishedJob("action", j)
+		return
+	}
+	j.Success = pupState
+
+	dbxState := t.sm.Get().Dogebox
+
+	nixPatch := t.nix.NewPatch(log)
+	t.nix.UpdateSystemContainerConfiguration(nixPatch)
+	t.nix.UpdateFirewallRules(nixPatch, dbxState)
+
+	if err := nixPatch.Apply(); err != nil {
+		j.Err = fmt.Sprintf("Failed to apply nix patch: %v", err)
+		t.sendFinishedJob("action", j)
+		return
+	}
+
+	t.sendFinishedJob("action", j)
+}
+
+// Handle an UpdatePupRateLimit action, the internal router picks it up on the next request
+func (t *Dogeboxd) updatePupRateLimit(j Job, u UpdatePupRateLimit) {
+	pupState, err := t.Pups.UpdatePup(u.PupID, SetPupRateLimit(u.Interface, u.Limit))
+	if err != nil {
+		j.Err = fmt.Sprintf("Couldnt update: %s", u.PupID)
+		t.sendFinishedJob("action", j)
+		return
+	}
+
+	j.Success = pupState
+	t.sendFinishedJob("action", j)
+}
+
 // Handle an UpdatePupHooks action
 func (t *Dogeboxd) updatePupHooks(j Job, u UpdatePupHooks) {
 	_, err := t.Pups.UpdatePup(u.PupID, SetPupHooks(u.Payload))
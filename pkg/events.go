@@ -38,6 +38,20 @@ type ActionProgress struct {
 	Msg       string        `json:"msg"`        // the message line
 	Error     bool          `json:"error"`      // if this represents an error or not
 	StepTaken time.Duration `json:"step_taken"` // time taken from previous step
+
+	Build *NixBuildProgress `json:"build,omitempty"` // only set while a nix rebuild is running
+}
+
+// Parsed from nix's internal-json log while rebuilding.
+type NixBuildProgress struct {
+	BuildsDone        int    `json:"buildsDone"`
+	BuildsExpected    int    `json:"buildsExpected"`
+	FetchesDone       int    `json:"fetchesDone"`
+	FetchesExpected   int    `json:"fetchesExpected"`
+	BytesDownloaded   int64  `json:"bytesDownloaded"`
+	BytesExpected     int64  `json:"bytesExpected"`
+	CurrentDerivation string `json:"currentDerivation"`
+	ETASeconds        int    `json:"etaSeconds"` // 0 if we can't estimate yet
 }
 
 /* Actions are passed to the dogeboxd service via its
@@ -49,10 +63,12 @@ type Action any
 
 // Install a pup on the system
 type InstallPup struct {
-	PupName      string
-	PupVersion   string
-	SourceId     string
-	SessionToken string
+	PupName             string
+	PupVersion          string
+	SourceId            string
+	SessionToken        string
+	AcceptSourceChanges bool                // install even if the source changed this version since we last installed it
+	Grants              map[string][]string // permission groups the user approved, per interface
 }
 
 // Uninstalling a pup will remove container
@@ -88,6 +104,21 @@ type UpdatePupProviders struct {
 	Payload map[string]string
 }
 
+// Grants or revokes permission groups a pup uses on an interface
+type UpdatePupGrants struct {
+	PupID            string
+	Interface        string
+	PermissionGroups []string // groups to grant or revoke, revoking none revokes all
+	Revoke           bool
+}
+
+// Overrides the rate limits a pup is held to on an interface, nil restores the defaults
+type UpdatePupRateLimit struct {
+	PupID     string
+	Interface string
+	Limit     *PupRateLimit
+}
+
 // Updates hooks for this pup
 type UpdatePupHooks struct {
 	PupID   string
@@ -121,6 +152,41 @@ type RemoveSSHKey struct {
 	ID string
 }
 
+// Restore a previously recorded nix generation and rebuild
+type RollbackNixGeneration struct {
+	Generation int
+}
+
+// Create or replace a custom nix module and rebuild
+type WriteCustomNixModule struct {
+	Name    string
+	Content string
+}
+
+type RemoveCustomNixModule struct {
+	Name string
+}
+
+type (
+	EnableCustomNixModules  struct{}
+	DisableCustomNixModules struct{}
+)
+
+// Delete old NixOS generations and garbage collect the nix store
+type CollectGarbage struct {
+	KeepGenerations int
+	DryRun          bool
+}
+
+// Export a source, or some of its pups, as an offline mirror bundle
+type ExportSourceMirror struct {
+	SourceID string
+	Pups     map[string][]string // pup name to versions, empty for everything
+	Path     string
+	Closures bool // include a nix binary cache of every pup's closure
+	Archive  bool // write a .tar.gz to Path rather than a directory
+}
+
 /* Updates are responses to Actions or simply
 * internal state changes that the frontend needs,
 * these are wrapped in a 'change' and sent via
@@ -134,3 +200,16 @@ type Update any
 type StatsUpdate struct {
 	Stats []PupStats `json:"stats"`
 }
+
+// SourcesUpdate lists what a background source refresh found
+// that wasn't in the store before.
+type SourcesUpdate struct {
+	Sources []SourceChanges `json:"sources"`
+}
+
+type SourceChanges struct {
+	SourceID    string              `json:"sourceId"`
+	NewPups     []string            `json:"newPups"`     // pups not seen from this source before
+	NewVersions map[string][]string `json:"newVersions"` // pup name -> versions not seen before, including new pups
+	Upgrades    map[string]string   `json:"upgrades"`    // installed dev channel pup ID -> the channel's new build
+}
@@ -0,0 +1,107 @@
+package dogeboxd
+
+import (
+	"slices"
+	"sort"
+)
+
+/* Grants are the permission groups a user has approved for a
+ * pup, per interface it depends on. A pup only gets to use a
+ * provider's permission group if it requested the group in its
+ * dependency, the user granted it, and the provider defines it.
+ */
+
+// RequestedPermissionGroups lists the groups a pup's dependencies on iface ask for.
+func (p PupState) RequestedPermissionGroups(iface string) []string {
+	requested := []string{}
+	for _, dep := range p.Manifest.Dependencies {
+		if dep.InterfaceName != iface {
+			continue
+		}
+		for _, name := range dep.PermissionGroups {
+			if !slices.Contains(requested, name) {
+				requested = append(requested, name)
+			}
+		}
+	}
+	return requested
+}
+
+// GrantedPermissionGroups returns the provider's groups for iface that this pup may use.
+func (p PupState) GrantedPermissionGroups(iface string, provider PupState) []PupManifestPermissionGroup {
+	requested := p.RequestedPermissionGroups(iface)
+	granted := p.Grants[iface]
+
+	groups := []PupManifestPermissionGroup{}
+	for _, pg := range provider.Manifest.PermissionGroups(iface) {
+		if slices.Contains(requested, pg.Name) && slices.Contains(granted, pg.Name) {
+			groups = append(groups, pg)
+		}
+	}
+	return groups
+}
+
+// PermissionGroups returns the permission groups the manifest defines for iface.
+func (m PupManifest) PermissionGroups(iface string) []PupManifestPermissionGroup {
+	for _, i := range m.Interfaces {
+		if i.Name == iface {
+			return i.PermissionGroups
+		}
+	}
+	return []PupManifestPermissionGroup{}
+}
+
+// A permission group a pup asks a provider for, as shown to the user.
+type PupPermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Severity    int    `json:"severity"`
+	Granted     bool   `json:"granted"`
+}
+
+type PupPermissionSeverity struct {
+	Severity int                    `json:"severity"` // 1: critical, 2: makes changes, 3: read only
+	Groups   []PupPermissionRequest `json:"groups"`
+}
+
+/* PermissionRequests describes what a pup asks of a provider on
+ * iface, grouped by severity with the most critical first, so the
+ * user can decide what to grant. Requested groups the provider
+ * doesn't define are left out, they can never be used.
+ */
+func (p PupState) PermissionRequests(iface string, provider PupManifest) []PupPermissionSeverity {
+	requested := p.RequestedPermissionGroups(iface)
+
+	bySeverity := map[int][]PupPermissionRequest{}
+	for _, pg := range provider.PermissionGroups(iface) {
+		if !slices.Contains(requested, pg.Name) {
+			continue
+		}
+		bySeverity[pg.Severity] = append(bySeverity[pg.Severity], PupPermissionRequest{
+			Name:        pg.Name,
+			Description: pg.Description,
+			Severity:    pg.Severity,
+			Granted:     slices.Contains(p.Grants[iface], pg.Name),
+		})
+	}
+
+	severities := []PupPermissionSeverity{}
+	for severity, groups := range bySeverity {
+		severities = append(severities, PupPermissionSeverity{Severity: severity, Groups: groups})
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		return severities[i].Severity < severities[j].Severity
+	})
+	return severities
+}
+
+/* GrantAllRequested grants every permission group the pup's
+ * dependencies ask for. Pups installed before grants existed got
+ * everything they requested, this keeps them working.
+ */
+func (p *PupState) GrantAllRequested() {
+	p.Grants = map[string][]string{}
+	for _, dep := range p.Manifest.Dependencies {
+		p.Grants[dep.InterfaceName] = p.RequestedPermissionGroups(dep.InterfaceName)
+	}
+}
@@ -1,6 +1,11 @@
 package dogeboxd
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Masterminds/semver"
+)
 
 /* PupManifest represents a Nix installed process
  * running inside the Dogebox Runtime Environment.
@@ -9,7 +14,8 @@ import "fmt"
 type PupManifest struct {
 	// The version of the actual manifest. This differs from the "version"
 	// of the pup, and the version of the deployed software for this pup.
-	// Valid values: 1
+	// Valid values: 1, 2. Version 2 manifests are checked against
+	// ManifestSchema and held to the stricter rules in StrictIssues.
 	ManifestVersion int                     `json:"manifestVersion"`
 	Meta            PupManifestMeta         `json:"meta"`
 	Config          PupManifestConfigFields `json:"config"`
@@ -20,7 +26,7 @@ type PupManifest struct {
 }
 
 func (m *PupManifest) Validate() error {
-	if m.ManifestVersion != 1 {
+	if m.ManifestVersion != 1 && m.ManifestVersion != 2 {
 		return fmt.Errorf("unknown manifest version: %d", m.ManifestVersion)
 	}
 
@@ -64,9 +70,168 @@ func (m *PupManifest) Validate() error {
 		}
 	}
 
+	if m.ManifestVersion >= 2 {
+		return m.validateV2()
+	}
+
+	return nil
+}
+
+// The config field types dPanel knows how to render.
+var manifestConfigFieldTypes = []string{"text", "password", "textarea", "number", "range", "toggle", "checkbox", "select", "radio", "email", "url", "date"}
+
+func (m *PupManifest) validateV2() error {
+	if issues := m.StrictIssues(); len(issues) > 0 {
+		return fmt.Errorf("%s", issues[0])
+	}
 	return nil
 }
 
+/* StrictIssues lists everything that breaks the rules version 2
+ * manifests are held to, which version 1 only assumed: things
+ * that refer to each other must line up, and versions must
+ * actually be versions. Paths are JSON pointers into the manifest.
+ */
+func (m *PupManifest) StrictIssues() []ManifestIssue {
+	issues := []ManifestIssue{}
+	fail := func(path, format string, args ...any) {
+		issues = append(issues, ManifestIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, err := semver.NewVersion(m.Meta.Version); err != nil {
+		fail("/meta/version", "%q is not a valid semver version", m.Meta.Version)
+	}
+
+	interfaceNames := []string{}
+	for i, iface := range m.Interfaces {
+		path := fmt.Sprintf("/interfaces/%d", i)
+
+		if iface.Name == "" {
+			fail(path+"/name", "interface name is required")
+		} else if slices.Contains(interfaceNames, iface.Name) {
+			fail(path+"/name", "interface %s is declared more than once", iface.Name)
+		}
+		interfaceNames = append(interfaceNames, iface.Name)
+
+		if _, err := semver.NewVersion(iface.Version); err != nil {
+			fail(path+"/version", "%q is not a valid semver version", iface.Version)
+		}
+
+		groups := []string{}
+		for j, group := range iface.PermissionGroups {
+			groupPath := fmt.Sprintf("%s/permissionGroups/%d", path, j)
+
+			if group.Name == "" {
+				fail(groupPath+"/name", "permission group name is required")
+			} else if slices.Contains(groups, group.Name) {
+				fail(groupPath+"/name", "permission group %s is declared more than once", group.Name)
+			}
+			groups = append(groups, group.Name)
+
+			if group.Severity < 1 || group.Severity > 3 {
+				fail(groupPath+"/severity", "must be between 1 and 3")
+			}
+
+			if group.RateLimit.RequestsPerSecond < 0 || group.RateLimit.Burst < 0 || group.RateLimit.MaxConcurrent < 0 {
+				fail(groupPath+"/rateLimit", "rate limits can't be negative")
+			}
+
+			for k, route := range group.Routes {
+				if _, err := ParsePermissionRoute(route); err != nil {
+					fail(fmt.Sprintf("%s/routes/%d", groupPath, k), "%v", err)
+				}
+			}
+		}
+	}
+
+	exposeNames := []string{}
+	exposePorts := map[int]string{}
+	for i, expose := range m.Container.Exposes {
+		path := fmt.Sprintf("/container/exposes/%d", i)
+
+		if slices.Contains(exposeNames, expose.Name) {
+			fail(path+"/name", "expose %s is declared more than once", expose.Name)
+		}
+		exposeNames = append(exposeNames, expose.Name)
+
+		if other, ok := exposePorts[expose.Port]; ok {
+			fail(path+"/port", "exposes %s and %s both use port %d", other, expose.Name, expose.Port)
+		} else {
+			exposePorts[expose.Port] = expose.Name
+		}
+
+		for _, name := range expose.Interfaces {
+			if !slices.Contains(interfaceNames, name) {
+				fail(path+"/interfaces", "refers to interface %s, which this pup doesn't declare", name)
+			}
+		}
+	}
+
+	for i, dep := range m.Dependencies {
+		path := fmt.Sprintf("/dependencies/%d", i)
+
+		if dep.InterfaceName == "" {
+			fail(path+"/interfaceName", "dependency interfaceName is required")
+		}
+		if _, err := semver.NewConstraint(dep.InterfaceVersion); err != nil {
+			fail(path+"/interfaceVersion", "%q is not a valid semver constraint", dep.InterfaceVersion)
+		}
+	}
+
+	sectionNames := []string{}
+	fieldNames := []string{}
+	for i, section := range m.Config.Sections {
+		path := fmt.Sprintf("/config/sections/%d", i)
+
+		if section.Name == "" {
+			fail(path+"/name", "config section name is required")
+		} else if slices.Contains(sectionNames, section.Name) {
+			fail(path+"/name", "config section %s is declared more than once", section.Name)
+		}
+		sectionNames = append(sectionNames, section.Name)
+
+		for j, field := range section.Fields {
+			fieldPath := fmt.Sprintf("%s/fields/%d", path, j)
+
+			// Config values are stored flat, so names must be unique across sections.
+			if field.Name == "" {
+				fail(fieldPath+"/name", "config field name is required")
+			} else if slices.Contains(fieldNames, field.Name) {
+				fail(fieldPath+"/name", "config field %s is declared more than once", field.Name)
+			}
+			fieldNames = append(fieldNames, field.Name)
+
+			if !slices.Contains(manifestConfigFieldTypes, field.Type) {
+				fail(fieldPath+"/type", "unknown config field type %q", field.Type)
+			}
+			if (field.Type == "select" || field.Type == "radio") && len(field.Options) == 0 {
+				fail(fieldPath+"/options", "a %s field needs at least one option", field.Type)
+			}
+			if field.Min != 0 && field.Max != 0 && field.Min > field.Max {
+				fail(fieldPath+"/min", "min is greater than max")
+			}
+		}
+	}
+
+	metricNames := []string{}
+	for i, metric := range m.Metrics {
+		path := fmt.Sprintf("/metrics/%d", i)
+
+		if metric.Name == "" {
+			fail(path+"/name", "metric name is required")
+		} else if slices.Contains(metricNames, metric.Name) {
+			fail(path+"/name", "metric %s is declared more than once", metric.Name)
+		}
+		metricNames = append(metricNames, metric.Name)
+
+		if metric.Type != "string" && metric.Type != "int" && metric.Type != "float" {
+			fail(path+"/type", "must be one of: string, int, float")
+		}
+	}
+
+	return issues
+}
+
 /* PupManifestMeta holds meta information about this pup
  * such as its name, version, any imagery that needs to be shown.
  */
@@ -150,11 +315,23 @@ type PupManifestInterface struct {
  * APIs and resources, via their Dependencies
  */
 type PupManifestPermissionGroup struct {
-	Name        string   `json:"name"`        // ie:  admin, wallet-read-only, etc.
-	Description string   `json:"description"` // What does this permission group do (shown to user)
-	Severity    int      `json:"severity"`    // 1-3, 1: critical/danger, 2: makes changes, 3: read only stuff
-	Routes      []string `json:"routes"`      // http routes accessible for this group
-	Port        int      `json:"port"`        // port accessible for this group
+	Name        string       `json:"name"`        // ie:  admin, wallet-read-only, etc.
+	Description string       `json:"description"` // What does this permission group do (shown to user)
+	Severity    int          `json:"severity"`    // 1-3, 1: critical/danger, 2: makes changes, 3: read only stuff
+	Routes      []string     `json:"routes"`      // http routes accessible for this group
+	Port        int          `json:"port"`        // port accessible for this group
+	RateLimit   PupRateLimit `json:"rateLimit"`   // default limits for pups granted this group
+}
+
+/* PupRateLimit limits how hard a consumer pup may use a provider's
+ * interface through the internal router. Requests refill at
+ * RequestsPerSecond up to Burst at once, and at most MaxConcurrent
+ * may be in flight. Zero means unlimited.
+ */
+type PupRateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"` // defaults to RequestsPerSecond, rounded up
+	MaxConcurrent     int     `json:"maxConcurrent"`
 }
 
 /* Dependency specifies that this pup requires
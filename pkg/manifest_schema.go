@@ -0,0 +1,273 @@
+package dogeboxd
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/* ManifestSchema is the JSON Schema for pup manifests. It is
+ * shipped in the binary so dbx and dogeboxd always agree on
+ * it, and can be written out for editors and CI.
+ */
+//go:embed manifest.schema.json
+var ManifestSchema []byte
+
+// ManifestIssue is one problem found in a manifest, at a JSON pointer into it.
+type ManifestIssue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (i ManifestIssue) String() string {
+	if i.Path == "" {
+		return i.Message
+	}
+	return i.Path + ": " + i.Message
+}
+
+/* ValidateManifestSchema checks raw manifest JSON against
+ * ManifestSchema. It only understands the parts of JSON
+ * Schema that our schema uses: type, enum, required,
+ * properties, additionalProperties, items, minimum, maximum,
+ * minLength, pattern and local $refs.
+ */
+func ValidateManifestSchema(data []byte) ([]ManifestIssue, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(ManifestSchema, &schema); err != nil {
+		return nil, fmt.Errorf("embedded manifest schema is broken: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	v := schemaValidator{root: schema, issues: []ManifestIssue{}}
+	v.validate(schema, doc, "")
+
+	return v.issues, nil
+}
+
+/* ValidateManifestJSON applies the rules that need the raw
+ * manifest rather than the parsed struct. Version 2 manifests
+ * must match the schema exactly, so a typo in a field name is
+ * an error rather than a silently ignored field. Version 1
+ * manifests predate the schema and are not held to it.
+ */
+func ValidateManifestJSON(data []byte, manifest PupManifest) error {
+	if manifest.ManifestVersion < 2 {
+		return nil
+	}
+
+	issues, err := ValidateManifestSchema(data)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) > 0 {
+		messages := []string{}
+		for _, issue := range issues {
+			messages = append(messages, issue.String())
+		}
+		return fmt.Errorf("manifest does not match schema: %s", strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+type schemaValidator struct {
+	root   map[string]any
+	issues []ManifestIssue
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	v.issues = append(v.issues, ManifestIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) resolve(schema map[string]any) map[string]any {
+	ref, ok := schema["$ref"].(string)
+	if !ok {
+		return schema
+	}
+
+	resolved := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		next, ok := resolved[part].(map[string]any)
+		if !ok {
+			return map[string]any{}
+		}
+		resolved = next
+	}
+	return resolved
+}
+
+func (v *schemaValidator) validate(schema map[string]any, value any, path string) {
+	schema = v.resolve(schema)
+
+	if types, ok := schema["type"]; ok && !matchesSchemaType(types, value) {
+		v.fail(path, "expected %s, got %s", describeSchemaType(types), jsonTypeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !schemaEnumContains(enum, value) {
+		options := []string{}
+		for _, e := range enum {
+			options = append(options, fmt.Sprint(e))
+		}
+		v.fail(path, "must be one of: %s", strings.Join(options, ", "))
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		v.validateObject(schema, val, path)
+
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range val {
+				v.validate(items, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len(val)) < minLength {
+			if minLength == 1 {
+				v.fail(path, "must not be empty")
+			} else {
+				v.fail(path, "must be at least %v characters", minLength)
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(val) {
+				v.fail(path, "must match %s", pattern)
+			}
+		}
+
+	case json.Number:
+		n, _ := val.Float64()
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			v.fail(path, "must be at least %v", minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && n > maximum {
+			v.fail(path, "must be at most %v", maximum)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]any, obj map[string]any, path string) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := obj[name]; !present {
+				v.fail(path+"/"+name, "is required")
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+
+	// Walk keys in order so issues come out the same every time.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if prop, ok := properties[k].(map[string]any); ok {
+			v.validate(prop, obj[k], path+"/"+k)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path+"/"+k, "unknown field")
+			}
+		case map[string]any:
+			v.validate(additional, obj[k], path+"/"+k)
+		}
+	}
+}
+
+func matchesSchemaType(types any, value any) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleSchemaType(t, value)
+	case []any:
+		for _, one := range t {
+			if s, ok := one.(string); ok && matchesSingleSchemaType(s, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesSingleSchemaType(t string, value any) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	}
+	return jsonTypeName(value) == t
+}
+
+func describeSchemaType(types any) string {
+	if list, ok := types.([]any); ok {
+		names := []string{}
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func schemaEnumContains(enum []any, value any) bool {
+	for _, e := range enum {
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil && e == f {
+				return true
+			}
+			continue
+		}
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
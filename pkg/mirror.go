@@ -0,0 +1,86 @@
+package dogeboxd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/* A mirror bundle is a self-contained copy of some or all of
+ * a source, for boxes that can't reach it. It is laid out as
+ * a disk source (dogebox.json plus one directory per pup
+ * version), with mirror.json describing where it came from
+ * and, optionally, a nix binary cache of the pups' closures.
+ */
+const (
+	MIRROR_BUNDLE_FILE   = "mirror.json"
+	MIRROR_NIX_CACHE_DIR = "nix-cache"
+)
+
+type MirrorBundle struct {
+	ID             string            `json:"id"` // the source ID the bundle registers as
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	SourceID       string            `json:"sourceId"` // the source it was exported from
+	SourceLocation string            `json:"sourceLocation"`
+	Created        time.Time         `json:"created"`
+	Pups           []MirrorBundlePup `json:"pups"`
+	// Carried over from the exported source, so signed pups
+	// are still checked on the box the bundle is imported on.
+	SigningKeys     []string `json:"signingKeys,omitempty"`
+	SignaturePolicy string   `json:"signaturePolicy,omitempty"`
+	// Set once closures have been copied into MIRROR_NIX_CACHE_DIR,
+	// along with the key they were signed with.
+	NixCache     bool   `json:"nixCache"`
+	NixPublicKey string `json:"nixPublicKey,omitempty"`
+}
+
+type MirrorBundlePup struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version"`
+	Location   string   `json:"location"` // directory within the bundle
+	StorePaths []string `json:"storePaths,omitempty"`
+}
+
+type MirrorExportOptions struct {
+	SourceID string
+	// Pup name to the versions to include. Empty includes every
+	// pup, and an empty version list includes every version.
+	Pups map[string][]string
+	// Directory to write the bundle to, it must not exist yet.
+	Dest string
+}
+
+// MirrorsDir is where imported mirror bundles are kept.
+func MirrorsDir(dataDir string) string {
+	return filepath.Join(dataDir, "mirrors")
+}
+
+func ReadMirrorBundle(dir string) (MirrorBundle, error) {
+	content, err := os.ReadFile(filepath.Join(dir, MIRROR_BUNDLE_FILE))
+	if err != nil {
+		return MirrorBundle{}, fmt.Errorf("not a mirror bundle: %w", err)
+	}
+
+	var bundle MirrorBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return MirrorBundle{}, fmt.Errorf("failed to parse %s: %w", MIRROR_BUNDLE_FILE, err)
+	}
+
+	if bundle.ID == "" {
+		return MirrorBundle{}, fmt.Errorf("%s has no id", MIRROR_BUNDLE_FILE)
+	}
+
+	return bundle, nil
+}
+
+func WriteMirrorBundle(dir string, bundle MirrorBundle) error {
+	content, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, MIRROR_BUNDLE_FILE), content, 0644)
+}
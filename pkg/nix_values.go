@@ -0,0 +1,300 @@
+package dogeboxd
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* Every Nix*TemplateValues struct can validate itself before being
+ * rendered into a nix template. The nix templates escape values they
+ * interpolate, but a lot of these values also end up in shell scripts,
+ * iptables rules and systemd units, so we keep them to a strict
+ * character set wherever we can.
+ */
+
+var (
+	nixPupIDPattern       = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	nixServiceNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+	nixSafePathPattern    = regexp.MustCompile(`^/[a-zA-Z0-9._+/-]*$`)
+	nixDevicePattern      = regexp.MustCompile(`^/dev/[a-zA-Z0-9._/-]+$`)
+	nixKeymapPattern      = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	nixInterfacePattern   = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,15}$`)
+	nixEnvKeyPattern      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	nixHostnameLabel      = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	nixWifiHexPSKPattern  = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+	nixCustomModuleName   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$`)
+)
+
+func (v NixPupContainerTemplateValues) Validate() error {
+	if err := validateNixPupID(v.PUP_ID); err != nil {
+		return err
+	}
+
+	for field, path := range map[string]string{
+		"DATA_DIR":          v.DATA_DIR,
+		"CONTAINER_LOG_DIR": v.CONTAINER_LOG_DIR,
+		"STORAGE_PATH":      v.STORAGE_PATH,
+		"PUP_PATH":          v.PUP_PATH,
+		"NIX_FILE":          v.NIX_FILE,
+	} {
+		if err := validateNixPath(field, path); err != nil {
+			return err
+		}
+	}
+
+	if err := validateNixIP("INTERNAL_IP", v.INTERNAL_IP); err != nil {
+		return err
+	}
+
+	for _, port := range v.PUP_PORTS {
+		if err := validateNixPort(port.PORT); err != nil {
+			return err
+		}
+	}
+
+	for _, service := range v.SERVICES {
+		if err := service.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateNixEnv(v.PUP_ENV); err != nil {
+		return err
+	}
+
+	return validateNixEnv(v.GLOBAL_ENV)
+}
+
+func (v NixPupContainerServiceValues) Validate() error {
+	if !nixServiceNamePattern.MatchString(v.NAME) {
+		return fmt.Errorf("invalid service name %q", v.NAME)
+	}
+
+	if v.EXEC == "" || hasControlCharacters(v.EXEC) {
+		return fmt.Errorf("service %s has an invalid exec command", v.NAME)
+	}
+
+	if !strings.HasPrefix(v.CWD, "/") || hasControlCharacters(v.CWD) {
+		return fmt.Errorf("service %s has an invalid working directory %q", v.NAME, v.CWD)
+	}
+
+	return validateNixEnv(v.ENV)
+}
+
+func (v NixSystemContainerConfigTemplateValues) Validate() error {
+	if err := validateNixIP("DOGEBOX_HOST_IP", v.DOGEBOX_HOST_IP); err != nil {
+		return err
+	}
+
+	if _, _, err := net.ParseCIDR(v.DOGEBOX_CONTAINER_CIDR); err != nil {
+		return fmt.Errorf("invalid DOGEBOX_CONTAINER_CIDR %q", v.DOGEBOX_CONTAINER_CIDR)
+	}
+
+	for _, pup := range v.PUPS_REQUIRING_INTERNET {
+		if err := validateNixPupID(pup.PUP_ID); err != nil {
+			return err
+		}
+		if err := validateNixIP("PUP_IP", pup.PUP_IP); err != nil {
+			return err
+		}
+	}
+
+	for _, pup := range v.PUPS_TCP_CONNECTIONS {
+		if err := validateNixPupID(pup.ID); err != nil {
+			return err
+		}
+		if err := validateNixIP("IP", pup.IP); err != nil {
+			return err
+		}
+
+		for _, other := range pup.OTHER_PUPS {
+			if err := validateNixPupID(other.ID); err != nil {
+				return err
+			}
+			if err := validateNixIP("IP", other.IP); err != nil {
+				return err
+			}
+			for _, port := range other.PORTS {
+				if err := validateNixPort(port.PORT); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v NixFirewallTemplateValues) Validate() error {
+	for _, port := range v.PUP_PORTS {
+		if err := validateNixPupID(port.PUP_ID); err != nil {
+			return err
+		}
+		if err := validateNixPort(port.PORT); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v NixSystemTemplateValues) Validate() error {
+	// Hostname and keymap are empty until the user has configured them.
+	if v.SYSTEM_HOSTNAME != "" && !isValidHostname(v.SYSTEM_HOSTNAME) {
+		return fmt.Errorf("invalid hostname %q", v.SYSTEM_HOSTNAME)
+	}
+
+	if v.KEYMAP != "" && !nixKeymapPattern.MatchString(v.KEYMAP) {
+		return fmt.Errorf("invalid keymap %q", v.KEYMAP)
+	}
+
+	for _, key := range v.SSH_KEYS {
+		if !nixPupIDPattern.MatchString(key.ID) {
+			return fmt.Errorf("invalid SSH key id %q", key.ID)
+		}
+		if strings.TrimSpace(key.Key) == "" || hasControlCharacters(key.Key) {
+			return fmt.Errorf("SSH key %s is not a single-line public key", key.ID)
+		}
+	}
+
+	return nil
+}
+
+func (v NixIncludesFileTemplateValues) Validate() error {
+	if err := validateNixPath("NIX_DIR", v.NIX_DIR); err != nil {
+		return err
+	}
+
+	for _, id := range v.PUP_IDS {
+		if err := validateNixPupID(id); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range v.CUSTOM_MODULES {
+		if err := ValidateNixCustomModuleName(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Custom module names become filenames under NixDir/custom.
+func ValidateNixCustomModuleName(name string) error {
+	if !nixCustomModuleName.MatchString(name) {
+		return fmt.Errorf("invalid custom module name %q, must be up to 64 letters, numbers, - or _", name)
+	}
+	return nil
+}
+
+func (v NixNetworkTemplateValues) Validate() error {
+	if !v.USE_ETHERNET && !v.USE_WIRELESS {
+		return nil
+	}
+
+	if !nixInterfacePattern.MatchString(v.INTERFACE) {
+		return fmt.Errorf("invalid network interface %q", v.INTERFACE)
+	}
+
+	if v.USE_ETHERNET {
+		return nil
+	}
+
+	if len(v.WIFI_SSID) == 0 || len(v.WIFI_SSID) > 32 || hasControlCharacters(v.WIFI_SSID) {
+		return fmt.Errorf("wifi SSID must be between 1 and 32 bytes with no control characters")
+	}
+
+	// WPA passphrases are 8-63 printable ASCII characters, or a raw 64 character hex key.
+	if nixWifiHexPSKPattern.MatchString(v.WIFI_PASSWORD) {
+		return nil
+	}
+
+	if len(v.WIFI_PASSWORD) < 8 || len(v.WIFI_PASSWORD) > 63 {
+		return fmt.Errorf("wifi password must be between 8 and 63 characters")
+	}
+
+	for _, r := range v.WIFI_PASSWORD {
+		if r < 0x20 || r > 0x7e {
+			return fmt.Errorf("wifi password must only contain printable ASCII characters")
+		}
+	}
+
+	return nil
+}
+
+func (v NixStorageOverlayTemplateValues) Validate() error {
+	if !nixDevicePattern.MatchString(v.STORAGE_DEVICE) {
+		return fmt.Errorf("invalid storage device %q", v.STORAGE_DEVICE)
+	}
+
+	if err := validateNixPath("DATA_DIR", v.DATA_DIR); err != nil {
+		return err
+	}
+
+	if _, err := strconv.ParseUint(v.DBX_UID, 10, 32); err != nil {
+		return fmt.Errorf("invalid DBX_UID %q", v.DBX_UID)
+	}
+
+	return nil
+}
+
+func validateNixPupID(id string) error {
+	if !nixPupIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid pup id %q", id)
+	}
+	return nil
+}
+
+func validateNixPath(field, path string) error {
+	if !nixSafePathPattern.MatchString(path) {
+		return fmt.Errorf("%s must be an absolute path without spaces or special characters, got %q", field, path)
+	}
+	return nil
+}
+
+func validateNixIP(field, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid %s %q", field, ip)
+	}
+	return nil
+}
+
+func validateNixPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %d", port)
+	}
+	return nil
+}
+
+func validateNixEnv(env []EnvEntry) error {
+	for _, entry := range env {
+		if !nixEnvKeyPattern.MatchString(entry.KEY) {
+			return fmt.Errorf("invalid environment variable name %q", entry.KEY)
+		}
+		if hasControlCharacters(entry.VAL) {
+			return fmt.Errorf("environment variable %s contains control characters", entry.KEY)
+		}
+	}
+	return nil
+}
+
+func isValidHostname(hostname string) bool {
+	if len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !nixHostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasControlCharacters(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) != -1
+}
@@ -75,6 +75,7 @@ func (t PupManager) AdoptPup(m dogeboxd.PupManifest, source dogeboxd.ManifestSou
 		Source:       source.Config(),
 		Manifest:     m,
 		Config:       map[string]string{},
+		Grants:       map[string][]string{}, // nothing until the user approves it
 		Installation: dogeboxd.STATE_INSTALLING,
 		Enabled:      false,
 		NeedsConf:    false, // TODO
@@ -170,6 +171,18 @@ func (t PupManager) indexPup(p *dogeboxd.PupState) {
 			Type:   "float",
 			Values: dogeboxd.NewBuffer[any](30),
 		},
+		{
+			Name:   "Router Requests In",
+			Label:  "Router Requests In",
+			Type:   "int",
+			Values: dogeboxd.NewBuffer[any](30),
+		},
+		{
+			Name:   "Router Requests Out",
+			Label:  "Router Requests Out",
+			Type:   "int",
+			Values: dogeboxd.NewBuffer[any](30),
+		},
 	}
 
 	metrics := []dogeboxd.PupMetrics[any]{}
@@ -196,6 +209,11 @@ func (t PupManager) indexPup(p *dogeboxd.PupState) {
 		Status:        dogeboxd.STATE_STOPPED,
 		SystemMetrics: systemMetrics,
 		Metrics:       metrics,
+		Router: dogeboxd.PupRouterStats{
+			ThrottledBy: map[string]int64{},
+			Served:      map[string]dogeboxd.PupTrafficSummary{},
+			Made:        map[string]dogeboxd.PupTrafficSummary{},
+		},
 	}
 
 	t.state[p.ID] = p
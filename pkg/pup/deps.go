@@ -22,9 +22,11 @@ func (t PupManager) calculateDeps(pupState *dogeboxd.PupState) []dogeboxd.PupDep
 	deps := []dogeboxd.PupDependencyReport{}
 	for _, dep := range pupState.Manifest.Dependencies {
 		report := dogeboxd.PupDependencyReport{
-			Interface: dep.InterfaceName,
-			Version:   dep.InterfaceVersion,
-			Optional:  dep.Optional,
+			Interface:              dep.InterfaceName,
+			Version:                dep.InterfaceVersion,
+			Optional:               dep.Optional,
+			Permissions:            map[string][]dogeboxd.PupPermissionSeverity{},
+			InstallablePermissions: map[string][]dogeboxd.PupPermissionSeverity{},
 		}
 
 		constraint, err := semver.NewConstraint(dep.InterfaceVersion)
@@ -48,6 +50,7 @@ func (t PupManager) calculateDeps(pupState *dogeboxd.PupState) []dogeboxd.PupDep
 				}
 				if iface.Name == dep.InterfaceName && constraint.Check(ver) == true {
 					installed = append(installed, id)
+					report.Permissions[id] = pupState.PermissionRequests(dep.InterfaceName, p.Manifest)
 				}
 			}
 		}
@@ -87,6 +90,7 @@ func (t PupManager) calculateDeps(pupState *dogeboxd.PupState) []dogeboxd.PupDep
 									PupVersion:     p.Version,
 									PupLogoBase64:  p.LogoBase64,
 								})
+								report.InstallablePermissions[p.Name] = pupState.PermissionRequests(dep.InterfaceName, p.Manifest)
 							}
 						}
 					}
@@ -81,7 +81,8 @@ loop:
 		Issues: dogeboxd.PupIssues{
 			DepsNotRunning: depsNotRunning,
 			// TODO: HealthWarnings
-			// TODO: UpdateAvailable
+			// the upgrade may since have been installed
+			UpgradeAvaialble: pup.UpgradeAvailable != "" && pup.UpgradeAvailable != pup.Version,
 		},
 		NeedsConf: !configSet,
 		NeedsDeps: !depsMet,
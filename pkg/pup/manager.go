@@ -38,6 +38,7 @@ type PupManager struct {
 	statsSubscribers  map[chan []dogeboxd.PupStats]bool // listeners for 'PupStats'
 	monitor           dogeboxd.SystemMonitor
 	sourceManager     dogeboxd.SourceManager
+	routerCounts      map[string]*routerCount // requests through the internal router since the last stats tick
 }
 
 func NewPupManager(dataDir string, tmpDir string, monitor dogeboxd.SystemMonitor) (*PupManager, error) {
@@ -61,6 +62,7 @@ func NewPupManager(dataDir string, tmpDir string, monitor dogeboxd.SystemMonitor
 		statsSubscribers:  map[chan []dogeboxd.PupStats]bool{},
 		mu:                &mu,
 		monitor:           monitor,
+		routerCounts:      map[string]*routerCount{},
 	}
 	// load pups from disk
 	err := p.loadPups()
@@ -108,6 +110,8 @@ func (t PupManager) Run(started, stopped chan bool, stop chan context.Context) e
 							continue
 						}
 
+						routerIn, routerOut := t.takeRouterCounts(id)
+
 						for _, m := range s.SystemMetrics {
 							switch m.Name {
 							case "CPU":
@@ -118,6 +122,10 @@ func (t PupManager) Run(started, stopped chan bool, stop chan context.Context) e
 								m.Values.Add(v.MEMPercent)
 							case "DiskUsage":
 								m.Values.Add(float64(0.0)) // TODO
+							case "Router Requests In":
+								m.Values.Add(routerIn)
+							case "Router Requests Out":
+								m.Values.Add(routerOut)
 							}
 						}
 
@@ -255,7 +263,7 @@ func (t PupManager) GetAllFromSource(source dogeboxd.ManifestSourceConfiguration
 	pups := []*dogeboxd.PupState{}
 
 	for _, pup := range t.state {
-		if pup.Source == source {
+		if sameSource(pup.Source, source) {
 			pups = append(pups, pup)
 		}
 	}
@@ -265,13 +273,19 @@ func (t PupManager) GetAllFromSource(source dogeboxd.ManifestSourceConfiguration
 
 func (t PupManager) GetPupFromSource(name string, source dogeboxd.ManifestSourceConfiguration) *dogeboxd.PupState {
 	for _, pup := range t.state {
-		if pup.Source == source && pup.Manifest.Meta.Name == name {
+		if sameSource(pup.Source, source) && pup.Manifest.Meta.Name == name {
 			return pup
 		}
 	}
 	return nil
 }
 
+// Pups keep a copy of their source config from install time, so
+// match on identity rather than settings like signing keys.
+func sameSource(a, b dogeboxd.ManifestSourceConfiguration) bool {
+	return a.ID == b.ID && a.Location == b.Location && a.Type == b.Type
+}
+
 // send pupdates to subscribers
 func (t PupManager) sendPupdate(p dogeboxd.Pupdate) {
 	t.mu.Lock()
@@ -314,6 +328,9 @@ func (t PupManager) GetPupSpecificEnvironmentVariablesForContainer(pupID string)
 	env := map[string]string{
 		"DBX_PUP_ID": pupID,
 		"DBX_PUP_IP": t.state[pupID].IP,
+		// token for the internal router, sent in DBX_PUP_TOKEN_HEADER
+		"DBX_PUP_TOKEN_FILE":   dogeboxd.PUP_TOKEN_CONTAINER_PATH,
+		"DBX_PUP_TOKEN_HEADER": dogeboxd.PUP_TOKEN_HEADER,
 	}
 
 	// Iterate over each of our configured interfaces, and expose the host and port of each
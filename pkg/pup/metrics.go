@@ -2,6 +2,7 @@ package pup
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
@@ -82,3 +83,72 @@ func (t PupManager) addMetricValue(stats *dogeboxd.PupStats, name string, value
 		}
 	}
 }
+
+// Counts a request the internal router throttled, see PupStats.Router
+func (t PupManager) RecordRouterThrottle(providerID, consumerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stats[providerID]
+	if !ok {
+		return
+	}
+	// Stats are read without the lock, so never write to a map already handed out.
+	throttledBy := map[string]int64{}
+	maps.Copy(throttledBy, s.Router.ThrottledBy)
+	throttledBy[consumerID]++
+	s.Router.ThrottledBy = throttledBy
+	s.Router.Throttled++
+}
+
+type routerCount struct {
+	in, out int
+}
+
+// Adds a request through the internal router to the stats of the pups at either end
+func (t PupManager) RecordRouterAccess(a dogeboxd.RouterAccess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.stats[a.ProviderPupID]; ok {
+		s.Router.Served = addTraffic(s.Router.Served, a)
+		t.routerCount(a.ProviderPupID).in++
+	}
+
+	if s, ok := t.stats[a.OriginPupID]; ok {
+		s.Router.Made = addTraffic(s.Router.Made, a)
+		t.routerCount(a.OriginPupID).out++
+	}
+}
+
+// Returns a copy of traffic with a added, see RecordRouterThrottle
+func addTraffic(traffic map[string]dogeboxd.PupTrafficSummary, a dogeboxd.RouterAccess) map[string]dogeboxd.PupTrafficSummary {
+	updated := map[string]dogeboxd.PupTrafficSummary{}
+	maps.Copy(updated, traffic)
+	summary := updated[a.Interface]
+	summary.Add(a)
+	updated[a.Interface] = summary
+	return updated
+}
+
+func (t PupManager) routerCount(pupID string) *routerCount {
+	c, ok := t.routerCounts[pupID]
+	if !ok {
+		c = &routerCount{}
+		t.routerCounts[pupID] = c
+	}
+	return c
+}
+
+// Returns and resets the router requests in and out of a pup since the last call
+func (t PupManager) takeRouterCounts(pupID string) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.routerCounts[pupID]
+	if !ok {
+		return 0, 0
+	}
+	delete(t.routerCounts, pupID)
+	return c.in, c.out
+}
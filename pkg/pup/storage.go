@@ -47,6 +47,14 @@ func (t PupManager) loadPups() error {
 
 		log.Printf("Loaded pup state: %+v", state)
 
+		// Pups from before grants were recorded had every permission
+		// group they requested, keep it that way until the user says
+		// otherwise. This is saved with the pup's next update.
+		if state.Grants == nil {
+			state.GrantAllRequested()
+			log.Printf("Granted pup %s the permission groups it requested: %v", state.ID, state.Grants)
+		}
+
 		// Success! add to index
 		t.indexPup(&state)
 	}
@@ -0,0 +1,44 @@
+package dogeboxd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+/* Every pup is issued a secret token when it is installed, written
+ * to its storage next to its delegated keys. Pups present it in the
+ * PUP_TOKEN_HEADER to the internal router, which only accepts requests
+ * that come from the pup's IP and carry its token. It has its own
+ * header so Authorization is left for the provider, ie: dogecoind's
+ * RPC basic auth. We only keep a hash of it on the PupState.
+ */
+const (
+	PUP_TOKEN_FILE           = "dbx.token"
+	PUP_TOKEN_CONTAINER_PATH = "/storage/" + PUP_TOKEN_FILE
+	PUP_TOKEN_HEADER         = "X-Dbx-Pup-Token"
+)
+
+// NewPupToken returns a new token and the hash to store for it.
+func NewPupToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, HashPupToken(token), nil
+}
+
+func HashPupToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckToken reports whether token is the one issued to this pup.
+func (p PupState) CheckToken(token string) bool {
+	if p.TokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashPupToken(token)), []byte(p.TokenHash)) == 1
+}
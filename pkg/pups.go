@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 )
 
 // Pup states
@@ -32,13 +34,24 @@ const (
 	BROKEN_REASON_STORAGE_CREATION_FAILED      string = "storage_creation_failed"
 	BROKEN_REASON_DELEGATE_KEY_CREATION_FAILED string = "delegate_key_creation_failed"
 	BROKEN_REASON_DELEGATE_KEY_WRITE_FAILED    string = "delegate_key_write_failed"
+	BROKEN_REASON_TOKEN_WRITE_FAILED           string = "token_write_failed"
 	BROKEN_REASON_ENABLE_FAILED                string = "enable_failed"
 	BROKEN_REASON_NIX_APPLY_FAILED             string = "nix_apply_failed"
+	BROKEN_REASON_SIGNATURE_INVALID            string = "signature_invalid"
+	BROKEN_REASON_SOURCE_CHANGED               string = "source_changed"
 )
 
+// Returned (wrapped) when a source requires signed pups and a download isn't.
+var ErrPupSignatureInvalid = errors.New("pup signature verification failed")
+
+// Returned (wrapped) when a source now serves something different for
+// a pup version than what we installed, ie: a git tag was moved.
+var ErrPupSourceChanged = errors.New("pup source changed since it was installed")
+
 const (
 	PUP_CHANGED_INSTALLATION int = iota
 	PUP_ADOPTED                  = iota
+	PUP_CHANGED_GRANTS           = iota
 )
 
 // PupManager Errors
@@ -71,6 +84,8 @@ type PupState struct {
 	Manifest     PupManifest                 `json:"manifest"`
 	Config       map[string]string           `json:"config"`
 	Providers    map[string]string           `json:"providers"`    // providers of interface dependencies
+	Grants       map[string][]string         `json:"grants"`       // permission groups the user approved, per interface
+	RateLimits   map[string]PupRateLimit     `json:"rateLimits"`   // user overrides of permission group rate limits, per interface
 	Hooks        []PupHook                   `json:"hooks"`        // webhooks
 	Installation string                      `json:"installation"` // see table above and constants
 	BrokenReason string                      `json:"brokenReason"` // reason for being in a broken state
@@ -80,6 +95,28 @@ type PupState struct {
 	IP           string                      `json:"ip"`           // Internal IP for this pup
 	Version      string                      `json:"version"`
 	WebUIs       []PupWebUI                  `json:"webUIs"`
+	SourcePin    PupSourcePin                `json:"sourcePin"` // what the source resolved this version to when installed
+	TokenHash    string                      `json:"-"`         // sha256 of the token the pup authenticates to the internal router with
+	// The current build of the dev channel this pup was installed
+	// from, if it isn't the installed one. Set by the source refresher.
+	UpgradeAvailable string `json:"upgradeAvailable,omitempty"`
+}
+
+// PupSourcePin records exactly what was downloaded for a pup version,
+// so we notice if the source later serves something else for it.
+type PupSourcePin struct {
+	Commit         string `json:"commit,omitempty"` // git commit the tag pointed at
+	ManifestSha256 string `json:"manifestSha256,omitempty"`
+}
+
+func (p PupSourcePin) Matches(other PupSourcePin) bool {
+	if p.Commit != "" && other.Commit != "" && p.Commit != other.Commit {
+		return false
+	}
+	if p.ManifestSha256 != "" && other.ManifestSha256 != "" && p.ManifestSha256 != other.ManifestSha256 {
+		return false
+	}
+	return true
 }
 
 // Represents a Web UI exposed port from the manifest
@@ -110,6 +147,49 @@ type PupStats struct {
 	SystemMetrics []PupMetrics[any] `json:"systemMetrics"`
 	Metrics       []PupMetrics[any] `json:"metrics"`
 	Issues        PupIssues         `json:"issues"`
+	Router        PupRouterStats    `json:"router"`
+}
+
+// What the internal router did with requests to and from a pup.
+type PupRouterStats struct {
+	Throttled   int64                        `json:"throttled"`   // requests refused by rate limits
+	ThrottledBy map[string]int64             `json:"throttledBy"` // by consuming pup ID
+	Served      map[string]PupTrafficSummary `json:"served"`      // requests to this pup, by interface
+	Made        map[string]PupTrafficSummary `json:"made"`        // requests from this pup, by interface
+}
+
+type PupTrafficSummary struct {
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"` // denied, or answered with a 4xx/5xx
+	BytesIn   int64   `json:"bytesIn"`
+	BytesOut  int64   `json:"bytesOut"`
+	LatencyMs float64 `json:"latencyMs"` // total, divide by Requests for the average
+}
+
+func (s *PupTrafficSummary) Add(a RouterAccess) {
+	s.Requests++
+	if a.Status >= 400 {
+		s.Errors++
+	}
+	s.BytesIn += a.BytesIn
+	s.BytesOut += a.BytesOut
+	s.LatencyMs += a.LatencyMs
+}
+
+// A request through the internal router.
+type RouterAccess struct {
+	Time            time.Time `json:"time"`
+	OriginIP        string    `json:"originIp"`
+	OriginPupID     string    `json:"originPupId,omitempty"`
+	ProviderPupID   string    `json:"providerPupId,omitempty"`
+	Interface       string    `json:"interface"`
+	PermissionGroup string    `json:"permissionGroup,omitempty"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	Status          int       `json:"status"`
+	LatencyMs       float64   `json:"latencyMs"`
+	BytesIn         int64     `json:"bytesIn"`  // request body
+	BytesOut        int64     `json:"bytesOut"` // response body
 }
 
 type PupLogos struct {
@@ -134,6 +214,11 @@ type PupDependencyReport struct {
 	InstalledProviders    []string                      `json:"installedProviders"`
 	InstallableProviders  []PupManifestDependencySource `json:"InstallableProviders"`
 	DefaultSourceProvider PupManifestDependencySource   `json:"DefaultProvider"`
+	// What the pup asks each provider for, so the user can grant it
+	// when choosing one. Keyed by installed provider ID, and for
+	// installable providers by pup name.
+	Permissions            map[string][]PupPermissionSeverity `json:"permissions"`
+	InstallablePermissions map[string][]PupPermissionSeverity `json:"installablePermissions"`
 }
 
 type PupHealthStateReport struct {
@@ -243,6 +328,12 @@ type PupManager interface {
 	FastPollPup(pupId string)
 
 	GetPupSpecificEnvironmentVariablesForContainer(pupID string) map[string]string
+
+	// RecordRouterThrottle counts a request from consumer to provider the internal router refused.
+	RecordRouterThrottle(providerID, consumerID string)
+
+	// RecordRouterAccess adds a request through the internal router to both pups' stats.
+	RecordRouterAccess(a RouterAccess)
 }
 
 /*****************************************************************************/
@@ -266,6 +357,12 @@ func SetPupBrokenReason(reason string) func(*PupState, *[]Pupdate) {
 	}
 }
 
+func SetPupSourcePin(pin PupSourcePin) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		p.SourcePin = pin
+	}
+}
+
 func SetPupConfig(newFields map[string]string) func(*PupState, *[]Pupdate) {
 	return func(p *PupState, pu *[]Pupdate) {
 		for k, v := range newFields {
@@ -274,6 +371,33 @@ func SetPupConfig(newFields map[string]string) func(*PupState, *[]Pupdate) {
 	}
 }
 
+func SetPupUpgradeAvailable(version string) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		p.UpgradeAvailable = version
+	}
+}
+
+func SetPupTokenHash(hash string) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		p.TokenHash = hash
+	}
+}
+
+// Overrides the rate limits for iface, or goes back to the defaults if limit is nil.
+func SetPupRateLimit(iface string, limit *PupRateLimit) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		if p.RateLimits == nil {
+			p.RateLimits = make(map[string]PupRateLimit)
+		}
+
+		if limit == nil {
+			delete(p.RateLimits, iface)
+			return
+		}
+		p.RateLimits[iface] = *limit
+	}
+}
+
 func SetPupProviders(newProviders map[string]string) func(*PupState, *[]Pupdate) {
 	return func(p *PupState, pu *[]Pupdate) {
 		if p.Providers == nil {
@@ -286,6 +410,53 @@ func SetPupProviders(newProviders map[string]string) func(*PupState, *[]Pupdate)
 	}
 }
 
+// Grants permission groups on iface, on top of any already granted.
+func SetPupGrants(iface string, groups []string) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		if p.Grants == nil {
+			p.Grants = make(map[string][]string)
+		}
+
+		granted := p.Grants[iface]
+		for _, g := range groups {
+			if !slices.Contains(granted, g) {
+				granted = append(granted, g)
+			}
+		}
+		p.Grants[iface] = granted
+		*pu = append(*pu, Pupdate{
+			ID:    p.ID,
+			Event: PUP_CHANGED_GRANTS,
+			State: *p,
+		})
+	}
+}
+
+// Revokes permission groups on iface, or all of them if groups is empty.
+func RevokePupGrants(iface string, groups []string) func(*PupState, *[]Pupdate) {
+	return func(p *PupState, pu *[]Pupdate) {
+		if p.Grants == nil {
+			p.Grants = make(map[string][]string)
+		}
+
+		// Keep the (empty) entry, a nil Grants means the pup predates grants.
+		granted := []string{}
+		if len(groups) > 0 {
+			for _, g := range p.Grants[iface] {
+				if !slices.Contains(groups, g) {
+					granted = append(granted, g)
+				}
+			}
+		}
+		p.Grants[iface] = granted
+		*pu = append(*pu, Pupdate{
+			ID:    p.ID,
+			Event: PUP_CHANGED_GRANTS,
+			State: *p,
+		})
+	}
+}
+
 func PupEnabled(b bool) func(*PupState, *[]Pupdate) {
 	return func(p *PupState, pu *[]Pupdate) {
 		p.Enabled = b
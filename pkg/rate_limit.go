@@ -0,0 +1,33 @@
+package dogeboxd
+
+/* RateLimit is what the internal router holds this pup to when
+ * using iface on provider: the user's override if there is one,
+ * otherwise the most generous defaults of the permission groups
+ * the pup has been granted.
+ */
+func (p PupState) RateLimit(iface string, provider PupState) PupRateLimit {
+	if limit, ok := p.RateLimits[iface]; ok {
+		return limit
+	}
+
+	groups := p.GrantedPermissionGroups(iface, provider)
+	if len(groups) == 0 {
+		return PupRateLimit{}
+	}
+
+	limit := groups[0].RateLimit
+	for _, pg := range groups[1:] {
+		limit.RequestsPerSecond = mostGenerous(limit.RequestsPerSecond, pg.RateLimit.RequestsPerSecond)
+		limit.Burst = mostGenerous(limit.Burst, pg.RateLimit.Burst)
+		limit.MaxConcurrent = mostGenerous(limit.MaxConcurrent, pg.RateLimit.MaxConcurrent)
+	}
+	return limit
+}
+
+// zero is unlimited, so it wins
+func mostGenerous[T int | float64](a, b T) T {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
@@ -0,0 +1,137 @@
+package dogeboxd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/* A PermissionRoute is a compiled permission group route. Routes
+ * are a path with an optional method in front, ie:
+ *
+ *   /status               exactly /status, any method
+ *   GET /wallet/*         one segment after /wallet, GET (or HEAD) only
+ *   POST /wallet/{id}/tx  a named segment
+ *   /explorer/**          /explorer and anything below it
+ *
+ * `*` and `{name}` match exactly one segment, `**` matches any
+ * number of segments (including none) and must come last. Query
+ * strings and trailing slashes are ignored on both sides.
+ */
+type PermissionRoute struct {
+	Route    string   // as written in the manifest
+	Method   string   // empty matches any method
+	segments []string // literals, "*" or "{name}"
+	rest     bool     // ends in "**"
+}
+
+var permissionRouteMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+func ParsePermissionRoute(route string) (PermissionRoute, error) {
+	r := PermissionRoute{Route: route}
+
+	path := strings.TrimSpace(route)
+	if method, p, ok := strings.Cut(path, " "); ok {
+		r.Method = strings.ToUpper(method)
+		path = strings.TrimSpace(p)
+		if !slices.Contains(permissionRouteMethods, r.Method) {
+			return PermissionRoute{}, fmt.Errorf("route %q: unknown method %s", route, method)
+		}
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return PermissionRoute{}, fmt.Errorf("route %q: path must start with /", route)
+	}
+
+	if HasDotSegments(path) {
+		return PermissionRoute{}, fmt.Errorf("route %q: . and .. segments aren't allowed", route)
+	}
+
+	names := []string{}
+	segments := splitRoutePath(path)
+	for i, segment := range segments {
+		switch {
+		case segment == "**":
+			if i != len(segments)-1 {
+				return PermissionRoute{}, fmt.Errorf("route %q: ** must be the last segment", route)
+			}
+			r.rest = true
+			continue
+
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name := segment[1 : len(segment)-1]
+			if name == "" || strings.ContainsAny(name, "*{}") {
+				return PermissionRoute{}, fmt.Errorf("route %q: bad parameter name %q", route, name)
+			}
+			if slices.Contains(names, name) {
+				return PermissionRoute{}, fmt.Errorf("route %q: parameter %s is used more than once", route, name)
+			}
+			names = append(names, name)
+
+		case segment != "*" && strings.ContainsAny(segment, "*{}"):
+			return PermissionRoute{}, fmt.Errorf("route %q: wildcards and parameters must be a whole segment", route)
+		}
+
+		r.segments = append(r.segments, segment)
+	}
+
+	return r, nil
+}
+
+/* Match reports whether a request matches the route, and the
+ * values of any named parameters.
+ */
+func (r PermissionRoute) Match(method, path string) (map[string]string, bool) {
+	if r.Method != "" && r.Method != method && !(r.Method == "GET" && method == "HEAD") {
+		return nil, false
+	}
+
+	// The provider may resolve these, so /public/../admin isn't under /public.
+	if HasDotSegments(path) {
+		return nil, false
+	}
+
+	pathSegments := splitRoutePath(path)
+	if len(pathSegments) < len(r.segments) || (!r.rest && len(pathSegments) != len(r.segments)) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, segment := range r.segments {
+		switch {
+		case segment == "*":
+		case strings.HasPrefix(segment, "{"):
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+		case segment != pathSegments[i]:
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+/* HasDotSegments reports whether a (decoded) path has . or ..
+ * segments. Requests with them are refused rather than cleaned, a
+ * well behaved client never sends them.
+ */
+func HasDotSegments(path string) bool {
+	for _, segment := range splitRoutePath(path) {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRoutePath drops any query string and empty segments, so "/a//b/?c" is [a b].
+func splitRoutePath(path string) []string {
+	path, _, _ = strings.Cut(path, "?")
+
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,118 @@
+package dogeboxd
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParsePermissionRoute(t *testing.T) {
+	tests := []struct {
+		route  string
+		method string
+		ok     bool
+	}{
+		{"/", "", true},
+		{"/status", "", true},
+		{"GET /wallet/*", "GET", true},
+		{"post /wallet/{id}/tx", "POST", true},
+		{"/explorer/**", "", true},
+		{"/a/*/b/*", "", true},
+		{"/a?verbose=1", "", true},
+		{"", "", false},
+		{"status", "", false},
+		{"FETCH /status", "", false},
+		{"/a/**/b", "", false},
+		{"/a/{}", "", false},
+		{"/a/{id}/{id}", "", false},
+		{"/a/x*", "", false},
+		{"/a/{id}x", "", false},
+		{"/public/../admin", "", false},
+		{"GET /./status", "GET", false},
+	}
+
+	for _, tt := range tests {
+		r, err := ParsePermissionRoute(tt.route)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParsePermissionRoute(%q) error = %v, want ok = %v", tt.route, err, tt.ok)
+			continue
+		}
+		if tt.ok && r.Method != tt.method {
+			t.Errorf("ParsePermissionRoute(%q) method = %q, want %q", tt.route, r.Method, tt.method)
+		}
+	}
+}
+
+func TestPermissionRouteMatch(t *testing.T) {
+	tests := []struct {
+		route  string
+		method string
+		path   string
+		match  bool
+		params map[string]string
+	}{
+		// literals
+		{"/status", "GET", "/status", true, map[string]string{}},
+		{"/status", "POST", "/status/", true, map[string]string{}},
+		{"/status", "GET", "/status/extra", false, nil},
+		{"/status", "GET", "/", false, nil},
+		{"/", "GET", "/", true, map[string]string{}},
+		{"/a/b", "GET", "/a/c", false, nil},
+
+		// methods
+		{"GET /status", "GET", "/status", true, map[string]string{}},
+		{"GET /status", "HEAD", "/status", true, map[string]string{}},
+		{"GET /status", "POST", "/status", false, nil},
+		{"POST /status", "GET", "/status", false, nil},
+
+		// single segment wildcards
+		{"/wallet/*", "GET", "/wallet/abc", true, map[string]string{}},
+		{"/wallet/*", "GET", "/wallet", false, nil},
+		{"/wallet/*", "GET", "/wallet/abc/def", false, nil},
+		{"/wallet/*", "GET", "/wallet/abc/def/ghi", false, nil},
+		{"/a/*/b/*", "GET", "/a/1/b/2", true, map[string]string{}},
+		{"/a/*/b/*", "GET", "/a/1/c/2", false, nil},
+
+		// named parameters
+		{"/wallet/{id}/tx", "POST", "/wallet/w1/tx", true, map[string]string{"id": "w1"}},
+		{"/wallet/{id}/tx/{tx}", "GET", "/wallet/w1/tx/t2", true, map[string]string{"id": "w1", "tx": "t2"}},
+		{"/wallet/{id}/tx", "POST", "/wallet/tx", false, nil},
+
+		// trailing wildcards
+		{"/explorer/**", "GET", "/explorer", true, map[string]string{}},
+		{"/explorer/**", "GET", "/explorer/block/1/txs", true, map[string]string{}},
+		{"/explorer/**", "GET", "/explorers", false, nil},
+		{"/**", "DELETE", "/anything/at/all", true, map[string]string{}},
+		{"/wallet/{id}/**", "GET", "/wallet/w1/a/b", true, map[string]string{"id": "w1"}},
+
+		// queries and stray slashes
+		{"/status", "GET", "/status?verbose=1", true, map[string]string{}},
+		{"/status?verbose=1", "GET", "/status", true, map[string]string{}},
+		{"/a/b", "GET", "//a//b/", true, map[string]string{}},
+
+		// traversal, paths arrive decoded so ..%2F is ../
+		{"/public/**", "GET", "/public/../admin/secret", false, nil},
+		{"/public/**", "GET", "/public/./secret", false, nil},
+		{"/public/*", "GET", "/public/..", false, nil},
+		{"/public/{file}", "GET", "/public/../admin", false, nil},
+		{"/public/**", "GET", "/public/x/../../admin", false, nil},
+		{"/public/*", "GET", "/public/a/b", false, nil}, // a%2Fb
+		{"/public/**", "GET", "/public/..?x=1", false, nil},
+		{"/public/**", "GET", "/public/..foo/bar", true, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		r, err := ParsePermissionRoute(tt.route)
+		if err != nil {
+			t.Fatalf("ParsePermissionRoute(%q): %v", tt.route, err)
+		}
+
+		params, ok := r.Match(tt.method, tt.path)
+		if ok != tt.match {
+			t.Errorf("%q.Match(%s %s) = %v, want %v", tt.route, tt.method, tt.path, ok, tt.match)
+			continue
+		}
+		if ok && !maps.Equal(params, tt.params) {
+			t.Errorf("%q.Match(%s %s) params = %v, want %v", tt.route, tt.method, tt.path, params, tt.params)
+		}
+	}
+}
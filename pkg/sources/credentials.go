@@ -0,0 +1,281 @@
+package source
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// The key that source credential secrets are encrypted with. It lives
+// outside the database, so a copy of dogebox.db alone doesn't leak them.
+const sourceCredentialKeyFile = "source-credentials.key"
+
+// SourceCredential is persisted with its secret (an ssh private
+// key or an access token) encrypted.
+type SourceCredential struct {
+	ID        string
+	Type      string
+	PublicKey string
+	Username  string
+	Secret    []byte
+	Created   time.Time
+	// Host keys seen on first use of an ssh credential, by host:port.
+	HostKeys map[string]string
+}
+
+func (c SourceCredential) Info() dogeboxd.SourceCredentialInfo {
+	return dogeboxd.SourceCredentialInfo{
+		ID:        c.ID,
+		Type:      c.Type,
+		PublicKey: c.PublicKey,
+		Username:  c.Username,
+		Created:   c.Created,
+	}
+}
+
+type credentialStore struct {
+	store *dogeboxd.TypeStore[SourceCredential]
+	key   []byte
+	mu    sync.Mutex
+}
+
+func newCredentialStore(config dogeboxd.ServerConfig, store *dogeboxd.StoreManager) (*credentialStore, error) {
+	key, err := loadOrCreateCredentialKey(filepath.Join(config.DataDir, sourceCredentialKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialStore{
+		store: dogeboxd.GetTypeStore[SourceCredential](store),
+		key:   key,
+	}, nil
+}
+
+func loadOrCreateCredentialKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s is not a valid key", path)
+		}
+		return key, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (t *credentialStore) seal(id string, plaintext []byte) ([]byte, error) {
+	gcm, err := t.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The ID is authenticated too, so secrets can't be swapped between credentials.
+	return gcm.Seal(nonce, nonce, plaintext, []byte(id)), nil
+}
+
+func (t *credentialStore) open(id string, ciphertext []byte) ([]byte, error) {
+	gcm, err := t.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("credential %s is corrupt", id)
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential %s: %w", id, err)
+	}
+
+	return plaintext, nil
+}
+
+func (t *credentialStore) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(t.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (t *credentialStore) create(credentialType, username, token string) (SourceCredential, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return SourceCredential{}, err
+	}
+
+	credential := SourceCredential{
+		ID:       fmt.Sprintf("%x", b),
+		Type:     credentialType,
+		Created:  time.Now(),
+		HostKeys: map[string]string{},
+	}
+
+	var secret []byte
+
+	switch credentialType {
+	case dogeboxd.SOURCE_CREDENTIAL_SSH:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SourceCredential{}, err
+		}
+
+		comment := "dogebox-source-" + credential.ID
+		block, err := ssh.MarshalPrivateKey(priv, comment)
+		if err != nil {
+			return SourceCredential{}, err
+		}
+		secret = pem.EncodeToMemory(block)
+
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			return SourceCredential{}, err
+		}
+		credential.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+
+	case dogeboxd.SOURCE_CREDENTIAL_TOKEN:
+		if token == "" {
+			return SourceCredential{}, fmt.Errorf("a token is required")
+		}
+		credential.Username = username
+		secret = []byte(token)
+
+	default:
+		return SourceCredential{}, fmt.Errorf("unknown credential type %q", credentialType)
+	}
+
+	sealed, err := t.seal(credential.ID, secret)
+	if err != nil {
+		return SourceCredential{}, err
+	}
+	credential.Secret = sealed
+
+	if err := t.store.Set(credential.ID, credential); err != nil {
+		return SourceCredential{}, err
+	}
+
+	return credential, nil
+}
+
+func (t *credentialStore) get(id string) (SourceCredential, error) {
+	credential, err := t.store.Get(id)
+	if err != nil {
+		return SourceCredential{}, fmt.Errorf("no source credential %s", id)
+	}
+	return credential, nil
+}
+
+func (t *credentialStore) list() ([]SourceCredential, error) {
+	return t.store.Exec(fmt.Sprintf("SELECT value FROM %s", t.store.Table))
+}
+
+/* auth builds a go-git AuthMethod for a credential. SSH host keys
+ * are trusted on first use and pinned in the credential, as there
+ * is no known_hosts for dogeboxd to lean on.
+ */
+func (t *credentialStore) auth(id string) (transport.AuthMethod, error) {
+	credential, err := t.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := t.open(credential.ID, credential.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch credential.Type {
+	case dogeboxd.SOURCE_CREDENTIAL_SSH:
+		auth, err := gitssh.NewPublicKeys("git", secret, "")
+		if err != nil {
+			return nil, err
+		}
+		auth.HostKeyCallback = t.hostKeyCallback(credential.ID)
+		return auth, nil
+
+	case dogeboxd.SOURCE_CREDENTIAL_TOKEN:
+		// Most forges ignore the username for tokens, but it can't be empty.
+		username := credential.Username
+		if username == "" {
+			username = "dogebox"
+		}
+		return &githttp.BasicAuth{Username: username, Password: string(secret)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown credential type %q", credential.Type)
+}
+
+func (t *credentialStore) hostKeyCallback(id string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		credential, err := t.get(id)
+		if err != nil {
+			return err
+		}
+
+		seen := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+		if known, ok := credential.HostKeys[hostname]; ok {
+			if known != seen {
+				return fmt.Errorf("host key for %s has changed, refusing to connect", hostname)
+			}
+			return nil
+		}
+
+		if credential.HostKeys == nil {
+			credential.HostKeys = map[string]string{}
+		}
+		credential.HostKeys[hostname] = seen
+
+		log.Printf("Trusting host key %s for %s on first use", ssh.FingerprintSHA256(key), hostname)
+		return t.store.Set(credential.ID, credential)
+	}
+}
+
+func (t *credentialStore) delete(id string) error {
+	if _, err := t.get(id); err != nil {
+		return err
+	}
+	return t.store.Del(id)
+}
+
+var errNoCredentialStore = errors.New("source credentials are not available")
@@ -108,6 +108,16 @@ outer:
 			return dogeboxd.ManifestSourceList{}, fmt.Errorf("manifest validation failed: %w", err)
 		}
 
+		if err := dogeboxd.ValidateManifestJSON(manifestData, manifest); err != nil {
+			return dogeboxd.ManifestSourceList{}, err
+		}
+
+		signatureStatus, err := verifyPupManifest(r.config, manifestData, readPupSignatures(os.ReadFile, pupLocation))
+		if err != nil {
+			log.Printf("Skipping pup at %s: %v", pupLocation, err)
+			continue
+		}
+
 		logoBase64 := ""
 
 		if manifest.Meta.LogoPath != "" {
@@ -129,9 +139,10 @@ outer:
 			Location: map[string]string{
 				"path": pupLocation,
 			},
-			Version:    manifest.Meta.Version,
-			Manifest:   manifest,
-			LogoBase64: logoBase64,
+			Version:         manifest.Meta.Version,
+			Manifest:        manifest,
+			LogoBase64:      logoBase64,
+			SignatureStatus: signatureStatus,
 		}
 
 		pups = append(pups, pup)
@@ -2,6 +2,7 @@ package source
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"golang.org/x/mod/semver"
 )
@@ -26,6 +28,8 @@ var _ dogeboxd.ManifestSource = &ManifestSourceGit{}
 type ManifestSourceGit struct {
 	serverConfig dogeboxd.ServerConfig
 	config       dogeboxd.ManifestSourceConfiguration
+	store        *dogeboxd.TypeStore[GitSourceCache]
+	credentials  *credentialStore
 	_cache       dogeboxd.ManifestSourceList
 	_isCached    bool
 }
@@ -83,9 +87,13 @@ func (r ManifestSourceGit) ValidateFromLocation(location string) (dogeboxd.Manif
 	}
 	defer manifestFile.Close()
 
+	manifestBytes, err := io.ReadAll(manifestFile)
+	if err != nil {
+		return dogeboxd.ManifestSourceConfiguration{}, fmt.Errorf("error reading manifest.json: %w", err)
+	}
+
 	var manifest dogeboxd.PupManifest
-	decoder := json.NewDecoder(manifestFile)
-	if err := decoder.Decode(&manifest); err != nil {
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
 		return dogeboxd.ManifestSourceConfiguration{}, fmt.Errorf("error parsing manifest.json: %w", err)
 	}
 
@@ -93,6 +101,10 @@ func (r ManifestSourceGit) ValidateFromLocation(location string) (dogeboxd.Manif
 		return dogeboxd.ManifestSourceConfiguration{}, fmt.Errorf("invalid manifest.json: %w", err)
 	}
 
+	if err := dogeboxd.ValidateManifestJSON(manifestBytes, manifest); err != nil {
+		return dogeboxd.ManifestSourceConfiguration{}, err
+	}
+
 	var sourceId string
 	b := make([]byte, 16)
 	_, err = rand.Read(b)
@@ -110,28 +122,111 @@ func (r ManifestSourceGit) ValidateFromLocation(location string) (dogeboxd.Manif
 	}, nil
 }
 
+// authMethod returns how to authenticate against a private source,
+// or nil for a public one.
+func (r ManifestSourceGit) authMethod() (transport.AuthMethod, error) {
+	if r.config.CredentialID == "" {
+		return nil, nil
+	}
+
+	if r.credentials == nil {
+		return nil, errNoCredentialStore
+	}
+
+	return r.credentials.auth(r.config.CredentialID)
+}
+
 func (r ManifestSourceGit) GetAllGitTags(location string) ([]string, error) {
+	refs, _, err := r.getRemoteHashes(location)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var tags []string
+	for tag := range refs {
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// getRemoteHashes returns the commit hash every tag and branch on the
+// remote points at. Annotated tags are peeled, so re-tagging the same
+// commit doesn't look like a change.
+func (r ManifestSourceGit) getRemoteHashes(location string) (map[string]string, map[string]string, error) {
 	rem := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		Name: "origin",
 		URLs: []string{location},
 	})
 
+	auth, err := r.authMethod()
+	if err != nil {
+		return map[string]string{}, map[string]string{}, err
+	}
+
 	refs, err := rem.List(&git.ListOptions{
+		Auth:          auth,
 		PeelingOption: git.AppendPeeled,
 	})
 	if err != nil {
-		return []string{}, err
+		return map[string]string{}, map[string]string{}, err
 	}
 
-	// Filters the references list and only keeps tags
-	var tags []string
+	tags := map[string]string{}
+	branches := map[string]string{}
 	for _, ref := range refs {
-		if ref.Name().IsTag() {
-			tags = append(tags, ref.Name().Short())
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = ref.Hash().String()
+			continue
+		}
+
+		if !ref.Name().IsTag() {
+			continue
+		}
+
+		name := ref.Name().Short()
+		if tag, peeled := strings.CutSuffix(name, "^{}"); peeled {
+			tags[tag] = ref.Hash().String()
+			continue
+		}
+
+		if _, ok := tags[name]; !ok {
+			tags[name] = ref.Hash().String()
 		}
 	}
 
-	return tags, nil
+	return tags, branches, nil
+}
+
+// GetBranchHead returns the commit a branch on the remote points at.
+func (r ManifestSourceGit) GetBranchHead(location, branch string) (string, error) {
+	_, branches, err := r.getRemoteHashes(location)
+	if err != nil {
+		return "", err
+	}
+
+	hash, ok := branches[branch]
+	if !ok {
+		return "", fmt.Errorf("branch %s not found", branch)
+	}
+
+	return hash, nil
+}
+
+/* devChannelVersion is the version a channel build is listed
+ * as. It is a pre-release of 0.0.0, so it never outranks a
+ * tagged release, and the commit makes every build distinct.
+ */
+func devChannelVersion(hash string) string {
+	short := hash
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	return "0.0.0-dev+" + short
+}
+
+func IsDevChannelVersion(version string) bool {
+	return strings.HasPrefix(version, "0.0.0-dev+")
 }
 
 func (r ManifestSourceGit) Name() string {
@@ -146,9 +241,15 @@ func (r ManifestSourceGit) getShallowWorktree(location, tag string) (*git.Worktr
 	storage := memory.NewStorage()
 	fs := memfs.New()
 
+	auth, err := r.authMethod()
+	if err != nil {
+		return &git.Worktree{}, &git.Repository{}, err
+	}
+
 	// Clone the repository with the specific tag
 	repo, err := git.Clone(storage, fs, &git.CloneOptions{
 		URL:           location,
+		Auth:          auth,
 		ReferenceName: plumbing.ReferenceName(tag),
 		SingleBranch:  true,
 		Depth:         1,
@@ -202,9 +303,11 @@ func (r ManifestSourceGit) getSourceDetailsFromWorktree(worktree *git.Worktree)
 }
 
 type GitPupEntry struct {
-	Manifest   dogeboxd.PupManifest
-	SubPath    string
-	LogoBase64 string
+	Manifest        dogeboxd.PupManifest
+	ManifestSha256  string
+	SubPath         string
+	LogoBase64      string
+	SignatureStatus string
 }
 
 func (r ManifestSourceGit) ensureTagValidAndGetPups(tag string) ([]GitPupEntry, error) {
@@ -235,18 +338,44 @@ func (r ManifestSourceGit) ensureTagValidAndGetPups(tag string) ([]GitPupEntry,
 		if err != nil {
 			return []GitPupEntry{}, err
 		}
-		if isValid {
-			entries = append(entries, GitPupEntry{
-				Manifest:   pupManifest,
-				SubPath:    pupLocation,
-				LogoBase64: logoBase64,
-			})
+		if !isValid {
+			continue
+		}
+
+		manifestBytes, err := readWorktreeFile(worktree, filepath.Join(pupLocation, "manifest.json"))
+		if err != nil {
+			return []GitPupEntry{}, fmt.Errorf("failed to read manifest.json: %w", err)
+		}
+
+		signatureStatus, err := verifyPupManifest(r.config, manifestBytes, readPupSignatures(func(name string) ([]byte, error) {
+			return readWorktreeFile(worktree, name)
+		}, pupLocation))
+		if err != nil {
+			log.Printf("tag %s pup %s: %v", tag, pupLocation, err)
+			continue
 		}
+
+		entries = append(entries, GitPupEntry{
+			Manifest:        pupManifest,
+			ManifestSha256:  fmt.Sprintf("%x", sha256.Sum256(manifestBytes)),
+			SubPath:         pupLocation,
+			LogoBase64:      logoBase64,
+			SignatureStatus: signatureStatus,
+		})
 	}
 
 	return entries, nil
 }
 
+func readWorktreeFile(worktree *git.Worktree, name string) ([]byte, error) {
+	f, err := worktree.Filesystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
 func (r ManifestSourceGit) getPupManifestFromWorktreeLocation(tag string, worktree *git.Worktree, location string) (dogeboxd.PupManifest, string, bool, error) {
 	for _, filename := range REQUIRED_FILES {
 		_, err := worktree.Filesystem.Stat(filepath.Join(location, filename))
@@ -280,6 +409,10 @@ func (r ManifestSourceGit) getPupManifestFromWorktreeLocation(tag string, worktr
 		return dogeboxd.PupManifest{}, "", false, fmt.Errorf("manifest validation failed: %w", err)
 	}
 
+	if err := dogeboxd.ValidateManifestJSON(manifestBytes, manifest); err != nil {
+		return dogeboxd.PupManifest{}, "", false, err
+	}
+
 	logoBase64 := ""
 
 	if manifest.Meta.LogoPath != "" {
@@ -308,23 +441,23 @@ func (r *ManifestSourceGit) List(ignoreCache bool) (dogeboxd.ManifestSourceList,
 		return r._cache, nil
 	}
 
-	repo, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
-		URL:          r.config.Location,
-		Depth:        1,
-		SingleBranch: true,
-		Tags:         git.AllTags,
-	})
-	if err != nil {
-		return dogeboxd.ManifestSourceList{}, err
-	}
+	cache := r.loadCache()
 
-	iter, err := repo.Tags()
+	remoteTags, remoteBranches, err := r.getRemoteHashes(r.config.Location)
 	if err != nil {
-		return dogeboxd.ManifestSourceList{}, err
+		if len(cache.Tags) == 0 {
+			return dogeboxd.ManifestSourceList{}, err
+		}
+
+		// Serve what we had last time, but don't keep it in memory
+		// so the next call tries the remote again.
+		log.Printf("Source %s unreachable, serving cached listing from %s: %v", r.config.ID, cache.LastChecked.Format(time.RFC3339), err)
+		return cache.toList(r.config, true), nil
 	}
 
 	type TagResult struct {
 		version string
+		hash    string
 		entries []GitPupEntry
 		err     error
 	}
@@ -332,58 +465,126 @@ func (r *ManifestSourceGit) List(ignoreCache bool) (dogeboxd.ManifestSourceList,
 	resultChan := make(chan TagResult)
 	var tagCount int
 
-	err = iter.ForEach(func(p *plumbing.Reference) error {
-		tagRef := string(p.Name())
-		tagName := strings.Replace(tagRef, "refs/tags/", "", -1)
-		if semver.IsValid(tagName) {
-			tagCount++
-			go func(ref, version string) {
-				entries, err := r.ensureTagValidAndGetPups(ref)
-				resultChan <- TagResult{version: version, entries: entries, err: err}
-			}(tagRef, tagName)
+	tags := map[string]GitSourceCacheTag{}
+
+	for tagName, hash := range remoteTags {
+		if !semver.IsValid(tagName) {
+			continue
 		}
 
-		return nil
-	})
-	if err != nil {
-		return dogeboxd.ManifestSourceList{}, err
-	}
+		// Only tags that are new, or now point at a different commit, need reading.
+		if cached, ok := cache.Tags[tagName]; ok && cached.Hash == hash {
+			tags[tagName] = cached
+			continue
+		}
 
-	validPups := []dogeboxd.ManifestSourcePup{}
+		tagCount++
+		go func(ref, version, hash string) {
+			entries, err := r.ensureTagValidAndGetPups(ref)
+			resultChan <- TagResult{version: version, hash: hash, entries: entries, err: err}
+		}("refs/tags/"+tagName, tagName, hash)
+	}
 
 	for i := 0; i < tagCount; i++ {
 		result := <-resultChan
 		if result.err != nil {
+			// Not cached, so we try this tag again on the next refresh.
 			log.Printf("Error validating tag %s: %v", result.version, result.err)
 			continue
 		}
 
+		pups := []dogeboxd.ManifestSourcePup{}
 		for _, entry := range result.entries {
-			validPups = append(validPups, dogeboxd.ManifestSourcePup{
-				Name: entry.Manifest.Meta.Name,
-				Location: map[string]string{
-					"tag":     result.version,
-					"subPath": entry.SubPath,
-				},
-				Version:    entry.Manifest.Meta.Version,
-				Manifest:   entry.Manifest,
-				LogoBase64: entry.LogoBase64,
-			})
+			pups = append(pups, gitListingPup(entry, map[string]string{
+				"tag":     result.version,
+				"subPath": entry.SubPath,
+				"commit":  result.hash,
+			}, result.hash))
 		}
+
+		tags[result.version] = GitSourceCacheTag{Hash: result.hash, Pups: pups}
 	}
 
-	list := dogeboxd.ManifestSourceList{
-		Config:      r.config,
-		LastChecked: time.Now(),
-		Pups:        validPups,
+	channelHead := r.listChannel(cache, remoteBranches)
+
+	cache = GitSourceCache{
+		Location:        r.config.Location,
+		SigningKeys:     r.config.SigningKeys,
+		SignaturePolicy: r.config.SignaturePolicy,
+		Channel:         r.config.Channel,
+		LastChecked:     time.Now(),
+		Tags:            tags,
+		ChannelHead:     channelHead,
 	}
+	r.saveCache(cache)
 
-	r._cache = list
+	r._cache = cache.toList(r.config, false)
 	r._isCached = true
 
 	return r._cache, nil
 }
 
+func gitListingPup(entry GitPupEntry, location map[string]string, hash string) dogeboxd.ManifestSourcePup {
+	return dogeboxd.ManifestSourcePup{
+		Name:            entry.Manifest.Meta.Name,
+		Location:        location,
+		Version:         entry.Manifest.Meta.Version,
+		Manifest:        entry.Manifest,
+		LogoBase64:      entry.LogoBase64,
+		SignatureStatus: entry.SignatureStatus,
+		Pin: dogeboxd.PupSourcePin{
+			Commit:         hash,
+			ManifestSha256: entry.ManifestSha256,
+		},
+	}
+}
+
+/* listChannel reads the HEAD of the source's channel branch,
+ * if it has one. Every pup there is listed under a dev
+ * version, whatever its manifest says, so a new commit is a
+ * new version to the store and to installed pups.
+ */
+func (r *ManifestSourceGit) listChannel(cache GitSourceCache, branches map[string]string) GitSourceCacheTag {
+	if r.config.Channel == "" {
+		return GitSourceCacheTag{}
+	}
+
+	hash, ok := branches[r.config.Channel]
+	if !ok {
+		log.Printf("Source %s channel branch %s not found", r.config.ID, r.config.Channel)
+		return GitSourceCacheTag{}
+	}
+
+	if cache.ChannelHead.Hash == hash {
+		return cache.ChannelHead
+	}
+
+	entries, err := r.ensureTagValidAndGetPups("refs/heads/" + r.config.Channel)
+	if err != nil {
+		// Not cached, so we try again on the next refresh.
+		log.Printf("Error validating channel %s: %v", r.config.Channel, err)
+		return GitSourceCacheTag{}
+	}
+
+	version := devChannelVersion(hash)
+
+	pups := []dogeboxd.ManifestSourcePup{}
+	for _, entry := range entries {
+		entry.Manifest.Meta.Version = version
+
+		pup := gitListingPup(entry, map[string]string{
+			"branch":  r.config.Channel,
+			"subPath": entry.SubPath,
+			"commit":  hash,
+		}, hash)
+		pup.DevChannel = true
+
+		pups = append(pups, pup)
+	}
+
+	return GitSourceCacheTag{Hash: hash, Pups: pups}
+}
+
 func (r ManifestSourceGit) Download(diskPath string, location map[string]string) error {
 	tempDir, err := os.MkdirTemp(r.serverConfig.TmpDir, "pup-clone-")
 	if err != nil {
@@ -391,11 +592,22 @@ func (r ManifestSourceGit) Download(diskPath string, location map[string]string)
 	}
 	defer os.RemoveAll(tempDir)
 
-	log.Printf("Cloning repository %s (tag: %s) to temporary directory", r.config.Location, location["tag"])
+	ref := "refs/tags/" + location["tag"]
+	if location["branch"] != "" {
+		ref = "refs/heads/" + location["branch"]
+	}
+
+	log.Printf("Cloning repository %s (%s) to temporary directory", r.config.Location, ref)
+
+	auth, err := r.authMethod()
+	if err != nil {
+		return err
+	}
 
-	_, err = git.PlainClone(tempDir, false, &git.CloneOptions{
+	repo, err := git.PlainClone(tempDir, false, &git.CloneOptions{
 		URL:           r.config.Location,
-		ReferenceName: plumbing.ReferenceName("refs/tags/" + location["tag"]),
+		Auth:          auth,
+		ReferenceName: plumbing.ReferenceName(ref),
 		SingleBranch:  true,
 		Depth:         1,
 	})
@@ -403,6 +615,19 @@ func (r ManifestSourceGit) Download(diskPath string, location map[string]string)
 		return fmt.Errorf("failed to clone repository: %w", err)
 	}
 
+	// The tag may have moved since we listed it, don't install
+	// something other than what the listing (and its pin) described.
+	if location["commit"] != "" {
+		head, err := repo.Head()
+		if err != nil {
+			return fmt.Errorf("failed to resolve cloned tag: %w", err)
+		}
+
+		if head.Hash().String() != location["commit"] {
+			return fmt.Errorf("%w: %s now points at %s, expected %s", dogeboxd.ErrPupSourceChanged, ref, head.Hash(), location["commit"])
+		}
+	}
+
 	// Construct the path to the subpath within the cloned repository
 	sourcePath := filepath.Join(tempDir, location["subPath"])
 
@@ -0,0 +1,87 @@
+package source
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* GitSourceCache is the last listing we built for a git source,
+ * persisted so we don't have to clone every tag again after a
+ * restart. Each tag remembers the commit it pointed at, so a
+ * refresh only re-reads tags that have moved or are new.
+ */
+type GitSourceCache struct {
+	Location        string
+	SigningKeys     []string
+	SignaturePolicy string
+	Channel         string
+	LastChecked     time.Time
+	Tags            map[string]GitSourceCacheTag
+	ChannelHead     GitSourceCacheTag // empty without a channel
+}
+
+type GitSourceCacheTag struct {
+	Hash string
+	Pups []dogeboxd.ManifestSourcePup
+}
+
+func (r ManifestSourceGit) loadCache() GitSourceCache {
+	empty := GitSourceCache{
+		Location:        r.config.Location,
+		SigningKeys:     r.config.SigningKeys,
+		SignaturePolicy: r.config.SignaturePolicy,
+		Channel:         r.config.Channel,
+		Tags:            map[string]GitSourceCacheTag{},
+	}
+
+	if r.store == nil {
+		return empty
+	}
+
+	cache, err := r.store.Get(r.config.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to load cached listing for source %s: %v", r.config.ID, err)
+		}
+		return empty
+	}
+
+	// Anything cached against a different location is useless to us,
+	// and signature statuses are only valid for the keys they were checked with.
+	if cache.Location != r.config.Location || cache.Tags == nil || cache.Channel != r.config.Channel ||
+		cache.SignaturePolicy != r.config.SignaturePolicy || !slices.Equal(cache.SigningKeys, r.config.SigningKeys) {
+		return empty
+	}
+
+	return cache
+}
+
+func (r ManifestSourceGit) saveCache(cache GitSourceCache) {
+	if r.store == nil {
+		return
+	}
+
+	if err := r.store.Set(r.config.ID, cache); err != nil {
+		log.Printf("Failed to persist cached listing for source %s: %v", r.config.ID, err)
+	}
+}
+
+func (cache GitSourceCache) toList(config dogeboxd.ManifestSourceConfiguration, stale bool) dogeboxd.ManifestSourceList {
+	pups := []dogeboxd.ManifestSourcePup{}
+	for _, tag := range cache.Tags {
+		pups = append(pups, tag.Pups...)
+	}
+	pups = append(pups, cache.ChannelHead.Pups...)
+
+	return dogeboxd.ManifestSourceList{
+		Config:      config,
+		LastChecked: cache.LastChecked,
+		Pups:        pups,
+		Stale:       stale,
+	}
+}
@@ -0,0 +1,430 @@
+package source
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+)
+
+var _ dogeboxd.ManifestSource = &ManifestSourceHTTP{}
+
+// Index requests should be quick, tarballs can take a while on a slow link.
+var (
+	httpIndexClient    = &http.Client{Timeout: 30 * time.Second}
+	httpDownloadClient = &http.Client{Timeout: 30 * time.Minute}
+)
+
+// Neither an index nor a logo has any business being bigger than this.
+const httpIndexMaxBytes = 32 << 20
+
+/* HTTPSourceIndex is a static JSON file describing every pup
+ * version a source provides, so a source can be hosted on any
+ * web server. Logo and tarball URLs may be relative to the
+ * index itself. Tarballs are gzipped and have manifest.json
+ * at their root, byte for byte the manifest in the index, so
+ * that signatures published in the index hold for both.
+ */
+type HTTPSourceIndex struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Pups        []HTTPSourceIndexPup `json:"pups"`
+}
+
+type HTTPSourceIndexPup struct {
+	Name     string                      `json:"name"`
+	Versions []HTTPSourceIndexPupVersion `json:"versions"`
+}
+
+type HTTPSourceIndexPupVersion struct {
+	Version    string               `json:"version"`
+	Manifest   dogeboxd.PupManifest `json:"manifest"`
+	LogoURL    string               `json:"logoUrl"`
+	TarballURL string               `json:"tarballUrl"`
+	SHA256     string               `json:"sha256"`
+	// Contents of manifest.json.minisig and manifest.json.sig, if signed.
+	Signatures HTTPSourceIndexSignatures `json:"signatures"`
+
+	rawManifest json.RawMessage
+}
+
+type HTTPSourceIndexSignatures struct {
+	Minisig string `json:"minisig,omitempty"`
+	SSHSig  string `json:"sshsig,omitempty"`
+}
+
+// UnmarshalJSON keeps the manifest exactly as published, as that's what was signed.
+func (v *HTTPSourceIndexPupVersion) UnmarshalJSON(data []byte) error {
+	type plain HTTPSourceIndexPupVersion
+	var entry struct {
+		plain
+		Manifest json.RawMessage `json:"manifest"`
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	*v = HTTPSourceIndexPupVersion(entry.plain)
+	v.rawManifest = entry.Manifest
+
+	if len(entry.Manifest) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(entry.Manifest, &v.Manifest)
+}
+
+func (v HTTPSourceIndexPupVersion) signatures() pupSignatures {
+	return pupSignatures{minisig: []byte(v.Signatures.Minisig), sshsig: []byte(v.Signatures.SSHSig)}
+}
+
+type ManifestSourceHTTP struct {
+	serverConfig dogeboxd.ServerConfig
+	config       dogeboxd.ManifestSourceConfiguration
+	_cache       dogeboxd.ManifestSourceList
+	_isCached    bool
+}
+
+func (r ManifestSourceHTTP) ValidateFromLocation(location string) (dogeboxd.ManifestSourceConfiguration, error) {
+	index, err := r.fetchIndex(location)
+	if err != nil {
+		return dogeboxd.ManifestSourceConfiguration{}, err
+	}
+
+	return dogeboxd.ManifestSourceConfiguration{
+		ID:          index.ID,
+		Name:        index.Name,
+		Description: index.Description,
+		Location:    location,
+		Type:        "http",
+	}, nil
+}
+
+func (r ManifestSourceHTTP) Config() dogeboxd.ManifestSourceConfiguration {
+	return r.config
+}
+
+func (r *ManifestSourceHTTP) List(ignoreCache bool) (dogeboxd.ManifestSourceList, error) {
+	if !ignoreCache && r._isCached {
+		return r._cache, nil
+	}
+
+	index, err := r.fetchIndex(r.config.Location)
+	if err != nil {
+		return dogeboxd.ManifestSourceList{}, err
+	}
+
+	logos := map[string]string{}
+	pups := []dogeboxd.ManifestSourcePup{}
+
+	for _, indexPup := range index.Pups {
+		for _, version := range indexPup.Versions {
+			tarballURL, err := resolveIndexURL(r.config.Location, version.TarballURL)
+			if err != nil {
+				log.Printf("Skipping %s %s: %v", indexPup.Name, version.Version, err)
+				continue
+			}
+
+			signatureStatus, err := verifyPupManifest(r.config, version.rawManifest, version.signatures())
+			if err != nil {
+				log.Printf("Skipping %s %s: %v", indexPup.Name, version.Version, err)
+				continue
+			}
+
+			logoBase64 := ""
+			if version.LogoURL != "" {
+				logoURL, err := resolveIndexURL(r.config.Location, version.LogoURL)
+				if err == nil {
+					if _, ok := logos[logoURL]; !ok {
+						logos[logoURL] = fetchLogo(logoURL)
+					}
+					logoBase64 = logos[logoURL]
+				}
+			}
+
+			pups = append(pups, dogeboxd.ManifestSourcePup{
+				Name: version.Manifest.Meta.Name,
+				Location: map[string]string{
+					"tarball":        tarballURL,
+					"sha256":         strings.ToLower(version.SHA256),
+					"manifestSha256": fmt.Sprintf("%x", sha256.Sum256(version.rawManifest)),
+					"minisig":        version.Signatures.Minisig,
+					"sshsig":         version.Signatures.SSHSig,
+				},
+				Version:         version.Manifest.Meta.Version,
+				Manifest:        version.Manifest,
+				LogoBase64:      logoBase64,
+				SignatureStatus: signatureStatus,
+				Pin: dogeboxd.PupSourcePin{
+					ManifestSha256: fmt.Sprintf("%x", sha256.Sum256(version.rawManifest)),
+				},
+			})
+		}
+	}
+
+	r._cache = dogeboxd.ManifestSourceList{
+		Config:      r.config,
+		LastChecked: time.Now(),
+		Pups:        pups,
+	}
+	r._isCached = true
+
+	return r._cache, nil
+}
+
+func (r ManifestSourceHTTP) Download(diskPath string, location map[string]string) error {
+	expected := location["sha256"]
+	if len(expected) != sha256.Size*2 {
+		return fmt.Errorf("missing or invalid sha256 for %s", location["tarball"])
+	}
+
+	tarball, err := os.CreateTemp(r.serverConfig.TmpDir, "pup-tarball-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tarball.Name())
+	defer tarball.Close()
+
+	log.Printf("Downloading %s", location["tarball"])
+
+	resp, err := httpDownloadClient.Get(location["tarball"])
+	if err != nil {
+		return fmt.Errorf("failed to download tarball: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download tarball: %s", resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tarball, hash), resp.Body); err != nil {
+		return fmt.Errorf("failed to download tarball: %w", err)
+	}
+
+	// Nothing from the tarball touches the disk until we know it's what the index promised.
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", location["tarball"], expected, actual)
+	}
+
+	if _, err := tarball.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := extractTarGz(tarball, diskPath); err != nil {
+		return fmt.Errorf("failed to extract tarball: %w", err)
+	}
+
+	// The tarball has to carry the manifest the index listed, or
+	// the index could say one thing and install another.
+	manifestData, err := os.ReadFile(filepath.Join(diskPath, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest file: %w", err)
+	}
+
+	if actual := fmt.Sprintf("%x", sha256.Sum256(manifestData)); location["manifestSha256"] != "" && actual != location["manifestSha256"] {
+		return fmt.Errorf("manifest.json in %s does not match its index entry: expected sha256 %s, got %s", location["tarball"], location["manifestSha256"], actual)
+	}
+
+	// Signatures published in the index rather than the tarball go
+	// next to the manifest, where verifyPupDirectory looks for them.
+	for name, file := range map[string]string{"minisig": minisignSignatureFile, "sshsig": sshSignatureFile} {
+		if location[name] == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(diskPath, file), []byte(location[name]), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+
+	log.Printf("Successfully downloaded and extracted %s to %s", location["tarball"], diskPath)
+
+	return nil
+}
+
+func (r ManifestSourceHTTP) fetchIndex(location string) (HTTPSourceIndex, error) {
+	body, err := httpGet(location)
+	if err != nil {
+		return HTTPSourceIndex{}, fmt.Errorf("failed to fetch index: %w", err)
+	}
+
+	var index HTTPSourceIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return HTTPSourceIndex{}, fmt.Errorf("failed to parse index: %w", err)
+	}
+
+	if err := index.Validate(); err != nil {
+		return HTTPSourceIndex{}, fmt.Errorf("invalid index: %w", err)
+	}
+
+	return index, nil
+}
+
+func (index HTTPSourceIndex) Validate() error {
+	if index.ID == "" {
+		return fmt.Errorf("missing field: id")
+	}
+
+	if index.Name == "" {
+		return fmt.Errorf("missing field: name")
+	}
+
+	for _, pup := range index.Pups {
+		for _, version := range pup.Versions {
+			if version.Manifest.Meta.Name != pup.Name || version.Manifest.Meta.Version != version.Version {
+				return fmt.Errorf("manifest for %s %s does not match its index entry", pup.Name, version.Version)
+			}
+
+			if err := version.Manifest.Validate(); err != nil {
+				return fmt.Errorf("manifest for %s %s is invalid: %w", pup.Name, version.Version, err)
+			}
+
+			if version.TarballURL == "" {
+				return fmt.Errorf("missing field: tarballUrl for %s %s", pup.Name, version.Version)
+			}
+
+			if _, err := hex.DecodeString(version.SHA256); err != nil || len(version.SHA256) != sha256.Size*2 {
+				return fmt.Errorf("invalid sha256 for %s %s", pup.Name, version.Version)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveIndexURL resolves a URL from the index relative to the index itself.
+func resolveIndexURL(indexLocation, ref string) (string, error) {
+	base, err := url.Parse(indexLocation)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	return u.String(), nil
+}
+
+// fetchLogo never fails a listing, a missing logo just isn't shown.
+func fetchLogo(logoURL string) string {
+	data, err := httpGet(logoURL)
+	if err != nil {
+		log.Printf("failed to fetch logo %s: %s", logoURL, err)
+		return ""
+	}
+
+	u, _ := url.Parse(logoURL)
+	logoBase64, err := utils.ImageBytesToWebBase64(data, path.Base(u.Path))
+	if err != nil {
+		log.Printf("failed to convert logo %s: %s", logoURL, err)
+		return ""
+	}
+
+	return logoBase64
+}
+
+func httpGet(location string) ([]byte, error) {
+	resp, err := httpIndexClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, httpIndexMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > httpIndexMaxBytes {
+		return nil, fmt.Errorf("GET %s: response too large", location)
+	}
+
+	return body, nil
+}
+
+// extractTarGz extracts regular files and directories into dest,
+// refusing anything that would land outside of it.
+func extractTarGz(r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+			if target == filepath.Clean(dest) {
+				continue
+			}
+			return fmt.Errorf("tarball entry %s escapes the pup directory", header.Name)
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+
+			if err := f.Close(); err != nil {
+				return err
+			}
+
+		default:
+			log.Printf("Skipping unsupported tarball entry %s", header.Name)
+		}
+	}
+}
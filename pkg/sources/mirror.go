@@ -0,0 +1,371 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* ExportMirror writes the selected pups of a source into a
+ * mirror bundle at options.Dest. Every pup is downloaded and
+ * verified exactly as it would be for an install, so the
+ * bundle only ever holds what this box would have installed.
+ * Nix closures are added separately, see NixManager.
+ */
+func (sourceManager *sourceManager) ExportMirror(options dogeboxd.MirrorExportOptions) (dogeboxd.MirrorBundle, error) {
+	source, err := sourceManager.GetSource(options.SourceID)
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+	config := source.Config()
+
+	list, err := source.List(false)
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	selected, err := selectMirrorPups(list.Pups, options.Pups)
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	if _, err := os.Stat(options.Dest); err == nil {
+		return dogeboxd.MirrorBundle{}, fmt.Errorf("%s already exists", options.Dest)
+	}
+
+	if err := os.MkdirAll(options.Dest, 0755); err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	bundle, err := sourceManager.writeMirror(config, selected, options.Dest)
+	if err != nil {
+		os.RemoveAll(options.Dest)
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	return bundle, nil
+}
+
+func (sourceManager *sourceManager) writeMirror(config dogeboxd.ManifestSourceConfiguration, pups []dogeboxd.ManifestSourcePup, dest string) (dogeboxd.MirrorBundle, error) {
+	bundle := dogeboxd.MirrorBundle{
+		ID:              config.ID,
+		Name:            config.Name,
+		Description:     config.Description,
+		SourceID:        config.ID,
+		SourceLocation:  config.Location,
+		Created:         time.Now(),
+		Pups:            []dogeboxd.MirrorBundlePup{},
+		SigningKeys:     config.SigningKeys,
+		SignaturePolicy: config.SignaturePolicy,
+	}
+
+	details := dogeboxd.SourceDetails{
+		ID:          config.ID,
+		Name:        config.Name,
+		Description: config.Description,
+		Pups:        []dogeboxd.SourceDetailsPup{},
+	}
+
+	for _, pup := range pups {
+		location := filepath.Join("pups", pupDirName(pup.Name, pup.Version))
+
+		pupDir := filepath.Join(dest, location)
+		if err := os.MkdirAll(pupDir, 0755); err != nil {
+			return dogeboxd.MirrorBundle{}, err
+		}
+
+		log.Printf("Mirroring %s %s from source %s", pup.Name, pup.Version, config.ID)
+
+		if _, err := sourceManager.DownloadPup(pupDir, config.ID, pup.Name, pup.Version, true); err != nil {
+			return dogeboxd.MirrorBundle{}, fmt.Errorf("failed to download %s %s: %w", pup.Name, pup.Version, err)
+		}
+
+		bundle.Pups = append(bundle.Pups, dogeboxd.MirrorBundlePup{Name: pup.Name, Version: pup.Version, Location: location})
+		details.Pups = append(details.Pups, dogeboxd.SourceDetailsPup{Location: location})
+	}
+
+	if err := writeSourceDetails(filepath.Join(dest, "dogebox.json"), details); err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	if err := dogeboxd.WriteMirrorBundle(dest, bundle); err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	return bundle, nil
+}
+
+func selectMirrorPups(available []dogeboxd.ManifestSourcePup, want map[string][]string) ([]dogeboxd.ManifestSourcePup, error) {
+	selected := []dogeboxd.ManifestSourcePup{}
+
+	for _, pup := range available {
+		versions, ok := want[pup.Name]
+		if len(want) > 0 && !ok {
+			continue
+		}
+		if len(versions) > 0 && !slices.Contains(versions, pup.Version) {
+			continue
+		}
+		selected = append(selected, pup)
+	}
+
+	// Don't quietly hand someone a bundle without the pup they asked for.
+	missing := []string{}
+	for name, versions := range want {
+		if len(versions) == 0 {
+			if !slices.ContainsFunc(selected, func(p dogeboxd.ManifestSourcePup) bool { return p.Name == name }) {
+				missing = append(missing, name)
+			}
+			continue
+		}
+		for _, version := range versions {
+			if !slices.ContainsFunc(selected, func(p dogeboxd.ManifestSourcePup) bool { return p.Name == name && p.Version == version }) {
+				missing = append(missing, name+" "+version)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, fmt.Errorf("not found in source: %s", strings.Join(missing, ", "))
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("nothing to export, the source has no pups")
+	}
+
+	return selected, nil
+}
+
+/* ImportMirror copies a mirror bundle (say, from a USB stick)
+ * into DataDir/mirrors and registers it as a disk source.
+ * Importing a newer bundle of an already imported mirror
+ * replaces it.
+ */
+func (sourceManager *sourceManager) ImportMirror(dir string) (dogeboxd.ManifestSource, error) {
+	if sourceManager.config.DataDir == "" {
+		return nil, errNoMirrorsDir
+	}
+
+	staging, err := stageMirrorDir(sourceManager.config.DataDir, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	return sourceManager.installMirror(staging)
+}
+
+// ImportMirrorArchive imports a mirror bundle from a gzipped tarball of one.
+func (sourceManager *sourceManager) ImportMirrorArchive(archive io.Reader) (dogeboxd.ManifestSource, error) {
+	if sourceManager.config.DataDir == "" {
+		return nil, errNoMirrorsDir
+	}
+
+	staging, bundleDir, err := stageMirrorArchive(sourceManager.config.DataDir, archive)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	return sourceManager.installMirror(bundleDir)
+}
+
+// installMirror moves a staged bundle into place and registers it.
+func (sourceManager *sourceManager) installMirror(staged string) (dogeboxd.ManifestSource, error) {
+	bundle, err := dogeboxd.ReadMirrorBundle(staged)
+	if err != nil {
+		return nil, err
+	}
+
+	dest := mirrorDest(sourceManager.config.DataDir, bundle)
+
+	existing, err := sourceManager.GetSource(bundle.ID)
+	if err == nil && existing.Config().Location != dest {
+		return nil, fmt.Errorf("a source with id %s already exists", bundle.ID)
+	}
+
+	if _, err := placeMirror(sourceManager.config.DataDir, staged); err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		// Same location, the source just lists the new contents.
+		sourceManager.index.invalidate()
+		return existing, nil
+	}
+
+	return sourceManager.registerMirror(dest, bundle)
+}
+
+/* StageMirror copies a mirror bundle, either a directory or a
+ * .tar.gz of one, into DataDir/mirrors without registering it.
+ * dogeboxd registers it as a source the next time it starts.
+ * This is how dbx imports a mirror without talking to dogeboxd.
+ */
+func StageMirror(dataDir, path string) (dogeboxd.MirrorBundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	var staging, bundleDir string
+
+	if info.IsDir() {
+		staging, err = stageMirrorDir(dataDir, path)
+		bundleDir = staging
+	} else {
+		f, openErr := os.Open(path)
+		if openErr != nil {
+			return dogeboxd.MirrorBundle{}, openErr
+		}
+		defer f.Close()
+		staging, bundleDir, err = stageMirrorArchive(dataDir, f)
+	}
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	return placeMirror(dataDir, bundleDir)
+}
+
+var errNoMirrorsDir = fmt.Errorf("mirrors can't be imported without a data dir")
+
+func newMirrorStaging(dataDir string) (string, error) {
+	mirrorsDir := dogeboxd.MirrorsDir(dataDir)
+	if err := os.MkdirAll(mirrorsDir, 0755); err != nil {
+		return "", err
+	}
+
+	// Stage next to the final location so we can rename into place.
+	return os.MkdirTemp(mirrorsDir, ".import-")
+}
+
+func stageMirrorDir(dataDir, dir string) (string, error) {
+	if _, err := dogeboxd.ReadMirrorBundle(dir); err != nil {
+		return "", err
+	}
+
+	staging, err := newMirrorStaging(dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	// A disk source download is just a recursive copy.
+	if err := (ManifestSourceDisk{}).Download(staging, map[string]string{"path": dir}); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	return staging, nil
+}
+
+// stageMirrorArchive returns the staging dir to clean up, and the bundle within it.
+func stageMirrorArchive(dataDir string, archive io.Reader) (string, string, error) {
+	staging, err := newMirrorStaging(dataDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := extractTarGz(archive, staging); err != nil {
+		os.RemoveAll(staging)
+		return "", "", fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	// Archives made with tar -C .. often wrap the bundle in its directory.
+	bundleDir := staging
+	if _, err := os.Stat(filepath.Join(staging, dogeboxd.MIRROR_BUNDLE_FILE)); os.IsNotExist(err) {
+		entries, err := os.ReadDir(staging)
+		if err == nil && len(entries) == 1 && entries[0].IsDir() {
+			bundleDir = filepath.Join(staging, entries[0].Name())
+		}
+	}
+
+	return staging, bundleDir, nil
+}
+
+func mirrorDest(dataDir string, bundle dogeboxd.MirrorBundle) string {
+	return filepath.Join(dogeboxd.MirrorsDir(dataDir), unsafeDirNameChars.ReplaceAllString(bundle.ID, "_"))
+}
+
+// placeMirror checks a staged bundle and moves it over any previous import of it.
+func placeMirror(dataDir, staged string) (dogeboxd.MirrorBundle, error) {
+	bundle, err := dogeboxd.ReadMirrorBundle(staged)
+	if err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	if _, err := (ManifestSourceDisk{config: dogeboxd.ManifestSourceConfiguration{Location: staged}}).List(false); err != nil {
+		return dogeboxd.MirrorBundle{}, fmt.Errorf("bundle isn't a valid source: %w", err)
+	}
+
+	dest := mirrorDest(dataDir, bundle)
+
+	if err := os.RemoveAll(dest); err != nil {
+		return dogeboxd.MirrorBundle{}, err
+	}
+
+	if err := os.Rename(staged, dest); err != nil {
+		return dogeboxd.MirrorBundle{}, fmt.Errorf("failed to store mirror: %w", err)
+	}
+
+	log.Printf("Imported mirror of %s (%d pups) into %s", bundle.SourceLocation, len(bundle.Pups), dest)
+
+	return bundle, nil
+}
+
+func (sourceManager *sourceManager) registerMirror(dir string, bundle dogeboxd.MirrorBundle) (dogeboxd.ManifestSource, error) {
+	s, err := sourceManager.AddSource(dir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bundle.SigningKeys) > 0 || bundle.SignaturePolicy != "" {
+		if err := sourceManager.SetSourceSigning(bundle.ID, bundle.SigningKeys, bundle.SignaturePolicy); err != nil {
+			log.Printf("Failed to carry signing keys over to mirror %s: %v", bundle.ID, err)
+		}
+		return sourceManager.GetSource(bundle.ID)
+	}
+
+	return s, nil
+}
+
+// ensureMirrorSources registers mirrors that were copied into place
+// while dogeboxd wasn't running, ie. by `dbx source mirror import`.
+func (sourceManager *sourceManager) ensureMirrorSources() {
+	mirrorsDir := dogeboxd.MirrorsDir(sourceManager.config.DataDir)
+
+	entries, err := os.ReadDir(mirrorsDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		dir := filepath.Join(mirrorsDir, entry.Name())
+
+		bundle, err := dogeboxd.ReadMirrorBundle(dir)
+		if err != nil {
+			continue
+		}
+
+		if slices.ContainsFunc(sourceManager.GetAllSourceConfigurations(), func(c dogeboxd.ManifestSourceConfiguration) bool { return c.Location == dir }) {
+			continue
+		}
+
+		if _, err := sourceManager.registerMirror(dir, bundle); err != nil {
+			log.Printf("Failed to register mirror %s: %v", dir, err)
+		}
+	}
+}
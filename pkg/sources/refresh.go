@@ -0,0 +1,258 @@
+package source
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"slices"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+const (
+	// Used when SourceRefreshConfig doesn't set an interval.
+	SOURCE_REFRESH_DEFAULT_INTERVAL = 60 * time.Minute
+	// How often the refresher checks whether a refresh is due.
+	SOURCE_REFRESH_CHECK_INTERVAL = time.Minute
+	// The first refresh happens at a random point within this long after boot.
+	SOURCE_REFRESH_BOOT_DELAY = 5 * time.Minute
+)
+
+// Refreshes are spread +/- this fraction of the interval, so every
+// dogebox doesn't hit a source at the same moment.
+const sourceRefreshJitter = 0.1
+
+/* SourceRefresher re-lists every configured source in the
+ * background, records how that went in SourceState.Status
+ * and tells the frontend about pups and versions it hasn't
+ * seen before.
+ */
+type SourceRefresher struct {
+	sources dogeboxd.SourceManager
+	sm      dogeboxd.StateManager
+	dbx     dogeboxd.Dogeboxd
+}
+
+func NewSourceRefresher(sources dogeboxd.SourceManager, sm dogeboxd.StateManager, dbx dogeboxd.Dogeboxd) SourceRefresher {
+	return SourceRefresher{
+		sources: sources,
+		sm:      sm,
+		dbx:     dbx,
+	}
+}
+
+func (t SourceRefresher) Run(started, stopped chan bool, stop chan context.Context) error {
+	go func() {
+		go func() {
+			ticker := time.NewTicker(SOURCE_REFRESH_CHECK_INTERVAL)
+			defer ticker.Stop()
+
+			next := time.Now().Add(time.Duration(rand.Int63n(int64(SOURCE_REFRESH_BOOT_DELAY))))
+		mainloop:
+			for {
+				select {
+				case <-stop:
+					break mainloop
+				case <-ticker.C:
+					config := t.sm.Get().Sources.Refresh
+					if config.Disabled || time.Now().Before(next) {
+						continue
+					}
+
+					t.Refresh()
+					next = time.Now().Add(nextSourceRefresh(config))
+				}
+			}
+		}()
+
+		started <- true
+		<-stop
+		stopped <- true
+	}()
+	return nil
+}
+
+func nextSourceRefresh(config dogeboxd.SourceRefreshConfig) time.Duration {
+	interval := SOURCE_REFRESH_DEFAULT_INTERVAL
+	if config.IntervalMinutes > 0 {
+		interval = time.Duration(config.IntervalMinutes) * time.Minute
+	}
+
+	jitter := time.Duration((rand.Float64()*2 - 1) * sourceRefreshJitter * float64(interval))
+	return interval + jitter
+}
+
+// Refresh re-lists every source now, ignoring any cached listings.
+func (t SourceRefresher) Refresh() {
+	previous := t.sm.Get().Sources.Status
+	devChannels := t.sm.Get().Sources.DevChannels
+	status := map[string]dogeboxd.SourceStatus{}
+	changes := []dogeboxd.SourceChanges{}
+
+	for _, config := range t.sources.GetAllSourceConfigurations() {
+		before, checkedBefore := previous[config.ID]
+		current := dogeboxd.SourceStatus{
+			LastChecked:   time.Now(),
+			KnownVersions: before.KnownVersions,
+		}
+
+		list, err := t.listSource(config.ID)
+		if err != nil {
+			log.Printf("Failed to refresh source %s: %v", config.ID, err)
+			current.Error = err.Error()
+			status[config.ID] = current
+			continue
+		}
+
+		if list.Stale {
+			current.Stale = true
+			current.Error = "source unreachable, serving the last listing we had"
+		}
+
+		current.KnownVersions = knownVersions(list)
+		status[config.ID] = current
+
+		// Remembered on the pup, the change below is only sent once.
+		builds := t.devChannelBuilds(config, list)
+		t.recordUpgradesAvailable(config, builds)
+
+		// Everything in a source we've never checked is new, which isn't news.
+		if !checkedBefore || before.KnownVersions == nil {
+			continue
+		}
+
+		c := diffKnownVersions(config.ID, before.KnownVersions, current.KnownVersions, devChannels)
+
+		// Pups installed from a channel are offered its new builds, opted in or not.
+		c.Upgrades = devChannelUpgrades(builds, before.KnownVersions)
+
+		if len(c.NewPups) > 0 || len(c.NewVersions) > 0 || len(c.Upgrades) > 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	// Re-read, so we don't overwrite a source added while we were listing.
+	state := t.sm.Get().Sources
+	state.Status = status
+	if err := t.sm.SetSources(state); err != nil {
+		log.Printf("Failed to save source refresh status: %v", err)
+	}
+
+	// The listings we just fetched are cached, so this is cheap.
+	t.sources.RebuildStoreIndex()
+
+	if len(changes) > 0 {
+		t.sendChange(dogeboxd.Change{ID: "internal", Type: "sources", Update: dogeboxd.SourcesUpdate{Sources: changes}})
+	}
+}
+
+func (t SourceRefresher) listSource(id string) (dogeboxd.ManifestSourceList, error) {
+	source, err := t.sources.GetSource(id)
+	if err != nil {
+		return dogeboxd.ManifestSourceList{}, err
+	}
+	return source.List(true)
+}
+
+// send changes without blocking if nobody is listening
+func (t SourceRefresher) sendChange(c dogeboxd.Change) {
+	select {
+	case t.dbx.Changes <- c:
+	case <-time.After(200 * time.Millisecond):
+		log.Println("Can't send source refresh change, no receiver")
+	}
+}
+
+// devChannelBuild is the channel's current build of an installed dev channel pup.
+type devChannelBuild struct {
+	name    string
+	version string
+}
+
+// devChannelBuilds maps installed dev channel pups of a source to
+// the channel's current build of them, if it isn't the installed one.
+func (t SourceRefresher) devChannelBuilds(config dogeboxd.ManifestSourceConfiguration, list dogeboxd.ManifestSourceList) map[string]devChannelBuild {
+	builds := map[string]devChannelBuild{}
+
+	for id, state := range t.dbx.Pups.GetStateMap() {
+		if state.Source.ID != config.ID || !IsDevChannelVersion(state.Version) {
+			continue
+		}
+
+		for _, pup := range list.Pups {
+			if pup.DevChannel && pup.Name == state.Manifest.Meta.Name && pup.Version != state.Version {
+				builds[id] = devChannelBuild{name: pup.Name, version: pup.Version}
+			}
+		}
+	}
+
+	return builds
+}
+
+// recordUpgradesAvailable sets PupState.UpgradeAvailable on the source's dev channel pups.
+func (t SourceRefresher) recordUpgradesAvailable(config dogeboxd.ManifestSourceConfiguration, builds map[string]devChannelBuild) {
+	for id, state := range t.dbx.Pups.GetStateMap() {
+		if state.Source.ID != config.ID || !IsDevChannelVersion(state.Version) {
+			continue
+		}
+
+		version := builds[id].version
+		if state.UpgradeAvailable == version {
+			continue
+		}
+
+		if _, err := t.dbx.Pups.UpdatePup(id, dogeboxd.SetPupUpgradeAvailable(version)); err != nil {
+			log.Printf("Failed to record available upgrade for pup %s: %v", id, err)
+		}
+	}
+}
+
+// devChannelUpgrades picks the builds we haven't seen before, to announce them.
+func devChannelUpgrades(builds map[string]devChannelBuild, known map[string][]string) map[string]string {
+	upgrades := map[string]string{}
+	for id, build := range builds {
+		if !slices.Contains(known[build.name], build.version) {
+			upgrades[id] = build.version
+		}
+	}
+	return upgrades
+}
+
+func knownVersions(list dogeboxd.ManifestSourceList) map[string][]string {
+	versions := map[string][]string{}
+	for _, pup := range list.Pups {
+		if !slices.Contains(versions[pup.Name], pup.Version) {
+			versions[pup.Name] = append(versions[pup.Name], pup.Version)
+		}
+	}
+	return versions
+}
+
+// diffKnownVersions lists what is in after but not before. Dev channel
+// builds are always recorded, but only announced if devChannels is set.
+func diffKnownVersions(sourceID string, before, after map[string][]string, devChannels bool) dogeboxd.SourceChanges {
+	c := dogeboxd.SourceChanges{
+		SourceID:    sourceID,
+		NewPups:     []string{},
+		NewVersions: map[string][]string{},
+		Upgrades:    map[string]string{},
+	}
+
+	for name, versions := range after {
+		for _, version := range versions {
+			if IsDevChannelVersion(version) && !devChannels {
+				continue
+			}
+			if !slices.Contains(before[name], version) {
+				c.NewVersions[name] = append(c.NewVersions[name], version)
+			}
+		}
+
+		if _, ok := before[name]; !ok && len(c.NewVersions[name]) > 0 {
+			c.NewPups = append(c.NewPups, name)
+		}
+	}
+
+	return c
+}
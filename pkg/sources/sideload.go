@@ -0,0 +1,185 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* Sideloaded pups live in a built-in disk source under
+ * DataDir/sideloaded, one directory per pup version, listed
+ * in its dogebox.json so they install like any other pup.
+ */
+const sideloadDirName = "sideloaded"
+
+var unsafeDirNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// pupDirName is a safe directory name for one version of a pup within a disk source.
+func pupDirName(name, version string) string {
+	return unsafeDirNameChars.ReplaceAllString(name+"-"+version, "_")
+}
+
+func (sourceManager *sourceManager) sideloadDir() string {
+	return filepath.Join(sourceManager.config.DataDir, sideloadDirName)
+}
+
+// ensureSideloadSource creates the sideloaded source if this is the first boot with it.
+func (sourceManager *sourceManager) ensureSideloadSource() error {
+	dir := sourceManager.sideloadDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	indexPath := filepath.Join(dir, "dogebox.json")
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		if err := writeSourceDetails(indexPath, dogeboxd.SourceDetails{
+			ID:          dogeboxd.SOURCE_ID_SIDELOADED,
+			Name:        "Sideloaded",
+			Description: "Pups installed from an uploaded archive",
+			Pups:        []dogeboxd.SourceDetailsPup{},
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range sourceManager.sources {
+		if s.Config().ID == dogeboxd.SOURCE_ID_SIDELOADED {
+			return nil
+		}
+	}
+
+	sourceManager.sources = append(sourceManager.sources, sourceManager.newSource(dogeboxd.ManifestSourceConfiguration{
+		ID:          dogeboxd.SOURCE_ID_SIDELOADED,
+		Name:        "Sideloaded",
+		Description: "Pups installed from an uploaded archive",
+		Location:    dir,
+		Type:        "disk",
+	}))
+
+	return sourceManager.Save()
+}
+
+/* SideloadPup unpacks a pup archive (a gzipped tarball with
+ * manifest.json at its root, as made by `dbx dev pup pack`),
+ * validates it like any downloaded pup and adds it to the
+ * sideloaded source, ready for a normal InstallPup.
+ */
+func (sourceManager *sourceManager) SideloadPup(archive io.Reader) (dogeboxd.ManifestSourcePup, error) {
+	sourceManager.sideloadMu.Lock()
+	defer sourceManager.sideloadMu.Unlock()
+
+	source, err := sourceManager.GetSource(dogeboxd.SOURCE_ID_SIDELOADED)
+	if err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	// Stage next to the final location so we can rename into place.
+	staging, err := os.MkdirTemp(sourceManager.sideloadDir(), ".upload-")
+	if err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := extractTarGz(archive, staging); err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	manifestData, err := os.ReadFile(filepath.Join(staging, "manifest.json"))
+	if err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("archive has no manifest.json at its root")
+	}
+
+	var manifest dogeboxd.PupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("failed to parse manifest file: %w", err)
+	}
+
+	if err := manifest.Validate(); err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("manifest validation failed: %w", err)
+	}
+
+	if err := dogeboxd.ValidateManifestJSON(manifestData, manifest); err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	if err := sourceManager.validatePupFiles(staging); err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	nixFile, err := os.ReadFile(filepath.Join(staging, manifest.Container.Build.NixFile))
+	if err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("failed to read nix file: %w", err)
+	}
+
+	if fmt.Sprintf("%x", sha256.Sum256(nixFile)) != manifest.Container.Build.NixFileSha256 {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("nix file hash does not match manifest, run `dbx dev pup update-hash`")
+	}
+
+	if _, err := verifyPupDirectory(source.Config(), staging); err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	// Replacing files underneath an installed pup would make its state a lie.
+	for _, p := range sourceManager.pm.GetAllFromSource(source.Config()) {
+		if p.Manifest.Meta.Name == manifest.Meta.Name && p.Version == manifest.Meta.Version {
+			return dogeboxd.ManifestSourcePup{}, fmt.Errorf("%s %s is already installed, purge it before sideloading it again", manifest.Meta.Name, manifest.Meta.Version)
+		}
+	}
+
+	dirName := pupDirName(manifest.Meta.Name, manifest.Meta.Version)
+	pupDir := filepath.Join(sourceManager.sideloadDir(), dirName)
+
+	if err := os.RemoveAll(pupDir); err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	if err := os.Rename(staging, pupDir); err != nil {
+		return dogeboxd.ManifestSourcePup{}, fmt.Errorf("failed to store sideloaded pup: %w", err)
+	}
+
+	if err := addToSourceDetails(filepath.Join(sourceManager.sideloadDir(), "dogebox.json"), dirName); err != nil {
+		return dogeboxd.ManifestSourcePup{}, err
+	}
+
+	log.Printf("Sideloaded %s %s into %s", manifest.Meta.Name, manifest.Meta.Version, pupDir)
+	sourceManager.index.invalidate()
+
+	return sourceManager.GetSourcePup(dogeboxd.SOURCE_ID_SIDELOADED, manifest.Meta.Name, manifest.Meta.Version)
+}
+
+func addToSourceDetails(indexPath, location string) error {
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+
+	details, err := ParseAndValidateSourceDetails(string(content))
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(details.Pups, func(p dogeboxd.SourceDetailsPup) bool { return p.Location == location }) {
+		return nil
+	}
+
+	details.Pups = append(details.Pups, dogeboxd.SourceDetailsPup{Location: location})
+
+	return writeSourceDetails(indexPath, details)
+}
+
+func writeSourceDetails(indexPath string, details dogeboxd.SourceDetails) error {
+	content, err := json.MarshalIndent(details, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(indexPath, content, 0644)
+}
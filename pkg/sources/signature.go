@@ -0,0 +1,281 @@
+package source
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+/* Publishers sign a pup's manifest.json, which pins the nix file
+ * by hash, with either minisign or ssh-keygen -Y sign. The detached
+ * signature sits next to the manifest:
+ *
+ *   minisign -Sm manifest.json                          -> manifest.json.minisig
+ *   ssh-keygen -Y sign -n dogebox -f key manifest.json  -> manifest.json.sig
+ */
+const (
+	minisignSignatureFile = "manifest.json.minisig"
+	sshSignatureFile      = "manifest.json.sig"
+	sshSignatureNamespace = "dogebox"
+)
+
+type signingKey interface {
+	verify(manifest []byte, signatures pupSignatures) (bool, error)
+}
+
+type pupSignatures struct {
+	minisig []byte
+	sshsig  []byte
+}
+
+func (s pupSignatures) empty() bool {
+	return len(s.minisig) == 0 && len(s.sshsig) == 0
+}
+
+func ValidateSignaturePolicy(policy string) error {
+	switch policy {
+	case "", dogeboxd.SOURCE_SIGNATURE_POLICY_OFF, dogeboxd.SOURCE_SIGNATURE_POLICY_WARN, dogeboxd.SOURCE_SIGNATURE_POLICY_REQUIRE:
+		return nil
+	}
+	return fmt.Errorf("unknown signature policy %q", policy)
+}
+
+// parseSigningKey accepts a minisign public key (the base64 line,
+// optionally with its untrusted comment) or an authorized_keys line.
+func parseSigningKey(key string) (signingKey, error) {
+	key = strings.TrimSpace(key)
+
+	if strings.HasPrefix(key, "ssh-") || strings.HasPrefix(key, "ecdsa-") || strings.HasPrefix(key, "sk-") {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh public key: %w", err)
+		}
+		return sshSigningKey{pub}, nil
+	}
+
+	lines := strings.Split(key, "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, fmt.Errorf("invalid minisign public key")
+	}
+
+	return minisignKey{keyID: raw[2:10], key: ed25519.PublicKey(raw[10:])}, nil
+}
+
+func ValidateSigningKeys(keys []string) error {
+	for _, key := range keys {
+		if _, err := parseSigningKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type minisignKey struct {
+	keyID []byte
+	key   ed25519.PublicKey
+}
+
+func (k minisignKey) verify(manifest []byte, signatures pupSignatures) (bool, error) {
+	if len(signatures.minisig) == 0 {
+		return false, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(signatures.minisig)), "\n")
+	if len(lines) != 4 {
+		return false, fmt.Errorf("malformed minisign signature")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 74 {
+		return false, fmt.Errorf("malformed minisign signature")
+	}
+
+	// Signed by some other key, which is fine if another key matches.
+	if !bytes.Equal(sig[2:10], k.keyID) {
+		return false, nil
+	}
+
+	message := manifest
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		hash := blake2b.Sum512(manifest)
+		message = hash[:]
+	default:
+		return false, fmt.Errorf("unknown minisign signature algorithm")
+	}
+
+	if !ed25519.Verify(k.key, message, sig[10:]) {
+		return false, fmt.Errorf("minisign signature does not match manifest")
+	}
+
+	trustedComment, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ok {
+		return false, fmt.Errorf("malformed minisign trusted comment")
+	}
+
+	globalMessage := append(bytes.Clone(sig[10:]), trustedComment...)
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(k.key, globalMessage, globalSig) {
+		return false, fmt.Errorf("minisign trusted comment signature is invalid")
+	}
+
+	return true, nil
+}
+
+type sshSigningKey struct {
+	key ssh.PublicKey
+}
+
+// See PROTOCOL.sshsig in the openssh source for the format.
+func (k sshSigningKey) verify(manifest []byte, signatures pupSignatures) (bool, error) {
+	if len(signatures.sshsig) == 0 {
+		return false, nil
+	}
+
+	block, _ := pem.Decode(signatures.sshsig)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return false, fmt.Errorf("malformed ssh signature")
+	}
+
+	blob, ok := bytes.CutPrefix(block.Bytes, []byte("SSHSIG"))
+	if !ok {
+		return false, fmt.Errorf("malformed ssh signature")
+	}
+
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(blob, &sig); err != nil || sig.Version != 1 {
+		return false, fmt.Errorf("malformed ssh signature")
+	}
+
+	if !bytes.Equal(sig.PublicKey, k.key.Marshal()) {
+		return false, nil
+	}
+
+	if sig.Namespace != sshSignatureNamespace {
+		return false, fmt.Errorf("ssh signature namespace must be %q, got %q", sshSignatureNamespace, sig.Namespace)
+	}
+
+	var hash []byte
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h := sha256.Sum256(manifest)
+		hash = h[:]
+	case "sha512":
+		h := sha512.Sum512(manifest)
+		hash = h[:]
+	default:
+		return false, fmt.Errorf("unsupported ssh signature hash %q", sig.HashAlgorithm)
+	}
+
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, hash})...)
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return false, fmt.Errorf("malformed ssh signature")
+	}
+
+	if err := k.key.Verify(signed, &signature); err != nil {
+		return false, fmt.Errorf("ssh signature does not match manifest")
+	}
+
+	return true, nil
+}
+
+/* verifyPupManifest checks a manifest against the signing keys of
+ * its source. It returns the resulting PUP_SIGNATURE_* status, and
+ * an error only when the source policy means the pup can't be used.
+ */
+func verifyPupManifest(config dogeboxd.ManifestSourceConfiguration, manifest []byte, signatures pupSignatures) (string, error) {
+	if config.SignaturePolicy == "" || config.SignaturePolicy == dogeboxd.SOURCE_SIGNATURE_POLICY_OFF {
+		return "", nil
+	}
+
+	status, err := checkPupSignatures(config.SigningKeys, manifest, signatures)
+	if err == nil {
+		return status, nil
+	}
+
+	if config.SignaturePolicy == dogeboxd.SOURCE_SIGNATURE_POLICY_REQUIRE {
+		return status, fmt.Errorf("%w: %s", dogeboxd.ErrPupSignatureInvalid, err)
+	}
+
+	log.Printf("Warning: source %s: %v", config.ID, err)
+	return status, nil
+}
+
+func checkPupSignatures(keys []string, manifest []byte, signatures pupSignatures) (string, error) {
+	if signatures.empty() {
+		return dogeboxd.PUP_SIGNATURE_UNSIGNED, fmt.Errorf("manifest is not signed")
+	}
+
+	if len(keys) == 0 {
+		return dogeboxd.PUP_SIGNATURE_INVALID, fmt.Errorf("source has no signing keys configured")
+	}
+
+	errs := []error{}
+	for _, k := range keys {
+		key, err := parseSigningKey(k)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		ok, err := key.verify(manifest, signatures)
+		if ok {
+			return dogeboxd.PUP_SIGNATURE_VERIFIED, nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return dogeboxd.PUP_SIGNATURE_INVALID, fmt.Errorf("manifest is not signed by any trusted key")
+	}
+
+	return dogeboxd.PUP_SIGNATURE_INVALID, errors.Join(errs...)
+}
+
+// readPupSignatures reads whichever signature files exist in dir.
+func readPupSignatures(readFile func(name string) ([]byte, error), dir string) pupSignatures {
+	minisig, _ := readFile(filepath.Join(dir, minisignSignatureFile))
+	sshsig, _ := readFile(filepath.Join(dir, sshSignatureFile))
+	return pupSignatures{minisig: minisig, sshsig: sshsig}
+}
+
+// verifyPupDirectory verifies a pup that has been downloaded to disk.
+func verifyPupDirectory(config dogeboxd.ManifestSourceConfiguration, path string) (string, error) {
+	manifest, err := os.ReadFile(filepath.Join(path, "manifest.json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest file: %w", err)
+	}
+
+	return verifyPupManifest(config, manifest, readPupSignatures(os.ReadFile, path))
+}
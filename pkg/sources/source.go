@@ -1,12 +1,15 @@
 package source
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 )
@@ -14,53 +17,129 @@ import (
 var REQUIRED_FILES = []string{"pup.nix", "manifest.json"}
 
 // TODO: This should take storeManager and manage state internally not via Statemanager
-func NewSourceManager(config dogeboxd.ServerConfig, sm dogeboxd.StateManager, pm dogeboxd.PupManager) dogeboxd.SourceManager {
+func NewSourceManager(config dogeboxd.ServerConfig, sm dogeboxd.StateManager, store *dogeboxd.StoreManager, pm dogeboxd.PupManager) dogeboxd.SourceManager {
 	state := sm.Get().Sources
 
-	sources := []dogeboxd.ManifestSource{}
-	for _, c := range state.SourceConfigs {
-		switch c.Type {
-		case "disk":
-			sources = append(sources, ManifestSourceDisk{config: c})
-		case "git":
-			sources = append(sources, &ManifestSourceGit{serverConfig: config, config: c})
+	sourceManager := sourceManager{
+		config:   config,
+		sm:       sm,
+		pm:       pm,
+		gitCache: dogeboxd.GetTypeStore[GitSourceCache](store),
+		sources:  []dogeboxd.ManifestSource{},
+	}
+
+	// dbx builds a source manager without a data dir, and doesn't need
+	// sideloading or credentials for private sources.
+	if config.DataDir != "" {
+		credentials, err := newCredentialStore(config, store)
+		if err != nil {
+			log.Printf("Failed to set up source credentials: %v", err)
 		}
+		sourceManager.credentials = credentials
 	}
 
-	log.Printf("Loaded %d sources", len(sources))
+	for _, c := range state.SourceConfigs {
+		if s := sourceManager.newSource(c); s != nil {
+			sourceManager.sources = append(sourceManager.sources, s)
+		}
+	}
 
-	sourceManager := sourceManager{
-		sm:      sm,
-		pm:      pm,
-		sources: sources,
+	if config.DataDir != "" {
+		if err := sourceManager.ensureSideloadSource(); err != nil {
+			log.Printf("Failed to set up sideloaded source: %v", err)
+		}
+		sourceManager.ensureMirrorSources()
 	}
 
+	log.Printf("Loaded %d sources", len(sourceManager.sources))
+
+	go sourceManager.watchInstalls()
+
 	return &sourceManager
 }
 
+// The index flags installed versions their source has changed,
+// so it has to be rebuilt whenever what's installed changes.
+func (sourceManager *sourceManager) watchInstalls() {
+	for p := range sourceManager.pm.GetUpdateChannel() {
+		switch p.Event {
+		case dogeboxd.PUP_CHANGED_INSTALLATION, dogeboxd.PUP_ADOPTED:
+			sourceManager.index.invalidate()
+		}
+	}
+}
+
 var _ dogeboxd.SourceManager = &sourceManager{}
 
 type sourceManager struct {
-	sm      dogeboxd.StateManager
-	pm      dogeboxd.PupManager
-	sources []dogeboxd.ManifestSource
+	config      dogeboxd.ServerConfig
+	sm          dogeboxd.StateManager
+	pm          dogeboxd.PupManager
+	gitCache    *dogeboxd.TypeStore[GitSourceCache]
+	credentials *credentialStore
+	sources     []dogeboxd.ManifestSource
+	sideloadMu  sync.Mutex
+	index       storeIndex
+}
+
+func (sourceManager *sourceManager) newSource(c dogeboxd.ManifestSourceConfiguration) dogeboxd.ManifestSource {
+	switch c.Type {
+	case "disk":
+		return ManifestSourceDisk{config: c}
+	case "git":
+		return &ManifestSourceGit{serverConfig: sourceManager.config, config: c, store: sourceManager.gitCache, credentials: sourceManager.credentials}
+	case "http":
+		return &ManifestSourceHTTP{serverConfig: sourceManager.config, config: c}
+	}
+	return nil
 }
 
 func (sourceManager *sourceManager) GetAll(ignoreCache bool) (map[string]dogeboxd.ManifestSourceList, error) {
 	available := map[string]dogeboxd.ManifestSourceList{}
+	devChannels := sourceManager.sm.Get().Sources.DevChannels
 
 	for _, r := range sourceManager.sources {
 		l, err := r.List(ignoreCache)
 		if err != nil {
+			// Sources listed before this one may have been refreshed,
+			// let the next store query pick them up.
+			sourceManager.index.invalidate()
 			return nil, err
 		}
 
-		available[l.Config.ID] = l
+		available[l.Config.ID] = sourceManager.storeListing(l, devChannels)
+	}
+
+	lists := []dogeboxd.ManifestSourceList{}
+	for _, l := range available {
+		lists = append(lists, l)
 	}
+	sourceManager.index.rebuild(lists)
 
 	return available, nil
 }
 
+/* storeListing is a source listing as the store shows it: without
+ * dev channel builds unless they're enabled, and with versions we
+ * have installed that the source now describes differently flagged.
+ */
+func (sourceManager *sourceManager) storeListing(l dogeboxd.ManifestSourceList, devChannels bool) dogeboxd.ManifestSourceList {
+	pups := []dogeboxd.ManifestSourcePup{}
+	for _, pup := range l.Pups {
+		if pup.DevChannel && !devChannels {
+			continue
+		}
+		for _, installed := range sourceManager.installedPins(l.Config, pup.Name, pup.Version) {
+			if !installed.Matches(pup.Pin) {
+				pup.SourceChanged = true
+			}
+		}
+		pups = append(pups, pup)
+	}
+	l.Pups = pups
+	return l
+}
+
 func (sourceManager *sourceManager) GetSourceManifest(sourceID, pupName, pupVersion string) (dogeboxd.PupManifest, dogeboxd.ManifestSource, error) {
 	for _, r := range sourceManager.sources {
 		c := r.Config()
@@ -87,7 +166,11 @@ func (sourceManager *sourceManager) GetSourcePup(sourceId, pupName, pupVersion s
 		return dogeboxd.ManifestSourcePup{}, err
 	}
 
-	l, err := r.List(false)
+	return findSourcePup(r, pupName, pupVersion, false)
+}
+
+func findSourcePup(r dogeboxd.ManifestSource, pupName, pupVersion string, ignoreCache bool) (dogeboxd.ManifestSourcePup, error) {
+	l, err := r.List(ignoreCache)
 	if err != nil {
 		return dogeboxd.ManifestSourcePup{}, err
 	}
@@ -113,40 +196,121 @@ func (sourceManager *sourceManager) GetSource(id string) (dogeboxd.ManifestSourc
 	return nil, fmt.Errorf("no source found with id %s", id)
 }
 
-func (sourceManager *sourceManager) DownloadPup(path, sourceId, pupName, pupVersion string) error {
+func (sourceManager *sourceManager) DownloadPup(path, sourceId, pupName, pupVersion string, acceptSourceChanges bool) (dogeboxd.PupSourcePin, error) {
 	r, err := sourceManager.GetSource(sourceId)
 	if err != nil {
-		return err
+		return dogeboxd.PupSourcePin{}, err
 	}
 
 	sourcePup, err := sourceManager.GetSourcePup(sourceId, pupName, pupVersion)
 	if err != nil {
-		return err
+		return dogeboxd.PupSourcePin{}, err
 	}
 
 	log.Printf("got source pup: %+v", sourcePup)
 
+	if err := sourceManager.checkPins(r.Config(), pupName, pupVersion, sourcePup.Pin, acceptSourceChanges); err != nil {
+		return dogeboxd.PupSourcePin{}, err
+	}
+
 	if err := r.Download(path, sourcePup.Location); err != nil {
-		return err
+		if !errors.Is(err, dogeboxd.ErrPupSourceChanged) {
+			return dogeboxd.PupSourcePin{}, err
+		}
+
+		// The ref moved since we listed it. List it again and check
+		// the pins against where it points now, so accepted changes
+		// go through and the pin we return is for what we installed.
+		log.Printf("Source %s moved %s %s since it was listed, listing it again: %v", sourceId, pupName, pupVersion, err)
+		sourcePup, err = findSourcePup(r, pupName, pupVersion, true)
+		if err != nil {
+			// ie: a dev channel build, whose version is its commit
+			return dogeboxd.PupSourcePin{}, fmt.Errorf("%w: %s %s is no longer listed by %s, a newer build may have replaced it", dogeboxd.ErrPupSourceChanged, pupName, pupVersion, r.Config().Name)
+		}
+
+		if err := sourceManager.checkPins(r.Config(), pupName, pupVersion, sourcePup.Pin, acceptSourceChanges); err != nil {
+			return dogeboxd.PupSourcePin{}, err
+		}
+
+		if err := r.Download(path, sourcePup.Location); err != nil {
+			return dogeboxd.PupSourcePin{}, err
+		}
+	}
+
+	// Listings may come from a cache or, for http sources, not be
+	// checked at all, so always verify what actually landed on disk.
+	if _, err := verifyPupDirectory(r.Config(), path); err != nil {
+		return dogeboxd.PupSourcePin{}, err
 	}
 
 	manifestPath := filepath.Join(path, "manifest.json")
 	manifestData, err := os.ReadFile(manifestPath)
 	if err != nil {
-		return fmt.Errorf("failed to read manifest file: %w", err)
+		return dogeboxd.PupSourcePin{}, fmt.Errorf("failed to read manifest file: %w", err)
 	}
 
 	var manifest dogeboxd.PupManifest
 	err = json.Unmarshal(manifestData, &manifest)
 	if err != nil {
-		return fmt.Errorf("failed to parse manifest file: %w", err)
+		return dogeboxd.PupSourcePin{}, fmt.Errorf("failed to parse manifest file: %w", err)
 	}
 
 	if err := manifest.Validate(); err != nil {
-		return fmt.Errorf("manifest validation failed: %w", err)
+		return dogeboxd.PupSourcePin{}, fmt.Errorf("manifest validation failed: %w", err)
+	}
+
+	if err := dogeboxd.ValidateManifestJSON(manifestData, manifest); err != nil {
+		return dogeboxd.PupSourcePin{}, err
+	}
+
+	pin := dogeboxd.PupSourcePin{
+		Commit:         sourcePup.Pin.Commit,
+		ManifestSha256: fmt.Sprintf("%x", sha256.Sum256(manifestData)),
+	}
+
+	// Whatever was listed, and possibly signed, is what has to be installed.
+	if sourcePup.Pin.ManifestSha256 != "" && sourcePup.Pin.ManifestSha256 != pin.ManifestSha256 {
+		return dogeboxd.PupSourcePin{}, fmt.Errorf("%w: the manifest downloaded for %s %s from %s is not the one it listed (expected %s, got %s)", dogeboxd.ErrPupSourceChanged, pupName, pupVersion, r.Config().Name, sourcePup.Pin.ManifestSha256, pin.ManifestSha256)
+	}
+
+	// The listing might be stale, so check what we actually got too.
+	if err := sourceManager.checkPins(r.Config(), pupName, pupVersion, pin, acceptSourceChanges); err != nil {
+		return dogeboxd.PupSourcePin{}, err
+	}
+
+	return pin, sourceManager.validatePupFiles(path)
+}
+
+// installedPins returns the pins of every installed pup of this version from this source.
+func (sourceManager *sourceManager) installedPins(config dogeboxd.ManifestSourceConfiguration, pupName, pupVersion string) []dogeboxd.PupSourcePin {
+	pins := []dogeboxd.PupSourcePin{}
+	for _, state := range sourceManager.pm.GetStateMap() {
+		if state.Source.ID != config.ID || state.Manifest.Meta.Name != pupName || state.Version != pupVersion {
+			continue
+		}
+		if state.SourcePin == (dogeboxd.PupSourcePin{}) {
+			continue
+		}
+		pins = append(pins, state.SourcePin)
 	}
+	return pins
+}
+
+func (sourceManager *sourceManager) checkPins(config dogeboxd.ManifestSourceConfiguration, pupName, pupVersion string, pin dogeboxd.PupSourcePin, acceptSourceChanges bool) error {
+	for _, installed := range sourceManager.installedPins(config, pupName, pupVersion) {
+		if installed.Matches(pin) {
+			continue
+		}
+
+		if acceptSourceChanges {
+			log.Printf("Source %s changed %s %s since it was installed, continuing as changes were accepted", config.ID, pupName, pupVersion)
+			return nil
+		}
 
-	return sourceManager.validatePupFiles(path)
+		return fmt.Errorf("%w: %s %s from %s no longer matches what was installed (commit %s, manifest %s)", dogeboxd.ErrPupSourceChanged, pupName, pupVersion, config.Name, installed.Commit, installed.ManifestSha256)
+	}
+
+	return nil
 }
 
 func (sourceManager *sourceManager) validatePupFiles(path string) error {
@@ -194,6 +358,11 @@ func (sourceManager *sourceManager) determineSourceType(location string) (string
 		return "git", nil
 	}
 
+	// Anything else over http(s) should be a static index.
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		return "http", nil
+	}
+
 	if strings.HasPrefix(location, "/") {
 		if _, err := os.Stat(location); err != nil {
 			return "", fmt.Errorf("location looks like disk path, but path %s does not exist", location)
@@ -205,7 +374,35 @@ func (sourceManager *sourceManager) determineSourceType(location string) (string
 	return "", fmt.Errorf("unknown source type: %s", location)
 }
 
-func (sourceManager *sourceManager) AddSource(location string) (dogeboxd.ManifestSource, error) {
+/* ListLocation lists a source without adding it, ie: for dbx to
+ * look at the default source of a pup's dependency. Nothing is
+ * cached, and private git sources aren't supported.
+ */
+func ListLocation(location string) (dogeboxd.ManifestSourceList, error) {
+	sourceManager := &sourceManager{}
+
+	sourceType, err := sourceManager.determineSourceType(location)
+	if err != nil {
+		return dogeboxd.ManifestSourceList{}, err
+	}
+
+	var config dogeboxd.ManifestSourceConfiguration
+	switch sourceType {
+	case "disk":
+		config, err = ManifestSourceDisk{}.ValidateFromLocation(location)
+	case "git":
+		config, err = ManifestSourceGit{}.ValidateFromLocation(location)
+	case "http":
+		config, err = ManifestSourceHTTP{}.ValidateFromLocation(location)
+	}
+	if err != nil {
+		return dogeboxd.ManifestSourceList{}, err
+	}
+
+	return sourceManager.newSource(config).List(true)
+}
+
+func (sourceManager *sourceManager) AddSource(location, credentialID string) (dogeboxd.ManifestSource, error) {
 	var c dogeboxd.ManifestSourceConfiguration
 	var s dogeboxd.ManifestSource
 
@@ -214,6 +411,10 @@ func (sourceManager *sourceManager) AddSource(location string) (dogeboxd.Manifes
 		return nil, err
 	}
 
+	if credentialID != "" && sourceType != "git" {
+		return nil, fmt.Errorf("credentials are only supported for git sources")
+	}
+
 	switch sourceType {
 	case "disk":
 		{
@@ -226,12 +427,28 @@ func (sourceManager *sourceManager) AddSource(location string) (dogeboxd.Manifes
 		}
 	case "git":
 		{
-			config, err := ManifestSourceGit{}.ValidateFromLocation(location)
+			// Listing tags already needs the credential for a private source.
+			validator := ManifestSourceGit{
+				config:      dogeboxd.ManifestSourceConfiguration{CredentialID: credentialID},
+				credentials: sourceManager.credentials,
+			}
+
+			config, err := validator.ValidateFromLocation(location)
 			if err != nil {
 				return nil, err
 			}
+			config.CredentialID = credentialID
 			c = config
-			s = &ManifestSourceGit{config: config}
+			s = sourceManager.newSource(config)
+		}
+	case "http":
+		{
+			config, err := ManifestSourceHTTP{}.ValidateFromLocation(location)
+			if err != nil {
+				return nil, err
+			}
+			c = config
+			s = &ManifestSourceHTTP{serverConfig: sourceManager.config, config: config}
 		}
 
 	default:
@@ -263,6 +480,10 @@ func (sourceManager *sourceManager) AddSource(location string) (dogeboxd.Manifes
 }
 
 func (sourceManager *sourceManager) RemoveSource(id string) error {
+	if id == dogeboxd.SOURCE_ID_SIDELOADED {
+		return fmt.Errorf("the built-in sideloaded source can't be removed")
+	}
+
 	var matched dogeboxd.ManifestSource
 	var matchedIndex int
 
@@ -291,10 +512,137 @@ func (sourceManager *sourceManager) RemoveSource(id string) error {
 		return err
 	}
 
+	if err := sourceManager.gitCache.Del(id); err != nil {
+		log.Printf("Failed to remove cached listing for source %s: %v", id, err)
+	}
+
 	return nil
 }
 
+func (sourceManager *sourceManager) SetSourceSigning(id string, keys []string, policy string) error {
+	if err := ValidateSignaturePolicy(policy); err != nil {
+		return err
+	}
+
+	if err := ValidateSigningKeys(keys); err != nil {
+		return err
+	}
+
+	if policy == dogeboxd.SOURCE_SIGNATURE_POLICY_REQUIRE && len(keys) == 0 {
+		return fmt.Errorf("at least one signing key is required to require signatures")
+	}
+
+	for i, r := range sourceManager.sources {
+		c := r.Config()
+		if c.ID != id {
+			continue
+		}
+
+		c.SigningKeys = keys
+		c.SignaturePolicy = policy
+
+		// Replace the source entirely, so nothing is served from a
+		// listing that was checked against the old keys.
+		sourceManager.sources[i] = sourceManager.newSource(c)
+
+		return sourceManager.Save()
+	}
+
+	return fmt.Errorf("no existing source id: %s", id)
+}
+
+/* SetSourceChannel makes a git source list the HEAD of a branch
+ * as a dev build of each of its pups. An empty channel goes
+ * back to tagged releases only.
+ */
+func (sourceManager *sourceManager) SetSourceChannel(id string, channel string) error {
+	for i, r := range sourceManager.sources {
+		c := r.Config()
+		if c.ID != id {
+			continue
+		}
+
+		if c.Type != "git" {
+			return fmt.Errorf("channels are only supported for git sources")
+		}
+
+		if channel != "" {
+			validator := ManifestSourceGit{config: c, credentials: sourceManager.credentials}
+			if _, err := validator.GetBranchHead(c.Location, channel); err != nil {
+				return err
+			}
+		}
+
+		c.Channel = channel
+		sourceManager.sources[i] = sourceManager.newSource(c)
+
+		return sourceManager.Save()
+	}
+
+	return fmt.Errorf("no existing source id: %s", id)
+}
+
+// SetDevChannels shows or hides dev channel builds in the store.
+func (sourceManager *sourceManager) SetDevChannels(enabled bool) error {
+	state := sourceManager.sm.Get().Sources
+	state.DevChannels = enabled
+
+	if err := sourceManager.sm.SetSources(state); err != nil {
+		return err
+	}
+
+	sourceManager.index.invalidate()
+	return nil
+}
+
+func (sourceManager *sourceManager) CreateSourceCredential(credentialType, username, token string) (dogeboxd.SourceCredentialInfo, error) {
+	if sourceManager.credentials == nil {
+		return dogeboxd.SourceCredentialInfo{}, errNoCredentialStore
+	}
+
+	credential, err := sourceManager.credentials.create(credentialType, username, token)
+	if err != nil {
+		return dogeboxd.SourceCredentialInfo{}, err
+	}
+
+	return credential.Info(), nil
+}
+
+func (sourceManager *sourceManager) ListSourceCredentials() ([]dogeboxd.SourceCredentialInfo, error) {
+	if sourceManager.credentials == nil {
+		return nil, errNoCredentialStore
+	}
+
+	credentials, err := sourceManager.credentials.list()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []dogeboxd.SourceCredentialInfo{}
+	for _, c := range credentials {
+		infos = append(infos, c.Info())
+	}
+
+	return infos, nil
+}
+
+func (sourceManager *sourceManager) DeleteSourceCredential(id string) error {
+	if sourceManager.credentials == nil {
+		return errNoCredentialStore
+	}
+
+	for _, c := range sourceManager.GetAllSourceConfigurations() {
+		if c.CredentialID == id {
+			return fmt.Errorf("credential is in use by source %s, remove it first", c.ID)
+		}
+	}
+
+	return sourceManager.credentials.delete(id)
+}
+
 func (sourceManager *sourceManager) Save() error {
+	sourceManager.index.invalidate()
+
 	state := sourceManager.sm.Get().Sources
 	state.SourceConfigs = sourceManager.GetAllSourceConfigurations()
 	return sourceManager.sm.SetSources(state)
@@ -0,0 +1,202 @@
+package source
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	STORE_QUERY_DEFAULT_LIMIT = 50
+	STORE_QUERY_MAX_LIMIT     = 200
+)
+
+/* storeIndex is a flattened, pre-lowercased view of every source
+ * listing, so store queries don't have to walk full manifests.
+ * It is rebuilt whenever the source listings are refreshed.
+ */
+type storeIndex struct {
+	mu      sync.RWMutex
+	built   bool
+	entries []storeIndexEntry
+}
+
+type storeIndexEntry struct {
+	pup      dogeboxd.StoreQueryPup
+	search   string
+	provides []string
+	requires []string
+}
+
+func (sourceManager *sourceManager) RebuildStoreIndex() {
+	lists := []dogeboxd.ManifestSourceList{}
+	devChannels := sourceManager.sm.Get().Sources.DevChannels
+
+	// Unlike GetAll, one broken source shouldn't empty the whole store.
+	for _, r := range sourceManager.sources {
+		l, err := r.List(false)
+		if err != nil {
+			log.Printf("Leaving source %s out of the store index: %v", r.Config().ID, err)
+			continue
+		}
+
+		lists = append(lists, sourceManager.storeListing(l, devChannels))
+	}
+
+	sourceManager.index.rebuild(lists)
+}
+
+func (t *storeIndex) rebuild(lists []dogeboxd.ManifestSourceList) {
+	entries := []storeIndexEntry{}
+	versions := map[string][]string{}
+
+	for _, l := range lists {
+		for _, pup := range l.Pups {
+			meta := pup.Manifest.Meta
+
+			entry := storeIndexEntry{
+				pup: dogeboxd.StoreQueryPup{
+					SourceID:         l.Config.ID,
+					Name:             pup.Name,
+					Version:          pup.Version,
+					ShortDescription: meta.ShortDescription,
+					LogoBase64:       pup.LogoBase64,
+					Provides:         []string{},
+					Requires:         []string{},
+					SignatureStatus:  pup.SignatureStatus,
+					SourceChanged:    pup.SourceChanged,
+				},
+				search: strings.ToLower(strings.Join([]string{pup.Name, meta.ShortDescription, meta.LongDescription}, "\n")),
+			}
+
+			for _, iface := range pup.Manifest.Interfaces {
+				entry.pup.Provides = append(entry.pup.Provides, iface.Name)
+			}
+			for _, dep := range pup.Manifest.Dependencies {
+				entry.pup.Requires = append(entry.pup.Requires, dep.InterfaceName)
+			}
+
+			key := l.Config.ID + "/" + pup.Name
+			versions[key] = append(versions[key], pup.Version)
+			entries = append(entries, entry)
+		}
+	}
+
+	for key := range versions {
+		sortVersionsDesc(versions[key])
+	}
+
+	for i := range entries {
+		entries[i].pup.Versions = versions[entries[i].pup.SourceID+"/"+entries[i].pup.Name]
+	}
+
+	// Name, then source, newest version first.
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].pup, entries[j].pup
+		if a.Name != b.Name {
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+		if a.SourceID != b.SourceID {
+			return a.SourceID < b.SourceID
+		}
+		return semver.Compare("v"+a.Version, "v"+b.Version) > 0
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = entries
+	t.built = true
+}
+
+// invalidate makes the next query rebuild the index, for when sources change.
+func (t *storeIndex) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.built = false
+}
+
+func sortVersionsDesc(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return semver.Compare("v"+versions[i], "v"+versions[j]) > 0
+	})
+}
+
+func (sourceManager *sourceManager) QueryStore(q dogeboxd.StoreQuery) (dogeboxd.StoreQueryResult, error) {
+	sourceManager.index.mu.RLock()
+	built := sourceManager.index.built
+	sourceManager.index.mu.RUnlock()
+
+	if !built {
+		sourceManager.RebuildStoreIndex()
+	}
+
+	// Installed state changes far more often than listings, so it isn't indexed.
+	installed := map[string]string{}
+	for _, p := range sourceManager.pm.GetStateMap() {
+		installed[p.Source.ID+"/"+p.Manifest.Meta.Name] = p.Version
+	}
+
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+
+	sourceManager.index.mu.RLock()
+	defer sourceManager.index.mu.RUnlock()
+
+	matches := []dogeboxd.StoreQueryPup{}
+	seen := map[string]bool{}
+
+	for _, e := range sourceManager.index.entries {
+		key := e.pup.SourceID + "/" + e.pup.Name
+
+		// Entries are sorted newest first, so the first match is the latest.
+		if !q.AllVersions && seen[key] {
+			continue
+		}
+
+		if q.SourceID != "" && e.pup.SourceID != q.SourceID {
+			continue
+		}
+		if text != "" && !strings.Contains(e.search, text) {
+			continue
+		}
+		if q.Provides != "" && !slices.Contains(e.pup.Provides, q.Provides) {
+			continue
+		}
+		if q.Requires != "" && !slices.Contains(e.pup.Requires, q.Requires) {
+			continue
+		}
+
+		pup := e.pup
+		pup.InstalledVersion, pup.Installed = installed[key]
+
+		if q.Installed != nil && *q.Installed != pup.Installed {
+			continue
+		}
+
+		seen[key] = true
+		matches = append(matches, pup)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = STORE_QUERY_DEFAULT_LIMIT
+	}
+	if limit > STORE_QUERY_MAX_LIMIT {
+		limit = STORE_QUERY_MAX_LIMIT
+	}
+
+	offset := max(q.Offset, 0)
+	start := min(offset, len(matches))
+	end := min(start+limit, len(matches))
+
+	return dogeboxd.StoreQueryResult{
+		Total:  len(matches),
+		Offset: offset,
+		Limit:  limit,
+		Pups:   matches[start:end],
+	}, nil
+}
@@ -2,6 +2,7 @@ package dogeboxd
 
 import (
 	"context"
+	"io"
 	"net"
 	"time"
 )
@@ -19,6 +20,9 @@ type SystemUpdater interface {
 	AddSSHKey(key string, l SubLogger) error
 	EnableSSH(l SubLogger) error
 	ListSSHKeys() ([]DogeboxStateSSHKey, error)
+
+	// Renders the nix changes an action would make, without applying them.
+	DryRunAction(a Action, options NixPatchDryRunOptions, l SubLogger) (NixPatchDryRunResult, error)
 }
 
 // monitors systemd services and returns stats
@@ -65,12 +69,27 @@ type DogeboxStateSSHConfig struct {
 	Keys    []DogeboxStateSSHKey `json:"keys"`
 }
 
+type DogeboxStateCustomNixConfig struct {
+	// Stops every custom nix module from being imported, without deleting them.
+	Disabled bool `json:"disabled"`
+}
+
+type DogeboxStateGarbageCollectionConfig struct {
+	// Run CollectGarbage automatically every IntervalHours.
+	Enabled         bool      `json:"enabled"`
+	IntervalHours   int       `json:"intervalHours"`
+	KeepGenerations int       `json:"keepGenerations"`
+	LastRun         time.Time `json:"lastRun"`
+}
+
 type DogeboxState struct {
-	InitialState  DogeboxStateInitialSetup
-	Hostname      string
-	KeyMap        string
-	SSH           DogeboxStateSSHConfig
-	StorageDevice string
+	InitialState      DogeboxStateInitialSetup
+	Hostname          string
+	KeyMap            string
+	SSH               DogeboxStateSSHConfig
+	CustomNix         DogeboxStateCustomNixConfig
+	GarbageCollection DogeboxStateGarbageCollectionConfig
+	StorageDevice     string
 }
 
 type NetworkState struct {
@@ -80,6 +99,26 @@ type NetworkState struct {
 
 type SourceState struct {
 	SourceConfigs []ManifestSourceConfiguration
+	Refresh       SourceRefreshConfig
+	Status        map[string]SourceStatus // by source ID
+	// Show dev channel builds in the store, see ManifestSourceConfiguration.Channel.
+	DevChannels bool
+}
+
+// Sources are refreshed in the background unless disabled. An
+// interval of zero uses the default.
+type SourceRefreshConfig struct {
+	Disabled        bool `json:"disabled"`
+	IntervalMinutes int  `json:"intervalMinutes"`
+}
+
+// SourceStatus is the outcome of the last background refresh of a source.
+type SourceStatus struct {
+	LastChecked time.Time `json:"lastChecked"`
+	Error       string    `json:"error,omitempty"`
+	Stale       bool      `json:"stale"`
+	// Every version of every pup seen, so we can tell what is new.
+	KnownVersions map[string][]string `json:"knownVersions"`
 }
 
 type State struct {
@@ -97,6 +136,11 @@ type StateManager interface {
 	SetSources(s SourceState) error
 }
 
+// runs privileged commands via _dbxroot on behalf of dogeboxd
+type DBXRoot interface {
+	Run(log SubLogger, args ...string) error
+}
+
 type LifecycleManager interface {
 	Shutdown()
 	Reboot()
@@ -180,24 +224,84 @@ type SourceManager interface {
 	GetSourceManifest(sourceId, pupName, pupVersion string) (PupManifest, ManifestSource, error)
 	GetSourcePup(sourceId, pupName, pupVersion string) (ManifestSourcePup, error)
 	GetSource(name string) (ManifestSource, error)
-	AddSource(location string) (ManifestSource, error)
+	AddSource(location, credentialID string) (ManifestSource, error)
 	RemoveSource(id string) error
-	DownloadPup(diskPath, sourceId, pupName, pupVersion string) error
+	SetSourceSigning(id string, keys []string, policy string) error
+	SetSourceChannel(id string, channel string) error
+	SetDevChannels(enabled bool) error
+	SideloadPup(archive io.Reader) (ManifestSourcePup, error)
+	CreateSourceCredential(credentialType, username, token string) (SourceCredentialInfo, error)
+	ListSourceCredentials() ([]SourceCredentialInfo, error)
+	DeleteSourceCredential(id string) error
+	DownloadPup(diskPath, sourceId, pupName, pupVersion string, acceptSourceChanges bool) (PupSourcePin, error)
 	GetAllSourceConfigurations() []ManifestSourceConfiguration
+	QueryStore(q StoreQuery) (StoreQueryResult, error)
+	ExportMirror(options MirrorExportOptions) (MirrorBundle, error)
+	ImportMirror(dir string) (ManifestSource, error)
+	ImportMirrorArchive(archive io.Reader) (ManifestSource, error)
+	RebuildStoreIndex()
+}
+
+/* StoreQuery filters the store index. Empty fields match
+ * everything. Text matches pup names and descriptions.
+ */
+type StoreQuery struct {
+	Text        string
+	Provides    string // an interface name some version must provide
+	Requires    string // an interface name some version depends on
+	SourceID    string
+	Installed   *bool
+	AllVersions bool // otherwise only the latest matching version of each pup
+	Offset      int
+	Limit       int
+}
+
+type StoreQueryResult struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Pups   []StoreQueryPup `json:"pups"`
+}
+
+// StoreQueryPup is a summary of one pup version, without its full manifest.
+type StoreQueryPup struct {
+	SourceID         string   `json:"sourceId"`
+	Name             string   `json:"name"`
+	Version          string   `json:"version"`
+	Versions         []string `json:"versions"` // every version the source has, newest first
+	ShortDescription string   `json:"shortDescription"`
+	LogoBase64       string   `json:"logoBase64"`
+	Provides         []string `json:"provides"`
+	Requires         []string `json:"requires"`
+	Installed        bool     `json:"installed"` // any version of this pup, from this source
+	InstalledVersion string   `json:"installedVersion,omitempty"`
+	SignatureStatus  string   `json:"signatureStatus,omitempty"`
+	SourceChanged    bool     `json:"sourceChanged,omitempty"`
 }
 
 type ManifestSourcePup struct {
-	Name       string
-	Location   map[string]string
-	Version    string
-	Manifest   PupManifest
-	LogoBase64 string
+	Name            string
+	Location        map[string]string
+	Version         string
+	Manifest        PupManifest
+	LogoBase64      string
+	SignatureStatus string // one of PUP_SIGNATURE_*, empty if not checked
+	Pin             PupSourcePin
+	// SourceChanged is set when an installed pup of this version was
+	// downloaded with a different pin than the source now lists.
+	SourceChanged bool
+	// DevChannel is set for builds of a source's channel branch,
+	// which are only shown once dev channels are enabled.
+	DevChannel bool
 }
 
 type ManifestSourceList struct {
 	Config      ManifestSourceConfiguration
 	LastChecked time.Time
 	Pups        []ManifestSourcePup
+	// Stale is set when the source couldn't be reached and
+	// this listing was served from a previous check.
+	Stale bool
 }
 
 type ManifestSource interface {
@@ -213,8 +317,53 @@ type ManifestSourceConfiguration struct {
 	Description string `json:"description"`
 	Location    string `json:"location"`
 	Type        string `json:"type"`
+
+	// Minisign or SSH public keys trusted to sign pup manifests
+	// from this source, and what to do when a pup isn't signed.
+	SigningKeys     []string `json:"signingKeys,omitempty"`
+	SignaturePolicy string   `json:"signaturePolicy,omitempty"`
+
+	// Private sources authenticate with a stored SourceCredential.
+	CredentialID string `json:"credentialId,omitempty"`
+
+	// A git branch whose HEAD is listed as a dev build of each
+	// pup, alongside the tagged releases.
+	Channel string `json:"channel,omitempty"`
+}
+
+// Source credential types
+const (
+	SOURCE_CREDENTIAL_SSH   string = "ssh"   // a deploy key generated on this box
+	SOURCE_CREDENTIAL_TOKEN string = "token" // an HTTPS username and access token
+)
+
+// SourceCredentialInfo is everything about a source credential
+// that is safe to show, it never includes the secret itself.
+type SourceCredentialInfo struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	PublicKey string    `json:"publicKey,omitempty"` // add this as a deploy key
+	Username  string    `json:"username,omitempty"`
+	Created   time.Time `json:"created"`
 }
 
+// The built-in disk source that holds sideloaded pups.
+const SOURCE_ID_SIDELOADED string = "sideloaded"
+
+// Source signature policies, an empty policy is the same as off.
+const (
+	SOURCE_SIGNATURE_POLICY_REQUIRE string = "require"
+	SOURCE_SIGNATURE_POLICY_WARN    string = "warn"
+	SOURCE_SIGNATURE_POLICY_OFF     string = "off"
+)
+
+// Pup signature statuses
+const (
+	PUP_SIGNATURE_VERIFIED string = "verified"
+	PUP_SIGNATURE_UNSIGNED string = "unsigned"
+	PUP_SIGNATURE_INVALID  string = "invalid"
+)
+
 type EnvEntry struct {
 	KEY string
 	VAL string
@@ -290,8 +439,11 @@ type NixSystemTemplateValues struct {
 }
 
 type NixIncludesFileTemplateValues struct {
-	NIX_DIR string
-	PUP_IDS []string
+	NIX_DIR                string
+	PUP_IDS                []string
+	CUSTOM_MODULES_ENABLED bool
+	// Filled in from NixDir/custom when the patch is applied.
+	CUSTOM_MODULES []string
 }
 
 type NixNetworkTemplateValues struct {
@@ -308,15 +460,61 @@ type NixStorageOverlayTemplateValues struct {
 	DBX_UID        string
 }
 
+type NixGarbageCollectOptions struct {
+	// The newest KeepGenerations system generations are kept, along
+	// with the current and last-known-good dogebox generations.
+	KeepGenerations int
+	DryRun          bool
+}
+
 type NixPatchApplyOptions struct {
 	RebuildBoot        bool
 	DangerousNoRebuild bool
 }
 
+type NixPatchDryRunOptions struct {
+	// Also check every changed file parses, then evaluate the whole
+	// system configuration with the changes in place.
+	Evaluate bool
+}
+
+type NixPatchFileDiff struct {
+	Filename  string `json:"filename"`
+	Created   bool   `json:"created"`
+	Removed   bool   `json:"removed"`
+	Diff      string `json:"diff"`                // unified diff against the current file
+	EvalError string `json:"evalError,omitempty"` // parse error, only set when Evaluate was requested
+}
+
+type NixPatchDryRunResult struct {
+	Operations []string           `json:"operations"`
+	Files      []NixPatchFileDiff `json:"files"`
+	Evaluated  bool               `json:"evaluated"`
+	EvalError  string             `json:"evalError,omitempty"` // evaluating the system configuration failed
+}
+
+// A NixGeneration is a copy of NixDir recorded after a patch was applied.
+type NixGeneration struct {
+	Number          int               `json:"number"`
+	PatchID         string            `json:"patchId"`
+	JobID           string            `json:"jobId"` // empty if not triggered by a job
+	Operations      []string          `json:"operations"`
+	NixOSGeneration int               `json:"nixosGeneration"` // 0 if the patch did not rebuild
+	Created         time.Time         `json:"created"`
+	Pups            map[string]string `json:"pups,omitempty"` // installed pup ID -> version, unset if recorded by dbx
+}
+
+// A user supplied nix module, stored as NixDir/custom/<name>.nix
+type NixCustomModule struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
 type NixPatch interface {
 	State() string
 	Apply() error
 	ApplyCustom(options NixPatchApplyOptions) error
+	DryRun(options NixPatchDryRunOptions) (NixPatchDryRunResult, error)
 
 	Cancel() error
 
@@ -328,12 +526,15 @@ type NixPatch interface {
 	WritePupFile(pupId string, values NixPupContainerTemplateValues)
 	RemovePupFile(pupId string)
 	UpdateStorageOverlay(values NixStorageOverlayTemplateValues)
+	RestoreGeneration(number int)
+	WriteCustomModule(name string, content string)
+	RemoveCustomModule(name string)
 }
 
 type NixManager interface {
 	// NixPatch passthrough helpers.
 	InitSystem(patch NixPatch, dbxState DogeboxState)
-	UpdateIncludesFile(patch NixPatch, pups PupManager)
+	UpdateIncludesFile(patch NixPatch, pups PupManager, dbxState DogeboxState)
 	WritePupFile(patch NixPatch, state PupState, dbxState DogeboxState)
 	RemovePupFile(patch NixPatch, pupId string)
 	UpdateSystemContainerConfiguration(patch NixPatch)
@@ -341,9 +542,19 @@ type NixManager interface {
 	UpdateNetwork(patch NixPatch, values NixNetworkTemplateValues)
 	UpdateSystem(patch NixPatch, values NixSystemTemplateValues)
 	UpdateStorageOverlay(patch NixPatch, partitionName string)
+	RestoreGeneration(patch NixPatch, number int)
+	WriteCustomModule(patch NixPatch, name string, content string)
+	RemoveCustomModule(patch NixPatch, name string)
+
+	ListGenerations() ([]NixGeneration, error)
+	DiffGenerations(from, to int) ([]NixPatchFileDiff, error)
+	ListCustomModules() ([]NixCustomModule, error)
+	GetCustomModule(name string) (NixCustomModule, error)
 
 	RebuildBoot(log SubLogger) error
 	Rebuild(log SubLogger) error
+	CollectGarbage(options NixGarbageCollectOptions, log SubLogger) error
+	ExportMirrorClosures(bundleDir string, log SubLogger) error
 
 	NewPatch(log SubLogger) NixPatch
 }
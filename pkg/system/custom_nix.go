@@ -0,0 +1,77 @@
+package system
+
+import (
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* Custom nix modules are applied through a NixPatch like everything
+ * else, so a module that fails to build is rolled back along with
+ * the includes file that imported it.
+ */
+
+func (t SystemUpdater) writeCustomNixModule(a dogeboxd.WriteCustomNixModule, log dogeboxd.SubLogger) error {
+	if err := dogeboxd.ValidateNixCustomModuleName(a.Name); err != nil {
+		log.Errf("%v", err)
+		return err
+	}
+
+	log.Logf("Writing custom nix module %s", a.Name)
+
+	patch := t.nix.NewPatch(log)
+	t.nix.WriteCustomModule(patch, a.Name, a.Content)
+	t.nix.UpdateIncludesFile(patch, t.pupManager, t.sm.Get().Dogebox)
+
+	if err := patch.Apply(); err != nil {
+		log.Errf("Failed to apply custom nix module %s: %v", a.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+func (t SystemUpdater) removeCustomNixModule(a dogeboxd.RemoveCustomNixModule, log dogeboxd.SubLogger) error {
+	if err := dogeboxd.ValidateNixCustomModuleName(a.Name); err != nil {
+		log.Errf("%v", err)
+		return err
+	}
+
+	log.Logf("Removing custom nix module %s", a.Name)
+
+	patch := t.nix.NewPatch(log)
+	t.nix.RemoveCustomModule(patch, a.Name)
+	t.nix.UpdateIncludesFile(patch, t.pupManager, t.sm.Get().Dogebox)
+
+	if err := patch.Apply(); err != nil {
+		log.Errf("Failed to remove custom nix module %s: %v", a.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// Disabling keeps the modules on disk but stops dogebox.nix importing
+// them. This is available in recovery mode to get out of a broken module.
+func (t SystemUpdater) setCustomNixModulesDisabled(disabled bool, log dogeboxd.SubLogger) error {
+	state := t.sm.Get().Dogebox
+	previous := state.CustomNix.Disabled
+	state.CustomNix.Disabled = disabled
+
+	if err := t.sm.SetDogebox(state); err != nil {
+		return err
+	}
+
+	patch := t.nix.NewPatch(log)
+	t.nix.UpdateIncludesFile(patch, t.pupManager, state)
+
+	if err := patch.Apply(); err != nil {
+		log.Errf("Failed to update custom nix modules: %v", err)
+
+		state.CustomNix.Disabled = previous
+		if err := t.sm.SetDogebox(state); err != nil {
+			log.Errf("Failed to restore custom nix module state: %v", err)
+		}
+		return err
+	}
+
+	return nil
+}
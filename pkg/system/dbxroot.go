@@ -0,0 +1,23 @@
+package system
+
+import (
+	"os/exec"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+var _ dogeboxd.DBXRoot = DBXRoot{}
+
+func NewDBXRoot() dogeboxd.DBXRoot {
+	return DBXRoot{}
+}
+
+// DBXRoot runs _dbxroot through sudo, which is the only
+// way dogeboxd is allowed to do anything as root.
+type DBXRoot struct{}
+
+func (t DBXRoot) Run(log dogeboxd.SubLogger, args ...string) error {
+	cmd := exec.Command("sudo", append([]string{"_dbxroot"}, args...)...)
+	log.LogCmd(cmd)
+	return cmd.Run()
+}
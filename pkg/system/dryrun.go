@@ -0,0 +1,85 @@
+package system
+
+import (
+	"fmt"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* DryRunAction builds the same nix patch the SystemUpdater would
+ * build for a pup or rollback action, but renders it into a
+ * scratch directory instead of applying it. No pup state is
+ * modified.
+ */
+func (t SystemUpdater) DryRunAction(a dogeboxd.Action, options dogeboxd.NixPatchDryRunOptions, l dogeboxd.SubLogger) (dogeboxd.NixPatchDryRunResult, error) {
+	dbxState := t.sm.Get().Dogebox
+	patch := t.nix.NewPatch(l)
+	defer patch.Cancel()
+
+	switch a := a.(type) {
+	case dogeboxd.EnablePup:
+		s, _, err := t.pupManager.GetPup(a.PupID)
+		if err != nil {
+			return dogeboxd.NixPatchDryRunResult{}, err
+		}
+		s.Enabled = true
+		t.nix.WritePupFile(patch, s, dbxState)
+
+	case dogeboxd.DisablePup:
+		s, _, err := t.pupManager.GetPup(a.PupID)
+		if err != nil {
+			return dogeboxd.NixPatchDryRunResult{}, err
+		}
+		s.Enabled = false
+		t.nix.WritePupFile(patch, s, dbxState)
+
+	case dogeboxd.UninstallPup:
+		s, _, err := t.pupManager.GetPup(a.PupID)
+		if err != nil {
+			return dogeboxd.NixPatchDryRunResult{}, err
+		}
+		s.Installation = dogeboxd.STATE_UNINSTALLING
+		t.nix.RemovePupFile(patch, s.ID)
+		t.nix.UpdateIncludesFile(patch, withPupState(t.pupManager, s), dbxState)
+
+	case dogeboxd.PurgePup:
+		// Purging only touches pup storage, there is nothing to render.
+		if _, _, err := t.pupManager.GetPup(a.PupID); err != nil {
+			return dogeboxd.NixPatchDryRunResult{}, err
+		}
+
+	case dogeboxd.RollbackNixGeneration:
+		t.nix.RestoreGeneration(patch, a.Generation)
+
+	case dogeboxd.WriteCustomNixModule:
+		t.nix.WriteCustomModule(patch, a.Name, a.Content)
+		t.nix.UpdateIncludesFile(patch, t.pupManager, dbxState)
+
+	case dogeboxd.RemoveCustomNixModule:
+		t.nix.RemoveCustomModule(patch, a.Name)
+		t.nix.UpdateIncludesFile(patch, t.pupManager, dbxState)
+
+	default:
+		return dogeboxd.NixPatchDryRunResult{}, fmt.Errorf("action %T does not support dry run", a)
+	}
+
+	return patch.DryRun(options)
+}
+
+// pupManagerOverlay reports a hypothetical state for one pup
+// from GetStateMap, so nix templates can be rendered as if an
+// action had already changed it.
+type pupManagerOverlay struct {
+	dogeboxd.PupManager
+	state dogeboxd.PupState
+}
+
+func withPupState(pups dogeboxd.PupManager, state dogeboxd.PupState) dogeboxd.PupManager {
+	return pupManagerOverlay{PupManager: pups, state: state}
+}
+
+func (t pupManagerOverlay) GetStateMap() map[string]dogeboxd.PupState {
+	states := t.PupManager.GetStateMap()
+	states[t.state.ID] = t.state
+	return states
+}
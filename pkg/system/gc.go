@@ -0,0 +1,87 @@
+package system
+
+import (
+	"context"
+	"log"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+// How often the scheduler checks whether garbage collection is due.
+const GC_SCHEDULE_CHECK_INTERVAL = 10 * time.Minute
+
+func (t SystemUpdater) collectGarbage(a dogeboxd.CollectGarbage, log dogeboxd.SubLogger) error {
+	err := t.nix.CollectGarbage(dogeboxd.NixGarbageCollectOptions{
+		KeepGenerations: a.KeepGenerations,
+		DryRun:          a.DryRun,
+	}, log)
+	if err != nil || a.DryRun {
+		return err
+	}
+
+	state := t.sm.Get().Dogebox
+	state.GarbageCollection.LastRun = time.Now()
+	return t.sm.SetDogebox(state)
+}
+
+/* GarbageCollectionScheduler queues a CollectGarbage action
+ * whenever the schedule in DogeboxState says one is due.
+ */
+type GarbageCollectionScheduler struct {
+	sm  dogeboxd.StateManager
+	dbx dogeboxd.Dogeboxd
+}
+
+func NewGarbageCollectionScheduler(sm dogeboxd.StateManager, dbx dogeboxd.Dogeboxd) GarbageCollectionScheduler {
+	return GarbageCollectionScheduler{
+		sm:  sm,
+		dbx: dbx,
+	}
+}
+
+func (t GarbageCollectionScheduler) Run(started, stopped chan bool, stop chan context.Context) error {
+	go func() {
+		go func() {
+			ticker := time.NewTicker(GC_SCHEDULE_CHECK_INTERVAL)
+			defer ticker.Stop()
+		mainloop:
+			for {
+				select {
+				case <-stop:
+					break mainloop
+				case <-ticker.C:
+					t.check()
+				}
+			}
+		}()
+
+		started <- true
+		<-stop
+		stopped <- true
+	}()
+	return nil
+}
+
+func (t GarbageCollectionScheduler) check() {
+	state := t.sm.Get().Dogebox
+	schedule := state.GarbageCollection
+
+	if !schedule.Enabled || schedule.IntervalHours <= 0 {
+		return
+	}
+
+	if time.Since(schedule.LastRun) < time.Duration(schedule.IntervalHours)*time.Hour {
+		return
+	}
+
+	// Mark it as run now so we don't queue it again while it's in progress.
+	state.GarbageCollection.LastRun = time.Now()
+	if err := t.sm.SetDogebox(state); err != nil {
+		log.Printf("Failed to update garbage collection schedule: %v", err)
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.CollectGarbage{KeepGenerations: schedule.KeepGenerations})
+	log.Printf("Queued scheduled garbage collection %s", id)
+}
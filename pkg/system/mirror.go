@@ -0,0 +1,73 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+)
+
+func (t SystemUpdater) exportSourceMirror(a dogeboxd.ExportSourceMirror, log dogeboxd.SubLogger) error {
+	if a.Path == "" {
+		return fmt.Errorf("no path to export to")
+	}
+
+	if _, err := os.Stat(a.Path); err == nil {
+		return fmt.Errorf("%s already exists", a.Path)
+	}
+
+	// Archives are put together in a directory first, then packed.
+	bundleDir := a.Path
+	if a.Archive {
+		tmp, err := os.MkdirTemp(t.config.TmpDir, "mirror-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		bundleDir = filepath.Join(tmp, a.SourceID)
+	}
+
+	log.Logf("Exporting source %s to %s", a.SourceID, a.Path)
+
+	bundle, err := t.sources.ExportMirror(dogeboxd.MirrorExportOptions{
+		SourceID: a.SourceID,
+		Pups:     a.Pups,
+		Dest:     bundleDir,
+	})
+	if err != nil {
+		log.Errf("Failed to export mirror: %v", err)
+		return err
+	}
+
+	log.Logf("Exported %d pups", len(bundle.Pups))
+
+	if a.Closures {
+		if err := t.nix.ExportMirrorClosures(bundleDir, log); err != nil {
+			if !a.Archive {
+				os.RemoveAll(bundleDir)
+			}
+			return err
+		}
+	}
+
+	if !a.Archive {
+		return nil
+	}
+
+	f, err := os.OpenFile(a.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := utils.WriteTarGz(bundleDir, f, nil); err != nil {
+		os.Remove(a.Path)
+		log.Errf("Failed to write archive: %v", err)
+		return err
+	}
+
+	log.Logf("Wrote %s", a.Path)
+	return nil
+}
@@ -0,0 +1,85 @@
+package nix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+// User supplied modules live in their own directory under NixDir,
+// so they are versioned with every generation like everything else.
+const customModulesDir = "custom"
+
+func customModulePath(nixDir, name string) string {
+	return filepath.Join(nixDir, customModulesDir, name+".nix")
+}
+
+func listCustomModuleNames(nixDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(nixDir, customModulesDir))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custom modules: %w", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".nix")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		// Ignore anything that wasn't written by us.
+		if dogeboxd.ValidateNixCustomModuleName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (nm nixManager) ListCustomModules() ([]dogeboxd.NixCustomModule, error) {
+	names, err := listCustomModuleNames(nm.config.NixDir)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := []dogeboxd.NixCustomModule{}
+	for _, name := range names {
+		module, err := nm.GetCustomModule(name)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, module)
+	}
+
+	return modules, nil
+}
+
+func (nm nixManager) GetCustomModule(name string) (dogeboxd.NixCustomModule, error) {
+	if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+		return dogeboxd.NixCustomModule{}, err
+	}
+
+	content, err := os.ReadFile(customModulePath(nm.config.NixDir, name))
+	if err != nil {
+		return dogeboxd.NixCustomModule{}, fmt.Errorf("failed to read custom module %s: %w", name, err)
+	}
+
+	return dogeboxd.NixCustomModule{Name: name, Content: string(content)}, nil
+}
+
+func (nm nixManager) WriteCustomModule(nixPatch dogeboxd.NixPatch, name string, content string) {
+	nixPatch.WriteCustomModule(name, content)
+}
+
+func (nm nixManager) RemoveCustomModule(nixPatch dogeboxd.NixPatch, name string) {
+	nixPatch.RemoveCustomModule(name)
+}
@@ -0,0 +1,235 @@
+package nix
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+const diffContextLines = 3
+
+// diffDirectories compares every regular file in oldDir and newDir
+// and returns a unified diff for each one that differs.
+func diffDirectories(oldDir, newDir string) ([]dogeboxd.NixPatchFileDiff, error) {
+	oldFiles, err := readDirectoryFiles(oldDir)
+	if err != nil {
+		return nil, err
+	}
+
+	newFiles, err := readDirectoryFiles(newDir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range oldFiles {
+		names[name] = true
+	}
+	for name := range newFiles {
+		names[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	diffs := []dogeboxd.NixPatchFileDiff{}
+
+	for _, name := range sorted {
+		oldContent, hadOld := oldFiles[name]
+		newContent, hasNew := newFiles[name]
+
+		if hadOld && hasNew && oldContent == newContent {
+			continue
+		}
+
+		oldName := "a/" + name
+		newName := "b/" + name
+		if !hadOld {
+			oldName = "/dev/null"
+		}
+		if !hasNew {
+			newName = "/dev/null"
+		}
+
+		diffs = append(diffs, dogeboxd.NixPatchFileDiff{
+			Filename: name,
+			Created:  !hadOld,
+			Removed:  !hasNew,
+			Diff:     unifiedDiff(oldName, newName, oldContent, newContent),
+		})
+	}
+
+	return diffs, nil
+}
+
+func readDirectoryFiles(dir string) (map[string]string, error) {
+	files := map[string]string{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		files[relPath] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	return files, nil
+}
+
+type diffLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// unifiedDiff renders a minimal line-based unified diff between two strings.
+func unifiedDiff(oldName, newName, oldContent, newContent string) string {
+	lines := diffLines(splitLines(oldContent), splitLines(newContent))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(lines); {
+		// Find the next change.
+		for start < len(lines) && lines[start].kind == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Extend the hunk until we see more than two context-widths of unchanged lines.
+		hunkStart := max(start-diffContextLines, 0)
+		end := start
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+
+			run := end
+			for run < len(lines) && lines[run].kind == ' ' {
+				run++
+			}
+			if run == len(lines) || run-end > 2*diffContextLines {
+				end = min(end+diffContextLines, len(lines))
+				break
+			}
+			end = run
+		}
+
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:hunkStart] {
+			if l.kind != '+' {
+				oldStart++
+			}
+			if l.kind != '-' {
+				newStart++
+			}
+		}
+
+		oldCount, newCount := 0, 0
+		for _, l := range lines[hunkStart:end] {
+			if l.kind != '+' {
+				oldCount++
+			}
+			if l.kind != '-' {
+				newCount++
+			}
+		}
+
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, l := range lines[hunkStart:end] {
+			out.WriteByte(l.kind)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines produces an edit script using the longest common subsequence
+// of both inputs. Nix files are small, so the quadratic table is fine.
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+
+	return lines
+}
@@ -0,0 +1,157 @@
+package nix
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// nixPath marks a string that should be rendered as a nix path literal.
+type nixPath string
+
+var (
+	nixIdentifierPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'-]*$`)
+	nixPathPattern       = regexp.MustCompile(`^/[a-zA-Z0-9._+-]+(/[a-zA-Z0-9._+-]+)*$`)
+)
+
+// Helpers available to every nix template. Anything that didn't
+// come from the template itself must go through one of these.
+var templateFuncs = template.FuncMap{
+	"nix":         encodeNix,
+	"nixStr":      encodeNixString,
+	"nixPath":     func(s string) (string, error) { return encodeNix(nixPath(s)) },
+	"nixAttr":     encodeNixAttrName,
+	"nixIndented": escapeNixIndentedString,
+	"nixComment":  nixComment,
+	"list":        func(values ...any) []any { return values },
+}
+
+// encodeNix renders a go value as a nix expression. Strings, bools,
+// numbers, slices (lists), string keyed maps (attrsets) and nixPath
+// are supported.
+func encodeNix(value any) (string, error) {
+	if p, ok := value.(nixPath); ok {
+		if !nixPathPattern.MatchString(string(p)) {
+			if !strings.HasPrefix(string(p), "/") {
+				return "", fmt.Errorf("nix path must be absolute, got %q", p)
+			}
+			// Paths with unusual characters can't be written as a literal.
+			return "(/. + " + encodeNixString(string(p)) + ")", nil
+		}
+		return string(p), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return encodeNixString(v.String()), nil
+
+	case reflect.Bool:
+		return fmt.Sprintf("%t", v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%d", v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d", v.Uint()), nil
+
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := encodeNix(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			return "[ ]", nil
+		}
+		return "[ " + strings.Join(items, " ") + " ]", nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("cannot encode map with %s keys as a nix attrset", v.Type().Key())
+		}
+
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+
+		var out strings.Builder
+		out.WriteString("{ ")
+		for _, key := range keys {
+			item, err := encodeNix(v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).Interface())
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&out, "%s = %s; ", encodeNixAttrName(key), item)
+		}
+		out.WriteString("}")
+		return out.String(), nil
+	}
+
+	return "", fmt.Errorf("cannot encode %T as a nix value", value)
+}
+
+// encodeNixString renders s as a double quoted nix string.
+func encodeNixString(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case '$':
+			// Only ${ starts an interpolation, but escaping every $ is harmless.
+			out.WriteString(`\$`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+var nixKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "assert": true, "with": true,
+	"let": true, "in": true, "rec": true, "inherit": true, "or": true,
+}
+
+// encodeNixAttrName leaves plain identifiers alone and quotes everything else.
+func encodeNixAttrName(name string) string {
+	if nixIdentifierPattern.MatchString(name) && !nixKeywords[name] {
+		return name
+	}
+	return encodeNixString(name)
+}
+
+// escapeNixIndentedString escapes s for use inside a nix indented (double single quote) string.
+func escapeNixIndentedString(s string) string {
+	s = strings.ReplaceAll(s, "''", "'''")
+	return strings.ReplaceAll(s, "${", "''${")
+}
+
+// nixComment makes s safe to put after a # in either nix or a shell
+// script inside an indented string, by keeping it on a single line.
+func nixComment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, s)
+	return escapeNixIndentedString(s)
+}
@@ -0,0 +1,186 @@
+package nix
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+)
+
+const defaultKeepGenerations = 5
+
+func (nm nixManager) CollectGarbage(options dogeboxd.NixGarbageCollectOptions, log dogeboxd.SubLogger) error {
+	if options.KeepGenerations <= 0 {
+		options.KeepGenerations = defaultKeepGenerations
+	}
+
+	toDelete, err := nm.collectableSystemGenerations(options.KeepGenerations)
+	if err != nil {
+		log.Errf("Failed to work out which generations to delete: %v", err)
+		return err
+	}
+
+	if len(toDelete) == 0 {
+		log.Log("No system generations to delete")
+	}
+
+	generations := make([]string, len(toDelete))
+	for i, number := range toDelete {
+		generations[i] = strconv.Itoa(number)
+	}
+
+	args := []string{"nix", "gc", "--delete-generations", strings.Join(generations, ",")}
+	if options.DryRun {
+		args = append(args, "--dry-run")
+	}
+
+	if err := nm.dbxRoot.Run(&gcProgressLogger{SubLogger: log}, args...); err != nil {
+		log.Errf("Error collecting nix garbage: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// collectableSystemGenerations returns every NixOS system generation
+// except the newest keep, the active one, and the one our last
+// successful nix patch rebuilt into.
+func (nm nixManager) collectableSystemGenerations(keep int) ([]int, error) {
+	all, err := listSystemGenerations()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(all) == 0 {
+		return []int{}, nil
+	}
+
+	current, err := currentNixOSGeneration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current system generation: %w", err)
+	}
+
+	keepSet := map[int]bool{current: true}
+
+	dogeboxGenerations, err := nm.ListGenerations()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(dogeboxGenerations) - 1; i >= 0; i-- {
+		if dogeboxGenerations[i].NixOSGeneration != 0 {
+			keepSet[dogeboxGenerations[i].NixOSGeneration] = true
+			break
+		}
+	}
+
+	for i := max(len(all)-keep, 0); i < len(all); i++ {
+		keepSet[all[i]] = true
+	}
+
+	toDelete := []int{}
+	for _, number := range all {
+		if !keepSet[number] {
+			toDelete = append(toDelete, number)
+		}
+	}
+
+	return toDelete, nil
+}
+
+// listSystemGenerations returns the numbers of every system-N-link, oldest first.
+func listSystemGenerations() ([]int, error) {
+	entries, err := os.ReadDir(filepath.Dir(nixOSSystemProfile))
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	generations := []int{}
+	for _, entry := range entries {
+		var number int
+		if _, err := fmt.Sscanf(entry.Name(), "system-%d-link", &number); err == nil {
+			generations = append(generations, number)
+		}
+	}
+	sort.Ints(generations)
+
+	return generations, nil
+}
+
+/* gcProgressLogger turns the "Reclaimable" lines printed by
+ * _dbxroot nix gc, and each "deleting" line from nix-store --gc,
+ * into step progress in bytes freed instead of logging every
+ * deleted path.
+ */
+type gcProgressLogger struct {
+	dogeboxd.SubLogger
+
+	mu          sync.Mutex
+	sizes       map[string]int64 // reclaimable store path -> bytes
+	total       int
+	totalBytes  int64
+	deleted     int
+	freedBytes  int64
+	lastPercent int
+}
+
+func (t *gcProgressLogger) LogCmd(cmd *exec.Cmd) {
+	cmd.Stdout = dogeboxd.NewLineWriter(t.line)
+	cmd.Stderr = dogeboxd.NewLineWriter(t.line)
+}
+
+func (t *gcProgressLogger) line(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var path string
+	var paths int
+	var size int64
+	if _, err := fmt.Sscanf(s, "Reclaimable path: %s %d", &path, &size); err == nil {
+		if t.sizes == nil {
+			t.sizes = map[string]int64{}
+		}
+		t.sizes[path] = size
+		return
+	}
+
+	if _, err := fmt.Sscanf(s, "Reclaimable: %d store paths, %d bytes", &paths, &size); err == nil {
+		t.total = paths
+		t.totalBytes = size
+		t.SubLogger.Logf("%s can be reclaimed (%d store paths)", utils.PrettyPrintDiskSize(size), paths)
+		return
+	}
+
+	if strings.HasPrefix(s, "deleting '/nix/store/") {
+		t.deleted++
+		t.freedBytes += t.sizes[strings.TrimSuffix(strings.TrimPrefix(s, "deleting '"), "'")]
+		if t.totalBytes == 0 {
+			return
+		}
+
+		percent := int(min(t.freedBytes*100/t.totalBytes, 99))
+		if percent != t.lastPercent {
+			t.lastPercent = percent
+			t.SubLogger.Progress(percent).Logf("Freed %s of %s (%d/%d store paths)", utils.PrettyPrintDiskSize(t.freedBytes), utils.PrettyPrintDiskSize(t.totalBytes), t.deleted, t.total)
+		}
+		return
+	}
+
+	// nix-store --gc finishes with ie: "1234 store paths deleted, 567.89 MiB freed"
+	var mib float64
+	if _, err := fmt.Sscanf(s, "%d store paths deleted, %f MiB freed", &paths, &mib); err == nil {
+		t.SubLogger.Logf("Freed %s (%d store paths)", utils.PrettyPrintDiskSize(int64(mib*1024*1024)), paths)
+		return
+	}
+
+	t.SubLogger.Log(s)
+}
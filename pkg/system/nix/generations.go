@@ -0,0 +1,224 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+const (
+	// How many generations we keep around before pruning the oldest.
+	maxNixGenerations = 50
+
+	nixOSSystemProfile = "/nix/var/nix/profiles/system"
+)
+
+func (nm nixManager) generationsDir() string {
+	return filepath.Join(nm.config.DataDir, "nix-generations")
+}
+
+func (nm nixManager) generationDir(number int) string {
+	return filepath.Join(nm.generationsDir(), strconv.Itoa(number))
+}
+
+func (nm nixManager) generationFilesDir(number int) string {
+	return filepath.Join(nm.generationDir(number), "files")
+}
+
+func (nm nixManager) ListGenerations() ([]dogeboxd.NixGeneration, error) {
+	entries, err := os.ReadDir(nm.generationsDir())
+	if os.IsNotExist(err) {
+		return []dogeboxd.NixGeneration{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generations directory: %w", err)
+	}
+
+	generations := []dogeboxd.NixGeneration{}
+	for _, entry := range entries {
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		generation, err := nm.loadGeneration(number)
+		if err != nil {
+			return nil, err
+		}
+		generations = append(generations, generation)
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Number < generations[j].Number
+	})
+
+	return generations, nil
+}
+
+func (nm nixManager) loadGeneration(number int) (dogeboxd.NixGeneration, error) {
+	data, err := os.ReadFile(filepath.Join(nm.generationDir(number), "generation.json"))
+	if err != nil {
+		return dogeboxd.NixGeneration{}, fmt.Errorf("failed to read generation %d: %w", number, err)
+	}
+
+	var generation dogeboxd.NixGeneration
+	if err := json.Unmarshal(data, &generation); err != nil {
+		return dogeboxd.NixGeneration{}, fmt.Errorf("failed to parse generation %d: %w", number, err)
+	}
+
+	return generation, nil
+}
+
+// recordGeneration copies the current NixDir into a new, numbered
+// generation and prunes the oldest generations past maxNixGenerations.
+func (nm nixManager) recordGeneration(generation dogeboxd.NixGeneration) (dogeboxd.NixGeneration, error) {
+	existing, err := nm.ListGenerations()
+	if err != nil {
+		return generation, err
+	}
+
+	generation.Number = 1
+	if len(existing) > 0 {
+		generation.Number = existing[len(existing)-1].Number + 1
+	}
+
+	if nm.pups != nil {
+		generation.Pups = map[string]string{}
+		for id, state := range nm.pups.GetStateMap() {
+			if state.Installation == dogeboxd.STATE_INSTALLING || state.Installation == dogeboxd.STATE_READY || state.Installation == dogeboxd.STATE_RUNNING {
+				generation.Pups[id] = state.Version
+			}
+		}
+	}
+
+	if err := copyDirectory(nm.config.NixDir, nm.generationFilesDir(generation.Number)); err != nil {
+		os.RemoveAll(nm.generationDir(generation.Number))
+		return generation, err
+	}
+
+	data, err := json.MarshalIndent(generation, "", "  ")
+	if err != nil {
+		return generation, err
+	}
+
+	if err := os.WriteFile(filepath.Join(nm.generationDir(generation.Number), "generation.json"), data, 0644); err != nil {
+		os.RemoveAll(nm.generationDir(generation.Number))
+		return generation, fmt.Errorf("failed to write generation %d: %w", generation.Number, err)
+	}
+
+	existing = append(existing, generation)
+	for len(existing) > maxNixGenerations {
+		if err := os.RemoveAll(nm.generationDir(existing[0].Number)); err != nil {
+			return generation, fmt.Errorf("failed to prune generation %d: %w", existing[0].Number, err)
+		}
+		existing = existing[1:]
+	}
+
+	return generation, nil
+}
+
+/* checkGenerationPups refuses rolling back to a generation made
+ * before a pup was installed, uninstalled or upgraded. Only the
+ * nix files are restored, so the pup's state, storage and files
+ * would no longer match its configuration.
+ */
+func (nm nixManager) checkGenerationPups(number int) error {
+	generation, err := nm.loadGeneration(number)
+	if err != nil {
+		return err
+	}
+
+	then, err := pupFileIDs(nm.generationFilesDir(number))
+	if err != nil {
+		return err
+	}
+	now, err := pupFileIDs(nm.config.NixDir)
+	if err != nil {
+		return err
+	}
+
+	installed := []string{}
+	for id := range now {
+		if !then[id] {
+			installed = append(installed, id)
+		}
+	}
+	uninstalled := []string{}
+	for id := range then {
+		if !now[id] {
+			uninstalled = append(uninstalled, id)
+		}
+	}
+	sort.Strings(installed)
+	sort.Strings(uninstalled)
+
+	if len(installed) > 0 {
+		return fmt.Errorf("can't roll back to generation %d, pups %s were installed since, uninstall them first", number, strings.Join(installed, ", "))
+	}
+	if len(uninstalled) > 0 {
+		return fmt.Errorf("can't roll back to generation %d, pups %s were uninstalled since, reinstall them first", number, strings.Join(uninstalled, ", "))
+	}
+
+	if generation.Pups == nil || nm.pups == nil {
+		return nil
+	}
+
+	for id, version := range generation.Pups {
+		state, _, err := nm.pups.GetPup(id)
+		if err == nil && state.Version != version {
+			return fmt.Errorf("can't roll back to generation %d, pup %s was upgraded from %s to %s since", number, id, version, state.Version)
+		}
+	}
+
+	return nil
+}
+
+// pupFileIDs lists the pups with a pup_<id>.nix file in dir.
+func pupFileIDs(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "pup_") && strings.HasSuffix(name, ".nix") {
+			ids[strings.TrimSuffix(strings.TrimPrefix(name, "pup_"), ".nix")] = true
+		}
+	}
+	return ids, nil
+}
+
+// DiffGenerations returns the changes needed to go from one recorded generation to another.
+func (nm nixManager) DiffGenerations(from, to int) ([]dogeboxd.NixPatchFileDiff, error) {
+	for _, number := range []int{from, to} {
+		if _, err := os.Stat(nm.generationFilesDir(number)); err != nil {
+			return nil, fmt.Errorf("unknown generation %d", number)
+		}
+	}
+
+	return diffDirectories(nm.generationFilesDir(from), nm.generationFilesDir(to))
+}
+
+// currentNixOSGeneration reads the generation number of the active
+// NixOS system profile, ie. /nix/var/nix/profiles/system-42-link
+func currentNixOSGeneration() (int, error) {
+	link, err := os.Readlink(nixOSSystemProfile)
+	if err != nil {
+		return 0, err
+	}
+
+	var number int
+	if _, err := fmt.Sscanf(filepath.Base(link), "system-%d-link", &number); err != nil {
+		return 0, fmt.Errorf("unexpected system profile link %q", link)
+	}
+
+	return number, nil
+}
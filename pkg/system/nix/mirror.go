@@ -0,0 +1,177 @@
+package nix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+func (nm nixManager) mirrorSigningKey() string {
+	return filepath.Join(nm.config.DataDir, "mirror-signing.key")
+}
+
+/* ExportMirrorClosures builds every pup in a mirror bundle and
+ * copies the resulting closures into a nix binary cache inside
+ * the bundle, so a box importing it can install the pups
+ * without fetching anything. The cache is signed with a key
+ * kept in DataDir, whose public half is recorded in the bundle.
+ */
+func (nm nixManager) ExportMirrorClosures(bundleDir string, log dogeboxd.SubLogger) error {
+	bundle, err := dogeboxd.ReadMirrorBundle(bundleDir)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := nm.ensureMirrorSigningKey(log)
+	if err != nil {
+		log.Errf("Failed to set up mirror signing key: %v", err)
+		return err
+	}
+
+	absBundleDir, err := filepath.Abs(bundleDir)
+	if err != nil {
+		return err
+	}
+
+	for i, pup := range bundle.Pups {
+		log.Logf("Building %s %s", pup.Name, pup.Version)
+
+		paths, err := buildPupClosure(filepath.Join(absBundleDir, pup.Location), log)
+		if err != nil {
+			log.Errf("Failed to build %s %s: %v", pup.Name, pup.Version, err)
+			return err
+		}
+		bundle.Pups[i].StorePaths = paths
+
+		log.Progress(((i + 1) * 100) / (len(bundle.Pups) + 1))
+	}
+
+	storePaths := []string{}
+	for _, pup := range bundle.Pups {
+		storePaths = append(storePaths, pup.StorePaths...)
+	}
+
+	cacheURL := fmt.Sprintf("file://%s?secret-key=%s", filepath.Join(absBundleDir, dogeboxd.MIRROR_NIX_CACHE_DIR), nm.mirrorSigningKey())
+
+	log.Logf("Copying %d store paths into the mirror", len(storePaths))
+
+	cmd := exec.Command("nix", append([]string{"--extra-experimental-features", "nix-command", "copy", "--to", cacheURL}, storePaths...)...)
+	log.LogCmd(cmd)
+
+	if err := cmd.Run(); err != nil {
+		log.Errf("Failed to copy closures: %v", err)
+		return err
+	}
+
+	bundle.NixCache = true
+	bundle.NixPublicKey = publicKey
+
+	return dogeboxd.WriteMirrorBundle(bundleDir, bundle)
+}
+
+func (nm nixManager) ensureMirrorSigningKey(log dogeboxd.SubLogger) (string, error) {
+	keyFile := nm.mirrorSigningKey()
+	pubFile := keyFile + ".pub"
+
+	if _, err := os.Stat(pubFile); os.IsNotExist(err) {
+		hostname, _ := os.Hostname()
+		if hostname == "" {
+			hostname = "dogebox"
+		}
+
+		cmd := exec.Command("nix-store", "--generate-binary-cache-key", hostname+"-mirror-1", keyFile, pubFile)
+		log.LogCmd(cmd)
+
+		if err := cmd.Run(); err != nil {
+			return "", err
+		}
+
+		if err := os.Chmod(keyFile, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	publicKey, err := os.ReadFile(pubFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(publicKey)), nil
+}
+
+// buildPupClosure builds a pup's nix file and returns the store paths it produced.
+func buildPupClosure(pupDir string, log dogeboxd.SubLogger) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(pupDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest dogeboxd.PupManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+
+	nixFile, err := encodeNix(nixPath(filepath.Join(pupDir, manifest.Container.Build.NixFile)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Same expression the pup container template imports.
+	expr := fmt.Sprintf("import %s { pkgs = import <nixpkgs> {}; }", nixFile)
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("nix-build", "--no-out-link", "-E", expr)
+	log.LogCmd(cmd)
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(stdout.String()), nil
+}
+
+/* mirrorSubstituterArgs points a rebuild at the binary caches
+ * of imported mirrors, so pups installed from them don't need
+ * to reach cache.nixos.org.
+ */
+func (nm nixManager) mirrorSubstituterArgs() []string {
+	args := []string{}
+
+	if nm.config.DataDir == "" {
+		return args
+	}
+
+	mirrorsDir := dogeboxd.MirrorsDir(nm.config.DataDir)
+
+	entries, err := os.ReadDir(mirrorsDir)
+	if err != nil {
+		return args
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		dir := filepath.Join(mirrorsDir, entry.Name())
+
+		bundle, err := dogeboxd.ReadMirrorBundle(dir)
+		if err != nil || !bundle.NixCache || bundle.NixPublicKey == "" {
+			continue
+		}
+
+		args = append(args,
+			"--substituter", "file://"+filepath.Join(dir, dogeboxd.MIRROR_NIX_CACHE_DIR),
+			"--trusted-public-key", bundle.NixPublicKey,
+		)
+	}
+
+	return args
+}
@@ -10,7 +10,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
 	"time"
 
@@ -57,6 +59,7 @@ type PatchOperation struct {
 type nixPatch struct {
 	id          string
 	nm          nixManager
+	nixDir      string // where operations write to, NixDir unless dry-running
 	snapshotDir string
 	state       string
 	operations  []PatchOperation
@@ -70,10 +73,11 @@ func NewNixPatch(nm nixManager, log dogeboxd.SubLogger) dogeboxd.NixPatch {
 	patchID := hex.EncodeToString(id)
 
 	p := &nixPatch{
-		id:    patchID,
-		nm:    nm,
-		state: NixPatchStatePending,
-		log:   log,
+		id:     patchID,
+		nm:     nm,
+		nixDir: nm.config.NixDir,
+		state:  NixPatchStatePending,
+		log:    log,
 	}
 
 	log.Logf("[patch-%s] Created new nix patch", p.id)
@@ -145,6 +149,8 @@ func (np *nixPatch) ApplyCustom(options dogeboxd.NixPatchApplyOptions) error {
 		np.log.Logf("[patch-%s] Applied all patch operations, but not rebuilding as requested.", np.id)
 	}
 
+	np.recordGeneration(options)
+
 	if err := os.RemoveAll(np.snapshotDir); err != nil {
 		np.log.Errf("[patch-%s] Warning: Failed to remove snapshot directory: %v", np.id, err)
 	} else {
@@ -157,6 +163,114 @@ func (np *nixPatch) ApplyCustom(options dogeboxd.NixPatchApplyOptions) error {
 	return nil
 }
 
+// DryRun renders every pending operation into a scratch copy of NixDir and
+// returns a unified diff for each file that would change. The patch is left
+// pending, so it can still be applied or cancelled afterwards.
+func (np *nixPatch) DryRun(options dogeboxd.NixPatchDryRunOptions) (dogeboxd.NixPatchDryRunResult, error) {
+	result := dogeboxd.NixPatchDryRunResult{
+		Operations: []string{},
+		Files:      []dogeboxd.NixPatchFileDiff{},
+	}
+
+	if np.state != NixPatchStatePending {
+		return result, errors.New("patch already applied or cancelled")
+	}
+
+	np.log.Logf("[patch-%s] Dry-running nix patch with %d operations", np.id, len(np.operations))
+
+	scratchDir := filepath.Join(np.nm.config.TmpDir, fmt.Sprintf("nix-patch-%s-dry-run", np.id))
+	if err := os.MkdirAll(scratchDir, 0750); err != nil {
+		return result, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	if err := copyDirectory(np.nm.config.NixDir, scratchDir); err != nil {
+		return result, fmt.Errorf("failed to copy nix directory: %w", err)
+	}
+
+	np.nixDir = scratchDir
+	defer func() { np.nixDir = np.nm.config.NixDir }()
+
+	for _, operation := range np.operations {
+		result.Operations = append(result.Operations, operation.Name)
+		if err := operation.Operation(); err != nil {
+			return result, fmt.Errorf("operation %s failed: %w", operation.Name, err)
+		}
+	}
+
+	files, err := diffDirectories(np.nm.config.NixDir, scratchDir)
+	if err != nil {
+		return result, fmt.Errorf("failed to diff nix directory: %w", err)
+	}
+
+	if options.Evaluate {
+		// Parse each file first, so a syntax error points at its file.
+		parsed := true
+		for i := range files {
+			if files[i].Removed {
+				continue
+			}
+
+			cmd := exec.Command("nix-instantiate", "--parse", filepath.Join(scratchDir, files[i].Filename))
+			if err := runNixCheck(cmd, &files[i].EvalError); err != nil {
+				parsed = false
+			}
+		}
+
+		if parsed && len(files) > 0 {
+			np.log.Logf("[patch-%s] Evaluating system configuration", np.id)
+			if err := np.evaluateSystem(scratchDir, &result.EvalError); err != nil {
+				np.log.Errf("[patch-%s] System configuration doesn't evaluate: %v", np.id, err)
+			}
+		}
+		result.Evaluated = true
+	}
+
+	result.Files = files
+
+	np.log.Logf("[patch-%s] Dry run complete, %d files would change", np.id, len(files))
+
+	return result, nil
+}
+
+// The system configuration nixos-rebuild uses, see _dbxroot nix rs.
+const nixosConfiguration = "/etc/nixos/configuration.nix"
+
+/* evaluateSystem evaluates the NixOS system configuration as
+ * nixos-rebuild would, but with the dogebox.nix in scratchDir in
+ * place of ours, without building anything.
+ */
+func (np *nixPatch) evaluateSystem(scratchDir string, evalError *string) error {
+	imports, err := encodeNix([]any{nixPath(nixosConfiguration), nixPath(filepath.Join(scratchDir, "dogebox.nix"))})
+	if err != nil {
+		*evalError = err.Error()
+		return err
+	}
+	disabled, err := encodeNix([]any{nixPath(filepath.Join(np.nm.config.NixDir, "dogebox.nix"))})
+	if err != nil {
+		*evalError = err.Error()
+		return err
+	}
+
+	// Forcing the toplevel derivation's path evaluates everything a rebuild would.
+	expr := fmt.Sprintf(`(import <nixpkgs/nixos> { configuration = { imports = %s; disabledModules = %s; }; }).config.system.build.toplevel.drvPath`, imports, disabled)
+
+	cmd := exec.Command("nix-instantiate", "--eval", "--strict", "-E", expr)
+	return runNixCheck(cmd, evalError)
+}
+
+// runNixCheck runs a nix command, putting its output in failure if it fails.
+func runNixCheck(cmd *exec.Cmd, failure *string) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		*failure = strings.TrimSpace(string(out))
+		if *failure == "" {
+			*failure = err.Error()
+		}
+	}
+	return err
+}
+
 func (np *nixPatch) Cancel() error {
 	if np.state != NixPatchStatePending {
 		return errors.New("patch already applied or cancelled")
@@ -183,6 +297,37 @@ func (np *nixPatch) snapshot() error {
 	return copyDirectory(np.nm.config.NixDir, np.snapshotDir)
 }
 
+// recordGeneration keeps a copy of the freshly applied NixDir so that
+// it can be rolled back to later. Failing to do so does not fail the patch.
+func (np *nixPatch) recordGeneration(options dogeboxd.NixPatchApplyOptions) {
+	generation := dogeboxd.NixGeneration{
+		PatchID:    np.id,
+		JobID:      np.log.JobID(),
+		Operations: []string{},
+		Created:    time.Now(),
+	}
+
+	for _, operation := range np.operations {
+		generation.Operations = append(generation.Operations, operation.Name)
+	}
+
+	if !options.DangerousNoRebuild {
+		nixosGeneration, err := currentNixOSGeneration()
+		if err != nil {
+			np.log.Errf("[patch-%s] Warning: Failed to read NixOS generation: %v", np.id, err)
+		}
+		generation.NixOSGeneration = nixosGeneration
+	}
+
+	generation, err := np.nm.recordGeneration(generation)
+	if err != nil {
+		np.log.Errf("[patch-%s] Warning: Failed to record nix generation: %v", np.id, err)
+		return
+	}
+
+	np.log.Logf("[patch-%s] Recorded nix generation %d", np.id, generation.Number)
+}
+
 func (np *nixPatch) triggerRollback(err error) error {
 	log.Printf("[patch-%s] Triggering rollback", np.id)
 	log.Printf("[patch-%s] Rollback triggered because of error: %v", np.id, err)
@@ -240,6 +385,14 @@ func (np *nixPatch) UpdateNetwork(values dogeboxd.NixNetworkTemplateValues) {
 
 func (np *nixPatch) UpdateIncludesFile(values dogeboxd.NixIncludesFileTemplateValues) {
 	np.add("UpdateIncludesFile", func() error {
+		if values.CUSTOM_MODULES_ENABLED {
+			modules, err := listCustomModuleNames(np.nixDir)
+			if err != nil {
+				return err
+			}
+			values.CUSTOM_MODULES = modules
+		}
+
 		return np.writeTemplate("dogebox.nix", rawIncludesFileTemplate, values)
 	})
 }
@@ -257,8 +410,60 @@ func (np *nixPatch) UpdateStorageOverlay(values dogeboxd.NixStorageOverlayTempla
 	})
 }
 
-func (np *nixPatch) writeTemplate(filename string, _template []byte, values interface{}) error {
-	template, err := template.New(filename).Parse(string(_template))
+func (np *nixPatch) RestoreGeneration(number int) {
+	np.add(fmt.Sprintf("RestoreGeneration(%d)", number), func() error {
+		filesDir := np.nm.generationFilesDir(number)
+		if _, err := os.Stat(filesDir); err != nil {
+			return fmt.Errorf("unknown generation %d", number)
+		}
+
+		if err := np.nm.checkGenerationPups(number); err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(np.nixDir); err != nil {
+			return fmt.Errorf("failed to remove nixDir: %w", err)
+		}
+
+		return copyDirectory(filesDir, np.nixDir)
+	})
+}
+
+func (np *nixPatch) WriteCustomModule(name string, content string) {
+	np.add(fmt.Sprintf("WriteCustomModule(%s)", name), func() error {
+		if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+			return err
+		}
+
+		return np.writeDogeboxNixFile(filepath.Join(customModulesDir, name+".nix"), content)
+	})
+}
+
+func (np *nixPatch) RemoveCustomModule(name string) {
+	np.add(fmt.Sprintf("RemoveCustomModule(%s)", name), func() error {
+		if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+			return err
+		}
+
+		if err := os.Remove(customModulePath(np.nixDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove custom module %s: %w", name, err)
+		}
+
+		return nil
+	})
+}
+
+// Every template value struct must be able to validate itself.
+type templateValues interface {
+	Validate() error
+}
+
+func (np *nixPatch) writeTemplate(filename string, _template []byte, values templateValues) error {
+	if err := values.Validate(); err != nil {
+		return fmt.Errorf("invalid values for %s: %w", filename, err)
+	}
+
+	template, err := template.New(filename).Funcs(templateFuncs).Parse(string(_template))
 	if err != nil {
 		return err
 	}
@@ -281,8 +486,8 @@ func (np *nixPatch) RemovePupFile(pupId string) {
 	np.add("RemovePupFile", func() error {
 		// Remove pup nix file
 		filename := fmt.Sprintf("pup_%s.nix", pupId)
-		if _, err := os.Stat(filepath.Join(np.nm.config.NixDir, filename)); err == nil {
-			if err := os.Remove(filepath.Join(np.nm.config.NixDir, filename)); err != nil {
+		if _, err := os.Stat(filepath.Join(np.nixDir, filename)); err == nil {
+			if err := os.Remove(filepath.Join(np.nixDir, filename)); err != nil {
 				return fmt.Errorf("failed to remove file %s: %w", filename, err)
 			}
 		}
@@ -291,7 +496,7 @@ func (np *nixPatch) RemovePupFile(pupId string) {
 }
 
 func (np *nixPatch) writeDogeboxNixFile(filename string, content string) error {
-	fullPath := filepath.Join(np.nm.config.NixDir, filename)
+	fullPath := filepath.Join(np.nixDir, filename)
 
 	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
 	if err != nil {
@@ -3,7 +3,6 @@ package nix
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 
@@ -13,19 +12,21 @@ import (
 var _ dogeboxd.NixManager = &nixManager{}
 
 type nixManager struct {
-	config dogeboxd.ServerConfig
-	pups   dogeboxd.PupManager
+	config  dogeboxd.ServerConfig
+	pups    dogeboxd.PupManager
+	dbxRoot dogeboxd.DBXRoot
 }
 
-func NewNixManager(config dogeboxd.ServerConfig, pups dogeboxd.PupManager) dogeboxd.NixManager {
+func NewNixManager(config dogeboxd.ServerConfig, pups dogeboxd.PupManager, dbxRoot dogeboxd.DBXRoot) dogeboxd.NixManager {
 	return nixManager{
-		config: config,
-		pups:   pups,
+		config:  config,
+		pups:    pups,
+		dbxRoot: dbxRoot,
 	}
 }
 
 func (nm nixManager) InitSystem(patch dogeboxd.NixPatch, dbxState dogeboxd.DogeboxState) {
-	nm.UpdateIncludesFile(patch, nm.pups)
+	nm.UpdateIncludesFile(patch, nm.pups, dbxState)
 
 	patch.UpdateSystem(dogeboxd.NixSystemTemplateValues{
 		SSH_ENABLED:     dbxState.SSH.Enabled,
@@ -38,7 +39,7 @@ func (nm nixManager) InitSystem(patch dogeboxd.NixPatch, dbxState dogeboxd.Dogeb
 	nm.UpdateSystemContainerConfiguration(patch)
 }
 
-func (nm nixManager) UpdateIncludesFile(patch dogeboxd.NixPatch, pups dogeboxd.PupManager) {
+func (nm nixManager) UpdateIncludesFile(patch dogeboxd.NixPatch, pups dogeboxd.PupManager, dbxState dogeboxd.DogeboxState) {
 	installed := pups.GetStateMap()
 	var pupIDs []string
 	for id, state := range installed {
@@ -48,8 +49,9 @@ func (nm nixManager) UpdateIncludesFile(patch dogeboxd.NixPatch, pups dogeboxd.P
 	}
 
 	values := dogeboxd.NixIncludesFileTemplateValues{
-		PUP_IDS: pupIDs,
-		NIX_DIR: nm.config.NixDir,
+		PUP_IDS:                pupIDs,
+		NIX_DIR:                nm.config.NixDir,
+		CUSTOM_MODULES_ENABLED: !dbxState.CustomNix.Disabled,
 	}
 
 	patch.UpdateIncludesFile(values)
@@ -63,7 +65,8 @@ func (nm nixManager) WritePupFile(
 	services := []dogeboxd.NixPupContainerServiceValues{}
 
 	for _, service := range state.Manifest.Container.Services {
-		cwd := filepath.Join(fmt.Sprintf("${pkgs.pup.%s}", service.Name), service.Command.CWD)
+		// Relative to the pup package, which the template prefixes.
+		cwd := filepath.Join("/", service.Command.CWD)
 
 		services = append(services, dogeboxd.NixPupContainerServiceValues{
 			NAME: service.Name,
@@ -200,6 +203,12 @@ func (nm nixManager) UpdateSystemContainerConfiguration(nixPatch dogeboxd.NixPat
 				continue
 			}
 
+			// Without a granted permission group the pup can't use the
+			// interface, unless the provider doesn't split it into any.
+			if len(providerPup.Manifest.PermissionGroups(dependency.InterfaceName)) > 0 && len(state.GrantedPermissionGroups(dependency.InterfaceName, providerPup)) == 0 {
+				continue
+			}
+
 			if _, ok := otherPupsById[providerPup.ID]; !ok {
 				otherPupsById[providerPup.ID] = dogeboxd.NixSystemContainerConfigTemplatePupTcpConnectionOtherPup{
 					NAME: providerPup.Manifest.Meta.Name,
@@ -298,10 +307,17 @@ func (nm nixManager) UpdateStorageOverlay(nixPatch dogeboxd.NixPatch, partitionN
 	nixPatch.UpdateStorageOverlay(values)
 }
 
+func (nm nixManager) RestoreGeneration(nixPatch dogeboxd.NixPatch, number int) {
+	nixPatch.RestoreGeneration(number)
+}
+
 func (nm nixManager) RebuildBoot(log dogeboxd.SubLogger) error {
-	md := exec.Command("sudo", "_dbxroot", "nix", "rb")
-	log.LogCmd(md)
-	err := md.Run()
+	progress := newNixProgressLogger(log)
+	defer progress.finish()
+
+	args := append([]string{"nix", "rb", "--json-log"}, nm.mirrorSubstituterArgs()...)
+
+	err := nm.dbxRoot.Run(progress, args...)
 	if err != nil {
 		log.Errf("Error executing nix rebuild boot: %v\n", err)
 		return err
@@ -310,10 +326,12 @@ func (nm nixManager) RebuildBoot(log dogeboxd.SubLogger) error {
 }
 
 func (nm nixManager) Rebuild(log dogeboxd.SubLogger) error {
-	cmd := exec.Command("sudo", "_dbxroot", "nix", "rs")
-	log.LogCmd(cmd)
+	progress := newNixProgressLogger(log)
+	defer progress.finish()
+
+	args := append([]string{"nix", "rs", "--json-log"}, nm.mirrorSubstituterArgs()...)
 
-	if err := cmd.Run(); err != nil {
+	if err := nm.dbxRoot.Run(progress, args...); err != nil {
 		log.Errf("Error executing nix rebuild: %v\n", err)
 		return err
 	}
@@ -0,0 +1,230 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"github.com/dogeorg/dogeboxd/pkg/utils"
+)
+
+// Activity, result and verbosity values from nix's logging.hh
+const (
+	nixActCopyPath     = 100
+	nixActFileTransfer = 101
+	nixActBuild        = 105
+
+	nixResProgress    = 105
+	nixResSetExpected = 106
+
+	nixLvlInfo = 3
+)
+
+// How often we report byte-level progress when no build finishes in between.
+const nixProgressInterval = 2 * time.Second
+
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+type nixLogEntry struct {
+	Action string `json:"action"`
+	ID     uint64 `json:"id"`
+	Level  int    `json:"level"`
+	Text   string `json:"text"`
+	Msg    string `json:"msg"`
+	Type   int    `json:"type"`
+	Fields []any  `json:"fields"`
+}
+
+/* nixProgressLogger wraps a SubLogger while nixos-rebuild runs
+ * with --log-format internal-json. Lines prefixed with "@nix" are
+ * parsed into NixBuildProgress and a step progress percentage,
+ * everything else is passed through untouched.
+ */
+type nixProgressLogger struct {
+	dogeboxd.SubLogger
+
+	mu          sync.Mutex
+	start       time.Time
+	lastReport  time.Time
+	lastPercent int
+	activities  map[uint64]int           // activity id -> activity type
+	expected    map[uint64]map[int]int64 // activity id -> activity type -> expected
+	transfers   map[uint64]int64         // bytes done per file transfer
+	progress    dogeboxd.NixBuildProgress
+}
+
+func newNixProgressLogger(log dogeboxd.SubLogger) *nixProgressLogger {
+	return &nixProgressLogger{
+		SubLogger:   log,
+		start:       time.Now(),
+		lastPercent: -1,
+		activities:  map[uint64]int{},
+		expected:    map[uint64]map[int]int64{},
+		transfers:   map[uint64]int64{},
+	}
+}
+
+func (t *nixProgressLogger) LogCmd(cmd *exec.Cmd) {
+	cmd.Stdout = dogeboxd.NewLineWriter(t.line)
+	cmd.Stderr = dogeboxd.NewLineWriter(t.line)
+}
+
+// finish clears the structured progress once the rebuild has exited.
+func (t *nixProgressLogger) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.SubLogger.BuildProgress(nil)
+}
+
+func (t *nixProgressLogger) line(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	raw, ok := strings.CutPrefix(s, "@nix ")
+	if !ok {
+		t.SubLogger.Log(s)
+		return
+	}
+
+	var entry nixLogEntry
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		t.SubLogger.Log(s)
+		return
+	}
+
+	changed := false
+
+	switch entry.Action {
+	case "msg":
+		if entry.Level <= nixLvlInfo {
+			t.SubLogger.Log(stripANSI(entry.Msg))
+		}
+
+	case "start":
+		t.activities[entry.ID] = entry.Type
+
+		if entry.Type == nixActBuild && len(entry.Fields) > 0 {
+			if drv, ok := entry.Fields[0].(string); ok {
+				t.progress.CurrentDerivation = derivationName(drv)
+				changed = true
+			}
+		}
+
+		if entry.Text != "" && entry.Level <= nixLvlInfo {
+			t.SubLogger.Log(stripANSI(entry.Text))
+		}
+
+	case "stop":
+		switch t.activities[entry.ID] {
+		case nixActBuild:
+			t.progress.BuildsDone++
+			changed = true
+		case nixActCopyPath:
+			t.progress.FetchesDone++
+			changed = true
+		}
+		delete(t.activities, entry.ID)
+
+	case "result":
+		switch entry.Type {
+		case nixResSetExpected:
+			if len(entry.Fields) >= 2 {
+				if t.expected[entry.ID] == nil {
+					t.expected[entry.ID] = map[int]int64{}
+				}
+				t.expected[entry.ID][int(fieldInt(entry.Fields[0]))] = fieldInt(entry.Fields[1])
+				changed = true
+			}
+
+		case nixResProgress:
+			if t.activities[entry.ID] == nixActFileTransfer && len(entry.Fields) > 0 {
+				t.transfers[entry.ID] = fieldInt(entry.Fields[0])
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		t.report()
+	}
+}
+
+// report recalculates totals and logs a progress line when the
+// percentage moves, or periodically while bytes are downloading.
+func (t *nixProgressLogger) report() {
+	t.progress.BuildsExpected = int(t.expectedTotal(nixActBuild))
+	t.progress.FetchesExpected = int(t.expectedTotal(nixActCopyPath))
+	t.progress.BytesExpected = t.expectedTotal(nixActFileTransfer)
+
+	t.progress.BytesDownloaded = 0
+	for _, done := range t.transfers {
+		t.progress.BytesDownloaded += done
+	}
+
+	total := t.progress.BuildsExpected + t.progress.FetchesExpected
+	done := t.progress.BuildsDone + t.progress.FetchesDone
+	if total == 0 {
+		return
+	}
+
+	percent := min(done*100/total, 99)
+
+	if done > 0 {
+		elapsed := time.Since(t.start)
+		t.progress.ETASeconds = int((elapsed * time.Duration(total-done) / time.Duration(done)).Seconds())
+	}
+
+	if percent == t.lastPercent && time.Since(t.lastReport) < nixProgressInterval {
+		return
+	}
+	t.lastPercent = percent
+	t.lastReport = time.Now()
+
+	progress := t.progress
+	t.SubLogger.Progress(percent).BuildProgress(&progress).Logf(
+		"Built %d/%d derivations, fetched %d/%d paths (%s of %s)",
+		progress.BuildsDone, progress.BuildsExpected,
+		progress.FetchesDone, progress.FetchesExpected,
+		utils.PrettyPrintDiskSize(progress.BytesDownloaded), utils.PrettyPrintDiskSize(progress.BytesExpected),
+	)
+}
+
+// Expected counts are reported per parent activity, so we sum them.
+func (t *nixProgressLogger) expectedTotal(activityType int) int64 {
+	var total int64
+	for _, expected := range t.expected {
+		total += expected[activityType]
+	}
+	return total
+}
+
+// derivationName turns /nix/store/<hash>-name.drv into name
+func derivationName(drvPath string) string {
+	name := strings.TrimSuffix(filepath.Base(drvPath), ".drv")
+	if _, rest, ok := strings.Cut(name, "-"); ok {
+		return rest
+	}
+	return name
+}
+
+func fieldInt(field any) int64 {
+	switch v := field.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		var n int64
+		fmt.Sscanf(v, "%d", &n)
+		return n
+	}
+	return 0
+}
+
+func stripANSI(s string) string {
+	return ansiEscapePattern.ReplaceAllString(s, "")
+}
@@ -7,10 +7,15 @@
     ./network.nix
     # ./recovery_ap.nix
     ./system_container_config.nix
-  ] ++ lib.optionals (builtins.pathExists "{{ .NIX_DIR }}/storage-overlay.nix") [
-    {{ .NIX_DIR }}/storage-overlay.nix
+  ] ++ lib.optionals (builtins.pathExists {{ nixPath (printf "%s/storage-overlay.nix" .NIX_DIR) }}) [
+    {{ nixPath (printf "%s/storage-overlay.nix" .NIX_DIR) }}
   ]
   {{range .PUP_IDS}}++ lib.optionals (builtins.pathExists ./pup_{{.}}.nix) [ ./pup_{{.}}.nix ]
   {{end}}
+  {{- if .CUSTOM_MODULES_ENABLED}}
+  # User supplied modules from ./custom
+  {{range .CUSTOM_MODULES}}++ [ ./custom/{{.}}.nix ]
+  {{end}}
+  {{- end}}
   ;
 }
@@ -14,8 +14,8 @@
     22
     {{end}}
     {{ range .PUP_PORTS }}{{ if .PUBLIC }}
-    # Open port {{.PORT}} (forwarding to {{.PORT}}) for pup {{.PUP_ID}}
-    {{.PORT}}
+    # Open port {{.PORT}} (forwarding to {{.PORT}}) for pup {{ nixComment .PUP_ID }}
+    {{ nix .PORT }}
     {{end}}{{end}}
   ];
 }
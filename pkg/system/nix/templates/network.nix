@@ -4,17 +4,17 @@
   networking = {
     {{if .USE_ETHERNET}}
     interfaces = {
-      {{.INTERFACE}} = {
+      {{ nixAttr .INTERFACE }} = {
         useDHCP = true;
       };
     };
     {{else if .USE_WIRELESS}}
     wireless = {
       enable = true;
-      interfaces = [ "{{.INTERFACE}}" ];
+      interfaces = {{ nix (list .INTERFACE) }};
       networks = {
-        "{{.WIFI_SSID}}" = {
-          psk = "{{.WIFI_PASSWORD}}";
+        {{ nixAttr .WIFI_SSID }} = {
+          psk = {{ nixStr .WIFI_PASSWORD }};
         };
       };
     };
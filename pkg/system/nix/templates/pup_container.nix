@@ -2,10 +2,10 @@
 
 let
   pupOverlay = self: super: {
-    pup = import {{.NIX_FILE}} { inherit pkgs; };
+    pup = import {{ nixPath .NIX_FILE }} { inherit pkgs; };
   };
 
-  pupConfig = import {{.NIX_FILE}} { inherit pkgs; };
+  pupConfig = import {{ nixPath .NIX_FILE }} { inherit pkgs; };
 
   pupServices = if lib.hasAttr "services" pupConfig
     then pupConfig.services
@@ -15,12 +15,15 @@ in
   # Maybe don't need this here at the top-level, only inside the container block?
   nixpkgs.overlays = [ pupOverlay ];
 
-  systemd.services."container-log-forwarder@pup-{{.PUP_ID}}" = {
-    description = "Container Log Forwarder for pup-{{.PUP_ID}}";
-    after = [ "container@pup-{{.PUP_ID}}.service" ];
-    requires = [ "container@pup-{{.PUP_ID}}.service" ];
-    serviceConfig = {
-      ExecStart = "${pkgs.bash}/bin/bash -c '${pkgs.systemd}/bin/journalctl -M pup-{{.PUP_ID}} -f --no-hostname -o short-iso >> {{.CONTAINER_LOG_DIR}}/pup-{{.PUP_ID}}'";
+  systemd.services.{{ nixAttr (printf "container-log-forwarder@pup-%s" .PUP_ID) }} = {
+    description = {{ nixStr (printf "Container Log Forwarder for pup-%s" .PUP_ID) }};
+    after = {{ nix (list (printf "container@pup-%s.service" .PUP_ID)) }};
+    requires = {{ nix (list (printf "container@pup-%s.service" .PUP_ID)) }};
+    serviceConfig = let
+      machine = {{ nixStr (printf "pup-%s" .PUP_ID) }};
+      logFile = {{ nixStr (printf "%s/pup-%s" .CONTAINER_LOG_DIR .PUP_ID) }};
+    in {
+      ExecStart = "${pkgs.bash}/bin/bash -c ${lib.escapeShellArg "${pkgs.systemd}/bin/journalctl -M ${lib.escapeShellArg machine} -f --no-hostname -o short-iso >> ${lib.escapeShellArg logFile}"}";
       Restart = "always";
       User = "root";
       StandardOutput = "null";
@@ -29,22 +32,22 @@ in
     wantedBy = [ "multi-user.target" ];
   };
 
-  containers.pup-{{.PUP_ID}} = {
+  containers.{{ nixAttr (printf "pup-%s" .PUP_ID) }} = {
 
     # If our pup is enabled, we set it to autostart on boot.
-    autoStart = {{.PUP_ENABLED}};
+    autoStart = {{ nix .PUP_ENABLED }};
 
     # Set up private networking. This will ensure the pup gets an internal IP
     # in the range of 10.69.0.0/8, be able to to dogeboxd at 10.69.0.1, but not
     # be able to talk to any other pups without proxying through dogeboxd.
     privateNetwork = true;
     hostAddress = "10.69.0.1";
-    localAddress = "{{.INTERNAL_IP}}";
+    localAddress = {{ nixStr .INTERNAL_IP }};
 
     forwardPorts = [
       {{ range .PUP_PORTS }}{{ if .PUBLIC }}{
-        containerPort = {{ .PORT }};
-        hostPort = {{ .PORT }};
+        containerPort = {{ nix .PORT }};
+        hostPort = {{ nix .PORT }};
         protocol = "tcp";
       }{{end}}{{end}}
     ];
@@ -54,13 +57,13 @@ in
     bindMounts = {
       "Persistent Storage" = {
         mountPoint = "/storage";
-        hostPath = "{{ .STORAGE_PATH }}";
+        hostPath = {{ nixStr .STORAGE_PATH }};
         isReadOnly = false;
       };
 
       "PUP" = {
         mountPoint = "/pup";
-        hostPath = "{{ .PUP_PATH }}";
+        hostPath = {{ nixStr .PUP_PATH }};
         isReadOnly = true;
       };
     };
@@ -85,7 +88,7 @@ in
           enable = true;
           # If the pup has marked that is listens on ports
           # explicitly whitelist those in the container fw.
-          allowedTCPPorts = [ {{ range .PUP_PORTS }}{{ .PORT }} {{end}}];
+          allowedTCPPorts = [ {{ range .PUP_PORTS }}{{ nix .PORT }} {{end}}];
         };
         hosts = {
           # Helper so you can always hit dogebox(d) in DNS.
@@ -107,7 +110,7 @@ in
       };
 
       environment.systemPackages = with pkgs; [
-        {{ range .SERVICES }}pup.{{.NAME}} {{end}}
+        {{ range .SERVICES }}pup.{{ nixAttr .NAME }} {{end}}
       ];
 
       # Merge in any managed nix service that the pup wants to start.
@@ -120,27 +123,27 @@ in
 
       # Create a systemd service for any unmanaged binary the pup wants to start.
       {{range .SERVICES}}
-      systemd.services.{{.NAME}} = {
+      systemd.services.{{ nixAttr .NAME }} = {
         after = [ "network.target" ];
         wantedBy = [ "multi-user.target" ];
 
         serviceConfig = {
-          ExecStart = "${pkgs.pup.{{.NAME}}}{{.EXEC}}";
+          ExecStart = "${pkgs.pup.{{ nixAttr .NAME }}}" + {{ nixStr .EXEC }};
           Restart = "always";
           User = "pup";
           Group = "pup";
 
-          WorkingDirectory = "{{.CWD}}";
+          WorkingDirectory = "${pkgs.pup.{{ nixAttr .NAME }}}" + {{ nixStr .CWD }};
 
           Environment = [
             {{range .ENV}}
-            "{{.KEY}}={{.VAL}}"
+            {{ nixStr (printf "%s=%s" .KEY .VAL) }}
             {{end}}
             {{range $.PUP_ENV}}
-            "{{.KEY}}={{.VAL}}"
+            {{ nixStr (printf "%s=%s" .KEY .VAL) }}
             {{end}}
             {{range $.GLOBAL_ENV}}
-            "{{.KEY}}={{.VAL}}"
+            {{ nixStr (printf "%s=%s" .KEY .VAL) }}
             {{end}}
           ];
 
@@ -155,5 +158,5 @@ in
   };
 
   # Add a start condition to this container so it will only start in non-recovery mode.
-  systemd.services."container@pup-{{.PUP_ID}}".serviceConfig.ExecCondition = "/run/wrappers/bin/dbx can-pup-start --data-dir {{.DATA_DIR}} --systemd --pup-id {{.PUP_ID}}";
+  systemd.services.{{ nixAttr (printf "container@pup-%s" .PUP_ID) }}.serviceConfig.ExecCondition = {{ nixStr (printf "/run/wrappers/bin/dbx can-pup-start --data-dir %s --systemd --pup-id %s" .DATA_DIR .PUP_ID) }};
 }
@@ -4,15 +4,15 @@
   # Ideally we'd use nix .fileSystems.<name> here, but it doesn't seem to work?
 
   systemd.services.mount-data-overlay = {
-    description = "Mounts the selected storage device as an overlay at {{.DATA_DIR}}";
+    description = {{ nixStr (printf "Mounts the selected storage device as an overlay at %s" .DATA_DIR) }};
     wantedBy = [ "local-fs.target" ];
     script = ''
-      if ! ${pkgs.mount}/bin/mountpoint -q {{ .DATA_DIR }}; then
-        ${pkgs.mount}/bin/mount {{ .STORAGE_DEVICE }} {{ .DATA_DIR }}
-        ${pkgs.coreutils}/bin/chown {{.DBX_UID}}:{{.DBX_UID}} {{.DATA_DIR}}
-        ${pkgs.coreutils}/bin/chmod u+rwX,g+rwX,o-rwx {{ .DATA_DIR }}
+      if ! ${pkgs.mount}/bin/mountpoint -q {{ nixIndented .DATA_DIR }}; then
+        ${pkgs.mount}/bin/mount {{ nixIndented .STORAGE_DEVICE }} {{ nixIndented .DATA_DIR }}
+        ${pkgs.coreutils}/bin/chown {{ nixIndented .DBX_UID }}:{{ nixIndented .DBX_UID }} {{ nixIndented .DATA_DIR }}
+        ${pkgs.coreutils}/bin/chmod u+rwX,g+rwX,o-rwx {{ nixIndented .DATA_DIR }}
       else
-        echo "{{ .DATA_DIR }} is already mounted, skipping mount operation"
+        echo "{{ nixIndented .DATA_DIR }} is already mounted, skipping mount operation"
       fi
     '';
   };
@@ -1,10 +1,10 @@
 { config, pkgs, lib, ... }:
 
 {
-  networking.hostName = lib.mkForce "{{ .SYSTEM_HOSTNAME }}";
+  networking.hostName = lib.mkForce {{ nixStr .SYSTEM_HOSTNAME }};
   networking.networkmanager.enable = false;
 
-  console.keyMap = "{{ .KEYMAP }}";
+  console.keyMap = {{ nixStr .KEYMAP }};
 
   services.openssh.settings = {
     AllowUsers = [ "shibe" ];
@@ -22,7 +22,7 @@
 +===================================================+
 '';
 
-  services.openssh.enable = lib.mkForce {{ .SSH_ENABLED }};
+  services.openssh.enable = lib.mkForce {{ nix .SSH_ENABLED }};
 
   users.users.shibe = {
     isNormalUser = true;
@@ -30,7 +30,7 @@
     openssh = {
       authorizedKeys = {
         keys = [
-          {{ range .SSH_KEYS }}"{{.Key}} # {{.ID}}"{{ end }}
+          {{ range .SSH_KEYS }}{{ nixStr (printf "%s # %s" .Key .ID) }} {{ end }}
         ];
       };
     };
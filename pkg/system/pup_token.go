@@ -0,0 +1,40 @@
+package system
+
+import (
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+// issuePupToken writes a new router token to the pup's storage, see dogeboxd.PUP_TOKEN_FILE.
+func (t SystemUpdater) issuePupToken(s dogeboxd.PupState, log dogeboxd.SubLogger) error {
+	token, hash, err := dogeboxd.NewPupToken()
+	if err != nil {
+		log.Errf("Failed to generate pup token: %v", err)
+		return err
+	}
+
+	err = t.dbxRoot.Run(log, "pup", "write-key", "--data-dir", t.config.DataDir, "--pupId", s.ID, "--key-file", dogeboxd.PUP_TOKEN_FILE, "--data", token)
+	if err != nil {
+		log.Errf("Failed to write pup token to storage: %v", err)
+		return err
+	}
+
+	if _, err := t.pupManager.UpdatePup(s.ID, dogeboxd.SetPupTokenHash(hash)); err != nil {
+		log.Errf("Failed to record pup token: %v", err)
+		return err
+	}
+
+	log.Log("issued pup token")
+	return nil
+}
+
+/* issueMissingPupTokens gives pups installed before tokens existed
+ * one, as the internal router no longer lets them in without it.
+ */
+func (t SystemUpdater) issueMissingPupTokens() {
+	for _, s := range t.pupManager.GetStateMap() {
+		if s.TokenHash != "" || s.Installation != dogeboxd.STATE_READY {
+			continue
+		}
+		t.issuePupToken(s, dogeboxd.NewConsoleSubLogger(s.ID, "issue token"))
+	}
+}
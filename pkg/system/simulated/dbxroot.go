@@ -0,0 +1,104 @@
+package simulated
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+var _ dogeboxd.DBXRoot = DBXRoot{}
+
+func NewDBXRoot(config dogeboxd.ServerConfig, runtime *Runtime) DBXRoot {
+	return DBXRoot{
+		config:  config,
+		runtime: runtime,
+	}
+}
+
+/* DBXRoot
+ *
+ * Handles the _dbxroot commands dogeboxd needs for the
+ * pup lifecycle without sudo: storage and keys are written
+ * as the current user, and nix rebuilds activate the
+ * simulated Runtime.
+ */
+
+type DBXRoot struct {
+	config  dogeboxd.ServerConfig
+	runtime *Runtime
+}
+
+func (t DBXRoot) Run(log dogeboxd.SubLogger, args ...string) error {
+	log.Logf("[simulated] _dbxroot %s", strings.Join(redactArgs(args), " "))
+
+	if len(args) == 0 {
+		return fmt.Errorf("no _dbxroot command given")
+	}
+
+	command := args[0]
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		command = args[0] + " " + args[1]
+	}
+
+	pupId := flagValue(args, "--pupId")
+	storagePath := filepath.Join(t.config.DataDir, "pups", "storage", pupId)
+
+	if strings.HasPrefix(command, "pup ") && (pupId == "" || pupId != filepath.Base(pupId)) {
+		return fmt.Errorf("invalid pupId %q", pupId)
+	}
+
+	switch command {
+	case "nix rs", "nix rb":
+		return t.runtime.Activate(log)
+
+	case "nix gc":
+		log.Log("Reclaimable: 0 store paths, 0 bytes")
+		return nil
+
+	case "pup create-storage":
+		return os.MkdirAll(storagePath, 0755)
+
+	case "pup delete-storage":
+		return os.RemoveAll(storagePath)
+
+	case "pup write-key":
+		keyFile := flagValue(args, "--key-file")
+		if keyFile != filepath.Base(keyFile) {
+			return fmt.Errorf("invalid key-file %q", keyFile)
+		}
+		return os.WriteFile(filepath.Join(storagePath, keyFile), []byte(flagValue(args, "--data")), 0600)
+
+	case "pup stop":
+		t.runtime.Stop(pupId)
+		return nil
+
+	case "reboot", "shutdown":
+		return nil
+	}
+
+	return fmt.Errorf("_dbxroot %s is not supported in simulate mode", command)
+}
+
+func flagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Don't log key material.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i, arg := range out {
+		if arg == "--data" && i+1 < len(out) {
+			out[i+1] = "<redacted>"
+		}
+	}
+	return out
+}
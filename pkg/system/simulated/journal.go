@@ -0,0 +1,43 @@
+package simulated
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+var _ dogeboxd.JournalReader = JournalReader{}
+
+func NewJournalReader() JournalReader {
+	return JournalReader{}
+}
+
+// JournalReader emits synthetic lines in place
+// of reading the host systemd journal.
+type JournalReader struct{}
+
+func (t JournalReader) GetJournalChan(service string) (context.CancelFunc, chan string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out := make(chan string, 10)
+
+	go func() {
+		ticker := time.NewTicker(SIMULATED_LOG_INTERVAL)
+		defer ticker.Stop()
+
+		out <- fmt.Sprintf("[simulated] %s has no systemd journal in simulate mode", service)
+
+		for {
+			select {
+			case <-ctx.Done():
+				close(out)
+				return
+			case <-ticker.C:
+				out <- fmt.Sprintf("[simulated] %s heartbeat at %s", service, time.Now().Format(time.RFC3339))
+			}
+		}
+	}()
+	return cancel, out, nil
+}
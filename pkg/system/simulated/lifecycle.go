@@ -0,0 +1,24 @@
+package simulated
+
+import (
+	"log"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+var _ dogeboxd.LifecycleManager = LifecycleManager{}
+
+func NewLifecycleManager() LifecycleManager {
+	return LifecycleManager{}
+}
+
+// LifecycleManager never touches the host, it only logs what was asked of it.
+type LifecycleManager struct{}
+
+func (t LifecycleManager) Reboot() {
+	log.Printf("[simulated] Not rebooting the host in simulate mode.")
+}
+
+func (t LifecycleManager) Shutdown() {
+	log.Printf("[simulated] Not shutting down the host in simulate mode.")
+}
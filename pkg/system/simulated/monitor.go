@@ -0,0 +1,107 @@
+package simulated
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+const (
+	MONITOR_INTERVAL time.Duration = 10 * time.Second
+)
+
+var _ dogeboxd.SystemMonitor = SystemMonitor{}
+
+func NewSystemMonitor(runtime *Runtime) SystemMonitor {
+	return SystemMonitor{
+		runtime:   runtime,
+		mon:       make(chan []string, 10),
+		stats:     make(chan map[string]dogeboxd.ProcStatus),
+		fastMon:   make(chan string, 10),
+		fastStats: make(chan map[string]dogeboxd.ProcStatus),
+	}
+}
+
+/* SystemMonitor
+ *
+ * Behaves like system.SystemMonitor, but reports on
+ * the pups in a simulated Runtime instead of asking
+ * systemd over dbus.
+ */
+
+type SystemMonitor struct {
+	runtime   *Runtime
+	mon       chan []string
+	stats     chan map[string]dogeboxd.ProcStatus
+	fastMon   chan string
+	fastStats chan map[string]dogeboxd.ProcStatus
+}
+
+func (t SystemMonitor) Run(started, stopped chan bool, stop chan context.Context) error {
+	go func() {
+		go func() {
+			services := []string{}
+			timer := time.NewTimer(MONITOR_INTERVAL)
+			defer timer.Stop()
+		mainloop:
+			for {
+				select {
+				case <-stop:
+					break mainloop
+				case s := <-t.mon:
+					services = s
+					t.send(t.stats, services)
+				case s := <-t.fastMon:
+					go func() {
+						for i := 0; i < 3; i++ {
+							time.Sleep(time.Second)
+							t.send(t.fastStats, []string{s})
+						}
+					}()
+				case <-timer.C:
+					t.send(t.stats, services)
+					timer.Reset(MONITOR_INTERVAL)
+				}
+			}
+		}()
+
+		started <- true
+		<-stop
+		stopped <- true
+	}()
+	return nil
+}
+
+func (t SystemMonitor) send(out chan map[string]dogeboxd.ProcStatus, services []string) {
+	stats := map[string]dogeboxd.ProcStatus{}
+	for _, service := range services {
+		// ie. container@pup-<id>.service
+		id := strings.TrimSuffix(strings.TrimPrefix(service, "container@pup-"), ".service")
+		stats[service] = t.runtime.Status(id)
+	}
+
+	select {
+	case out <- stats:
+	default:
+		fmt.Println("couldn't write to output channel")
+	}
+}
+
+func (t SystemMonitor) GetMonChannel() chan []string {
+	return t.mon
+}
+
+func (t SystemMonitor) GetStatChannel() chan map[string]dogeboxd.ProcStatus {
+	return t.stats
+}
+
+func (t SystemMonitor) GetFastMonChannel() chan string {
+	return t.fastMon
+}
+
+func (t SystemMonitor) GetFastStatChannel() chan map[string]dogeboxd.ProcStatus {
+	return t.fastStats
+}
@@ -0,0 +1,191 @@
+package simulated
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+const (
+	SIMULATED_LOG_INTERVAL time.Duration = 5 * time.Second
+)
+
+var (
+	includedPupPattern = regexp.MustCompile(`pup_([a-zA-Z0-9]+)\.nix`)
+	autoStartPattern   = regexp.MustCompile(`autoStart = (true|false);`)
+)
+
+/* Runtime
+ *
+ * Runtime stands in for NixOS and systemd when running
+ * with --simulate. Instead of starting containers, a
+ * "rebuild" reads the dogebox nix files that were just
+ * written and marks every included, autostarting pup as
+ * running. Running pups produce synthetic stats for the
+ * SystemMonitor and synthetic log lines in the container
+ * log dir, where the normal LogTailer picks them up.
+ */
+
+type Runtime struct {
+	config dogeboxd.ServerConfig
+	mu     *sync.Mutex
+	procs  map[string]*process
+}
+
+type process struct {
+	pid     int
+	started time.Time
+	phase   float64 // offsets the synthetic stats so pups don't move in lockstep
+}
+
+func NewRuntime(config dogeboxd.ServerConfig) *Runtime {
+	return &Runtime{
+		config: config,
+		mu:     &sync.Mutex{},
+		procs:  map[string]*process{},
+	}
+}
+
+func (t *Runtime) Run(started, stopped chan bool, stop chan context.Context) error {
+	go func() {
+		go func() {
+			ticker := time.NewTicker(SIMULATED_LOG_INTERVAL)
+			defer ticker.Stop()
+		mainloop:
+			for {
+				select {
+				case <-stop:
+					break mainloop
+				case <-ticker.C:
+					t.mu.Lock()
+					for id, p := range t.procs {
+						status := p.status()
+						t.writeLog(id, p, fmt.Sprintf("heartbeat: up %s, cpu %.1f%%, mem %.1fMB", time.Since(p.started).Round(time.Second), status.CPUPercent, status.MEMMb))
+					}
+					t.mu.Unlock()
+				}
+			}
+		}()
+
+		started <- true
+		<-stop
+		stopped <- true
+	}()
+	return nil
+}
+
+// Activate brings running pups in line with the nix config in NixDir,
+// the same way nixos-rebuild switch would start and stop containers.
+func (t *Runtime) Activate(l dogeboxd.SubLogger) error {
+	includes, err := os.ReadFile(filepath.Join(t.config.NixDir, "dogebox.nix"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, match := range includedPupPattern.FindAllStringSubmatch(string(includes), -1) {
+		pupFile, err := os.ReadFile(filepath.Join(t.config.NixDir, match[0]))
+		if err != nil {
+			// Included files are optional, just like in dogebox.nix.
+			continue
+		}
+
+		autoStart := autoStartPattern.FindStringSubmatch(string(pupFile))
+		if autoStart != nil && autoStart[1] == "true" {
+			wanted[match[1]] = true
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id := range wanted {
+		if _, ok := t.procs[id]; ok {
+			continue
+		}
+
+		p := &process{
+			pid:     1000 + rand.Intn(30000),
+			started: time.Now(),
+			phase:   rand.Float64() * 2 * math.Pi,
+		}
+		t.procs[id] = p
+		l.Logf("[simulated] Starting container pup-%s", id)
+		t.writeLog(id, p, "Started simulated container")
+	}
+
+	for id, p := range t.procs {
+		if wanted[id] {
+			continue
+		}
+
+		l.Logf("[simulated] Stopping container pup-%s", id)
+		t.writeLog(id, p, "Stopped simulated container")
+		delete(t.procs, id)
+	}
+
+	return nil
+}
+
+// Stop stops a single pup, until it is next activated.
+func (t *Runtime) Stop(pupID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.procs[pupID]
+	if !ok {
+		return
+	}
+
+	t.writeLog(pupID, p, "Stopped simulated container")
+	delete(t.procs, pupID)
+}
+
+func (t *Runtime) Status(pupID string) dogeboxd.ProcStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.procs[pupID]
+	if !ok {
+		return dogeboxd.ProcStatus{}
+	}
+
+	return p.status()
+}
+
+func (p *process) status() dogeboxd.ProcStatus {
+	elapsed := time.Since(p.started).Seconds()
+
+	// Slow sine waves, with memory creeping up for the first few minutes.
+	cpu := 5 + 4*math.Sin(elapsed/20+p.phase)
+	memMb := 48 + 8*math.Sin(elapsed/60+p.phase) + math.Min(elapsed/10, 64)
+
+	return dogeboxd.ProcStatus{
+		CPUPercent: cpu,
+		MEMPercent: memMb / 8192 * 100,
+		MEMMb:      memMb,
+		Running:    true,
+	}
+}
+
+// writeLog appends to the container log in the same
+// format the container-log-forwarder writes with.
+func (t *Runtime) writeLog(pupID string, p *process, msg string) {
+	f, err := os.OpenFile(filepath.Join(t.config.ContainerLogDir, "pup-"+pupID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("[simulated] Failed to write container log for %s: %v", pupID, err)
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "%s pup-%s simulated[%d]: %s\n", time.Now().Format("2006-01-02T15:04:05-0700"), pupID, p.pid, msg)
+}
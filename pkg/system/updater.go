@@ -4,10 +4,10 @@ import (
 	"context"
 	"crypto/sha256"
 	_ "embed"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
@@ -21,7 +21,7 @@ dogeboxd.Dogeboxd, especially as they relate to the operating system.
 
 */
 
-func NewSystemUpdater(config dogeboxd.ServerConfig, networkManager dogeboxd.NetworkManager, nixManager dogeboxd.NixManager, sourceManager dogeboxd.SourceManager, pupManager dogeboxd.PupManager, stateManager dogeboxd.StateManager, dkm dogeboxd.DKMManager) SystemUpdater {
+func NewSystemUpdater(config dogeboxd.ServerConfig, networkManager dogeboxd.NetworkManager, nixManager dogeboxd.NixManager, sourceManager dogeboxd.SourceManager, pupManager dogeboxd.PupManager, stateManager dogeboxd.StateManager, dkm dogeboxd.DKMManager, dbxRoot dogeboxd.DBXRoot) SystemUpdater {
 	return SystemUpdater{
 		config:     config,
 		jobs:       make(chan dogeboxd.Job),
@@ -32,6 +32,7 @@ func NewSystemUpdater(config dogeboxd.ServerConfig, networkManager dogeboxd.Netw
 		pupManager: pupManager,
 		sm:         stateManager,
 		dkm:        dkm,
+		dbxRoot:    dbxRoot,
 	}
 }
 
@@ -45,11 +46,16 @@ type SystemUpdater struct {
 	pupManager dogeboxd.PupManager
 	sm         dogeboxd.StateManager
 	dkm        dogeboxd.DKMManager
+	dbxRoot    dogeboxd.DBXRoot
 }
 
 func (t SystemUpdater) Run(started, stopped chan bool, stop chan context.Context) error {
 	go func() {
 		go func() {
+			// Before taking any jobs, so enabling a pup can't race
+			// us to issue its token.
+			t.issueMissingPupTokens()
+
 		mainloop:
 			for {
 			dance:
@@ -125,6 +131,55 @@ func (t SystemUpdater) Run(started, stopped chan bool, stop chan context.Context
 						}
 						t.done <- j
 
+					case dogeboxd.RollbackNixGeneration:
+						err := t.rollbackNixGeneration(a, j.Logger.Step("rollback"))
+						if err != nil {
+							j.Err = "Failed to roll back nix generation"
+						}
+						t.done <- j
+
+					case dogeboxd.WriteCustomNixModule:
+						err := t.writeCustomNixModule(a, j.Logger.Step("write custom nix module"))
+						if err != nil {
+							j.Err = "Failed to write custom nix module"
+						}
+						t.done <- j
+
+					case dogeboxd.RemoveCustomNixModule:
+						err := t.removeCustomNixModule(a, j.Logger.Step("remove custom nix module"))
+						if err != nil {
+							j.Err = "Failed to remove custom nix module"
+						}
+						t.done <- j
+
+					case dogeboxd.EnableCustomNixModules:
+						err := t.setCustomNixModulesDisabled(false, j.Logger.Step("enable custom nix modules"))
+						if err != nil {
+							j.Err = "Failed to enable custom nix modules"
+						}
+						t.done <- j
+
+					case dogeboxd.DisableCustomNixModules:
+						err := t.setCustomNixModulesDisabled(true, j.Logger.Step("disable custom nix modules"))
+						if err != nil {
+							j.Err = "Failed to disable custom nix modules"
+						}
+						t.done <- j
+
+					case dogeboxd.CollectGarbage:
+						err := t.collectGarbage(a, j.Logger.Step("collect garbage"))
+						if err != nil {
+							j.Err = "Failed to collect nix garbage"
+						}
+						t.done <- j
+
+					case dogeboxd.ExportSourceMirror:
+						err := t.exportSourceMirror(a, j.Logger.Step("export mirror"))
+						if err != nil {
+							j.Err = fmt.Sprintf("Failed to export mirror: %v", err)
+						}
+						t.done <- j
+
 					default:
 						fmt.Printf("Unknown action type: %v\n", a)
 					}
@@ -177,12 +232,23 @@ func (t SystemUpdater) installPup(pupSelection dogeboxd.InstallPup, j dogeboxd.J
 	pupPath := filepath.Join(t.config.DataDir, "pups", s.ID)
 
 	log.Logf("Downloading pup to %s", pupPath)
-	err := t.sources.DownloadPup(pupPath, pupSelection.SourceId, pupSelection.PupName, pupSelection.PupVersion)
+	pin, err := t.sources.DownloadPup(pupPath, pupSelection.SourceId, pupSelection.PupName, pupSelection.PupVersion, pupSelection.AcceptSourceChanges)
 	if err != nil {
 		log.Errf("Failed to download pup: %w", err)
+		if errors.Is(err, dogeboxd.ErrPupSignatureInvalid) {
+			return t.markPupBroken(s, dogeboxd.BROKEN_REASON_SIGNATURE_INVALID, err)
+		}
+		if errors.Is(err, dogeboxd.ErrPupSourceChanged) {
+			return t.markPupBroken(s, dogeboxd.BROKEN_REASON_SOURCE_CHANGED, err)
+		}
 		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_DOWNLOAD_FAILED, err)
 	}
 
+	if _, err := t.pupManager.UpdatePup(s.ID, dogeboxd.SetPupSourcePin(pin)); err != nil {
+		log.Errf("Failed to record pup source pin: %v", err)
+		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_STATE_UPDATE_FAILED, err)
+	}
+
 	// Ensure the nix file configured in the manifest matches the hash specified.
 	// Read pupPath s.Manifest.Container.Build.NixFile and hash it with sha256
 	nixFile, err := os.ReadFile(filepath.Join(pupPath, s.Manifest.Container.Build.NixFile))
@@ -199,9 +265,7 @@ func (t SystemUpdater) installPup(pupSelection dogeboxd.InstallPup, j dogeboxd.J
 	}
 
 	// create the storage dir
-	cmd := exec.Command("sudo", "_dbxroot", "pup", "create-storage", "--data-dir", t.config.DataDir, "--pupId", s.ID)
-	log.LogCmd(cmd)
-	err = cmd.Run()
+	err = t.dbxRoot.Run(log, "pup", "create-storage", "--data-dir", t.config.DataDir, "--pupId", s.ID)
 	if err != nil {
 		log.Errf("Failed to create pup storage: %v. Command output: %s", err)
 		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_STORAGE_CREATION_FAILED, err)
@@ -213,22 +277,23 @@ func (t SystemUpdater) installPup(pupSelection dogeboxd.InstallPup, j dogeboxd.J
 		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_DELEGATE_KEY_CREATION_FAILED, err)
 	}
 
-	cmd = exec.Command("sudo", "_dbxroot", "pup", "write-key", "--data-dir", t.config.DataDir, "--pupId", s.ID, "--key-file", "delegated.key", "--data", keyData.Priv)
-	log.LogCmd(cmd)
-	err = cmd.Run()
+	err = t.dbxRoot.Run(log, "pup", "write-key", "--data-dir", t.config.DataDir, "--pupId", s.ID, "--key-file", "delegated.key", "--data", keyData.Priv)
 	if err != nil {
 		log.Errf("Failed to create delegate key in storage: %v. Command output: %s", err)
 		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_DELEGATE_KEY_WRITE_FAILED, err)
 	}
 
-	cmd = exec.Command("sudo", "_dbxroot", "pup", "write-key", "--data-dir", t.config.DataDir, "--pupId", s.ID, "--key-file", "delegated.extended.key", "--data", keyData.Wif)
-	log.LogCmd(cmd)
-	err = cmd.Run()
+	err = t.dbxRoot.Run(log, "pup", "write-key", "--data-dir", t.config.DataDir, "--pupId", s.ID, "--key-file", "delegated.extended.key", "--data", keyData.Wif)
 	if err != nil {
 		log.Errf("Failed to create extended delegate key in storage: %v. Command output: %s", err)
 		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_DELEGATE_KEY_WRITE_FAILED, err)
 	}
 
+	// and the token it authenticates to the internal router with
+	if err := t.issuePupToken(s, log); err != nil {
+		return t.markPupBroken(s, dogeboxd.BROKEN_REASON_TOKEN_WRITE_FAILED, err)
+	}
+
 	// Now that we're mostly installed, enable it.
 	newState, err := t.pupManager.UpdatePup(s.ID, dogeboxd.PupEnabled(true))
 	if err != nil {
@@ -239,7 +304,7 @@ func (t SystemUpdater) installPup(pupSelection dogeboxd.InstallPup, j dogeboxd.J
 	dbxState := t.sm.Get().Dogebox
 
 	t.nix.WritePupFile(nixPatch, newState, dbxState)
-	t.nix.UpdateIncludesFile(nixPatch, t.pupManager)
+	t.nix.UpdateIncludesFile(nixPatch, t.pupManager, dbxState)
 
 	// Do a nix rebuild before we mark the pup as installed, this way
 	// the frontend will get a much longer "Installing.." state, as opposed
@@ -271,7 +336,7 @@ func (t SystemUpdater) uninstallPup(j dogeboxd.Job) error {
 	}
 
 	t.nix.RemovePupFile(nixPatch, s.ID)
-	t.nix.UpdateIncludesFile(nixPatch, t.pupManager)
+	t.nix.UpdateIncludesFile(nixPatch, t.pupManager, t.sm.Get().Dogebox)
 
 	if err := nixPatch.Apply(); err != nil {
 		log.Errf("Failed to apply nix patch: %w", err)
@@ -317,10 +382,7 @@ func (t SystemUpdater) purgePup(j dogeboxd.Job) error {
 	}
 
 	// Delete pup storage directory
-	cmd := exec.Command("sudo", "_dbxroot", "pup", "delete-storage", "--pupId", s.ID, "--data-dir", t.config.DataDir)
-	log.LogCmd(cmd)
-
-	if err := cmd.Run(); err != nil {
+	if err := t.dbxRoot.Run(log, "pup", "delete-storage", "--pupId", s.ID, "--data-dir", t.config.DataDir); err != nil {
 		log.Errf("Failed to remove pup storage: %v", err)
 		// Keep going if we fail.
 	}
@@ -338,6 +400,12 @@ func (t SystemUpdater) enablePup(j dogeboxd.Job) error {
 	log := j.Logger.Step("enable")
 	log.Logf("Enabling pup %s (%s)", s.Manifest.Meta.Name, s.ID)
 
+	if s.TokenHash == "" {
+		if err := t.issuePupToken(s, log); err != nil {
+			return err
+		}
+	}
+
 	newState, err := t.pupManager.UpdatePup(s.ID, dogeboxd.PupEnabled(true))
 	if err != nil {
 		log.Errf("Failed to update pup enabled state: %w", err)
@@ -368,10 +436,7 @@ func (t SystemUpdater) disablePup(j dogeboxd.Job) error {
 		return err
 	}
 
-	cmd := exec.Command("sudo", "_dbxroot", "pup", "stop", "--pupId", s.ID)
-	log.LogCmd(cmd)
-
-	if err := cmd.Run(); err != nil {
+	if err := t.dbxRoot.Run(log, "pup", "stop", "--pupId", s.ID); err != nil {
 		log.Errf("Error executing _dbxroot pup stop:", err)
 		return err
 	}
@@ -388,3 +453,17 @@ func (t SystemUpdater) disablePup(j dogeboxd.Job) error {
 
 	return nil
 }
+
+func (t SystemUpdater) rollbackNixGeneration(a dogeboxd.RollbackNixGeneration, log dogeboxd.SubLogger) error {
+	log.Logf("Rolling back to nix generation %d", a.Generation)
+
+	nixPatch := t.nix.NewPatch(log)
+	t.nix.RestoreGeneration(nixPatch, a.Generation)
+
+	if err := nixPatch.Apply(); err != nil {
+		log.Errf("Failed to apply nix patch: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"strings"
 )
 
 func sendResponse(w http.ResponseWriter, payload any) {
@@ -31,17 +31,14 @@ func sendErrorResponse(w http.ResponseWriter, code int, message string) {
 	w.Write([]byte(payload))
 }
 
+/* getOriginIP is the address a request actually came from. Only
+ * pups talk to the internal router, directly, so any forwarding
+ * headers are whatever the pup wanted to put in them: ignore them.
+ */
 func getOriginIP(r *http.Request) string {
-	var originIP string
-
-	// handle proxies
-	if r.Header.Get("X-Forwarded-For") != "" {
-		// If there are multiple IPs in X-Forwarded-For, take the first one
-		originIP = strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0]
-	} else {
-		// otherwise just use the remote address
-		originIP = strings.Split(r.RemoteAddr, ":")[0]
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-
-	return originIP
+	return host
 }
@@ -5,19 +5,14 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 )
 
 func (t InternalRouter) hookHandler(w http.ResponseWriter, r *http.Request) {
-	var originIsPup bool = false
-	originIP := getOriginIP(r)
-	_, _, err := t.pm.FindPupByIP(originIP)
-	if err == nil {
-		originIsPup = true
-	}
-
-	if !originIsPup {
+	if _, err := t.getOriginPup(r); err != nil {
 		// you must be a pup!
-		forbidden(w, "You are not a Pup we know about", originIP)
+		forbidden(w, "You are not a Pup we know about", getOriginIP(r))
 		return
 	}
 
@@ -51,7 +46,8 @@ done:
 				proxy.Director = func(req *http.Request) {
 					req.URL.Scheme = targetURL.Scheme
 					req.URL.Host = targetURL.Host
-					req.Header = r.Header
+					req.Header = r.Header.Clone()
+					req.Header.Del(dogeboxd.PUP_TOKEN_HEADER) // the calling pup's token
 				}
 				proxy.ServeHTTP(w, r)
 
@@ -103,8 +103,8 @@ func (t api) listKeys(w http.ResponseWriter, r *http.Request) {
 
 // Delegate keys to pups based on their pupID
 func (t InternalRouter) getDelegatedPupKeys(w http.ResponseWriter, r *http.Request) {
-	originPup, ok := t.getOriginPup(r)
-	if !ok {
+	originPup, err := t.getOriginPup(r)
+	if err != nil {
 		// you must be a pup!
 		forbidden(w, "You are not a Pup we know about")
 		return
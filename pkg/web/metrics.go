@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 )
@@ -44,8 +45,8 @@ func (t api) getPupMetrics(w http.ResponseWriter, r *http.Request) {
 }
 
 func (t InternalRouter) recordMetrics(w http.ResponseWriter, r *http.Request) {
-	originPup, ok := t.getOriginPup(r)
-	if !ok {
+	originPup, err := t.getOriginPup(r)
+	if err != nil {
 		// you must be a pup!
 		forbidden(w, "You are not a Pup we know about")
 		return
@@ -73,3 +74,38 @@ func (t InternalRouter) recordMetrics(w http.ResponseWriter, r *http.Request) {
 	id := t.dbx.AddAction(update)
 	sendResponse(w, map[string]string{"id": id})
 }
+
+// getPupRouterDenials lists requests to or from a pup that the internal router refused.
+func (t api) getPupRouterDenials(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, map[string]any{
+		"denials": t.routerLog.Denials(r.PathValue("ID")),
+	})
+}
+
+// How many access records GET /pup/{ID}/traffic returns unless asked otherwise.
+const defaultTrafficLimit = 100
+
+// getPupTraffic lists the most recent requests through the internal router to or from a pup.
+func (t api) getPupTraffic(w http.ResponseWriter, r *http.Request) {
+	pupID := r.PathValue("ID")
+
+	_, stats, err := t.pups.GetPup(pupID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	limit := defaultTrafficLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	sendResponse(w, map[string]any{
+		"traffic": t.routerLog.Traffic(pupID, limit),
+		"summary": stats.Router,
+	})
+}
@@ -0,0 +1,240 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+func (t api) listNixGenerations(w http.ResponseWriter, r *http.Request) {
+	generations, err := t.nix.ListGenerations()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error listing nix generations")
+		return
+	}
+
+	sendResponse(w, map[string]any{"generations": generations})
+}
+
+func (t api) diffNixGenerations(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.Atoi(r.PathValue("from"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid generation")
+		return
+	}
+
+	to, err := strconv.Atoi(r.PathValue("to"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid generation")
+		return
+	}
+
+	files, err := t.nix.DiffGenerations(from, to)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Error diffing nix generations: %v", err))
+		return
+	}
+
+	sendResponse(w, map[string]any{"files": files})
+}
+
+func (t api) rollbackNixGeneration(w http.ResponseWriter, r *http.Request) {
+	generation, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid generation")
+		return
+	}
+
+	a := dogeboxd.RollbackNixGeneration{Generation: generation}
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		options := dogeboxd.NixPatchDryRunOptions{
+			Evaluate: r.URL.Query().Get("evaluate") == "true",
+		}
+
+		result, err := t.dbx.SystemUpdater.DryRunAction(a, options, dogeboxd.NewConsoleSubLogger("internal", "dry-run"))
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to dry run rollback: %v", err))
+			return
+		}
+
+		sendResponse(w, result)
+		return
+	}
+
+	sendResponse(w, map[string]string{"id": t.dbx.AddAction(a)})
+}
+
+type CustomNixModulesStateRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type WriteCustomNixModuleRequest struct {
+	Content string `json:"content"`
+}
+
+func (t api) getCustomNixModulesState(w http.ResponseWriter, r *http.Request) {
+	state := t.sm.Get().Dogebox
+	sendResponse(w, map[string]any{"enabled": !state.CustomNix.Disabled})
+}
+
+func (t api) setCustomNixModulesState(w http.ResponseWriter, r *http.Request) {
+	var req CustomNixModulesStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	var action dogeboxd.Action
+	if req.Enabled {
+		action = dogeboxd.EnableCustomNixModules{}
+	} else {
+		action = dogeboxd.DisableCustomNixModules{}
+	}
+
+	sendResponse(w, map[string]string{"id": t.dbx.AddAction(action)})
+}
+
+func (t api) listCustomNixModules(w http.ResponseWriter, r *http.Request) {
+	modules, err := t.nix.ListCustomModules()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error listing custom nix modules")
+		return
+	}
+
+	sendResponse(w, map[string]any{"modules": modules})
+}
+
+func (t api) getCustomNixModule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	module, err := t.nix.GetCustomModule(name)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Custom nix module not found")
+		return
+	}
+
+	sendResponse(w, module)
+}
+
+func (t api) writeCustomNixModule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var req WriteCustomNixModuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Custom nix module content is required")
+		return
+	}
+
+	t.customNixModuleAction(w, r, dogeboxd.WriteCustomNixModule{Name: name, Content: req.Content})
+}
+
+func (t api) removeCustomNixModule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := dogeboxd.ValidateNixCustomModuleName(name); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t.customNixModuleAction(w, r, dogeboxd.RemoveCustomNixModule{Name: name})
+}
+
+func (t api) customNixModuleAction(w http.ResponseWriter, r *http.Request, a dogeboxd.Action) {
+	if r.URL.Query().Get("dryRun") == "true" {
+		options := dogeboxd.NixPatchDryRunOptions{
+			Evaluate: r.URL.Query().Get("evaluate") == "true",
+		}
+
+		result, err := t.dbx.SystemUpdater.DryRunAction(a, options, dogeboxd.NewConsoleSubLogger("internal", "dry-run"))
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to dry run custom nix module change: %v", err))
+			return
+		}
+
+		sendResponse(w, result)
+		return
+	}
+
+	sendResponse(w, map[string]string{"id": t.dbx.AddAction(a)})
+}
+
+type CollectGarbageRequest struct {
+	KeepGenerations int  `json:"keepGenerations"`
+	DryRun          bool `json:"dryRun"`
+}
+
+type GarbageCollectionScheduleRequest struct {
+	Enabled         bool `json:"enabled"`
+	IntervalHours   int  `json:"intervalHours"`
+	KeepGenerations int  `json:"keepGenerations"`
+}
+
+func (t api) collectGarbage(w http.ResponseWriter, r *http.Request) {
+	var req CollectGarbageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	if req.KeepGenerations < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "keepGenerations must not be negative")
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.CollectGarbage{
+		KeepGenerations: req.KeepGenerations,
+		DryRun:          req.DryRun,
+	})
+	sendResponse(w, map[string]string{"id": id})
+}
+
+func (t api) getGarbageCollectionSchedule(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, t.sm.Get().Dogebox.GarbageCollection)
+}
+
+func (t api) setGarbageCollectionSchedule(w http.ResponseWriter, r *http.Request) {
+	var req GarbageCollectionScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	if req.Enabled && req.IntervalHours <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "intervalHours must be positive")
+		return
+	}
+
+	if req.KeepGenerations < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "keepGenerations must not be negative")
+		return
+	}
+
+	dbxState := t.sm.Get().Dogebox
+	dbxState.GarbageCollection.Enabled = req.Enabled
+	dbxState.GarbageCollection.IntervalHours = req.IntervalHours
+	dbxState.GarbageCollection.KeepGenerations = req.KeepGenerations
+
+	if err := t.sm.SetDogebox(dbxState); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error saving state")
+		return
+	}
+
+	sendResponse(w, dbxState.GarbageCollection)
+}
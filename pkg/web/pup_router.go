@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -11,24 +12,39 @@ import (
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 	"github.com/dogeorg/dogeboxd/pkg/conductor"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-func NewInternalRouter(config dogeboxd.ServerConfig, dbx dogeboxd.Dogeboxd, pm dogeboxd.PupManager, dkm dogeboxd.DKMManager) conductor.Service {
+// how long a stream can outlive a revoked grant
+const routerStreamCheckInterval = 2 * time.Second
+
+func NewInternalRouter(config dogeboxd.ServerConfig, dbx dogeboxd.Dogeboxd, pm dogeboxd.PupManager, dkm dogeboxd.DKMManager, routerLog *RouterLog) conductor.Service {
 	return InternalRouter{
-		config: config,
-		pm:     pm,
-		dbx:    dbx,
-		dbxmux: http.NewServeMux(),
-		dkm:    dkm,
+		config:           config,
+		pm:               pm,
+		dbx:              dbx,
+		dbxmux:           http.NewServeMux(),
+		dkm:              dkm,
+		routerLog:        routerLog,
+		permissionRoutes: newPupRoutes(),
+		limiter:          newRouterLimiter(),
+		proxy:            newRouterProxy(),
+		streams:          newRouterStreams(),
 	}
 }
 
 type InternalRouter struct {
-	config dogeboxd.ServerConfig
-	dbx    dogeboxd.Dogeboxd
-	pm     dogeboxd.PupManager
-	dkm    dogeboxd.DKMManager
-	dbxmux *http.ServeMux
+	config           dogeboxd.ServerConfig
+	dbx              dogeboxd.Dogeboxd
+	pm               dogeboxd.PupManager
+	dkm              dogeboxd.DKMManager
+	dbxmux           *http.ServeMux
+	routerLog        *RouterLog
+	permissionRoutes *pupRoutes
+	limiter          *routerLimiter
+	proxy            *httputil.ReverseProxy
+	streams          *routerStreams
 }
 
 func (t InternalRouter) routes() {
@@ -41,8 +57,35 @@ func (t InternalRouter) routes() {
 func (t InternalRouter) Run(started, stopped chan bool, stop chan context.Context) error {
 	t.routes()
 	go func() {
+		// recompile a provider's routes whenever it changes, and
+		// cut off streams the change took away access to
+		go func() {
+			for p := range t.pm.GetUpdateChannel() {
+				t.permissionRoutes.update(p)
+				t.streams.revalidate(t.pm)
+			}
+		}()
+
+		// Pupdates are dropped when we're busy, and not every change
+		// (ie: providers) sends one, so check streams on a timer too.
+		done := make(chan struct{})
+		go func() {
+			check := time.NewTicker(routerStreamCheckInterval)
+			defer check.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-check.C:
+					t.streams.revalidate(t.pm)
+				}
+			}
+		}()
+
 		retry := time.NewTimer(time.Second)
-		srv := &http.Server{Addr: fmt.Sprintf("%s:%d", "10.69.0.1", t.config.InternalPort), Handler: t}
+		// h2c, so pups can speak HTTP/2 (ie: gRPC) without TLS
+		handler := h2c.NewHandler(t, &http2.Server{})
+		srv := &http.Server{Addr: fmt.Sprintf("%s:%d", "10.69.0.1", t.config.InternalPort), Handler: handler}
 		go func() {
 		mainloop:
 			for {
@@ -72,6 +115,7 @@ func (t InternalRouter) Run(started, stopped chan bool, stop chan context.Contex
 
 		started <- true
 		ctx := <-stop
+		close(done)
 		srv.Shutdown(ctx)
 		stopped <- true
 	}()
@@ -91,107 +135,115 @@ func (t InternalRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Everything from here on goes in the access log, denied or not.
+	access := dogeboxd.RouterAccess{Time: time.Now(), OriginIP: getOriginIP(r), Interface: iface, Method: r.Method, Path: r.URL.Path}
+	rec := newRouterResponseWriter(w)
+	w = rec
+	body := &countingBody{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	defer func() {
+		access.Status = rec.status
+		access.LatencyMs = float64(time.Since(access.Time).Microseconds()) / 1000
+		access.BytesIn = body.bytes
+		access.BytesOut = rec.bytes
+		t.routerLog.record(access)
+		t.pm.RecordRouterAccess(access)
+	}()
+
 	// Who is this request from?
-	originPup, ok := t.getOriginPup(r)
-	if !ok {
+	originPup, err := t.getOriginPup(r)
+	access.OriginPupID = originPup.ID
+	if err != nil {
 		// you must be a pup!
+		t.routerLog.deny(RouterDenial{OriginIP: getOriginIP(r), OriginPupID: originPup.ID, Interface: iface, Method: r.Method, Path: r.URL.Path, Reason: err.Error()})
 		forbidden(w, "You are not a Pup we know about")
 		return
 	}
-	//
+
+	deny := func(providerID string, reasons ...string) {
+		t.routerLog.deny(RouterDenial{OriginIP: getOriginIP(r), OriginPupID: originPup.ID, ProviderPupID: providerID, Interface: iface, Method: r.Method, Path: r.URL.Path, Reason: strings.Join(reasons, " ")})
+		forbidden(w, reasons...)
+	}
+
 	// check the pup has a provider for this interface and get the provider pup
 	providerID, ok := originPup.Providers[iface]
 	if !ok {
-		forbidden(w, "Your pup has no provider for interface: ", iface)
+		deny("", "Your pup has no provider for interface:", iface)
 		return
 	}
+	access.ProviderPupID = providerID
 
 	providerPup, _, err := t.pm.GetPup(providerID)
 	if err != nil {
-		forbidden(w, "Your pup's provider for this interface no longer exists")
+		deny(providerID, "Your pup's provider for this interface no longer exists")
 		return
 	}
 
-	// Does the request match any of their permissionGroup routes the provider provides?
-	routes := []string{}
-	for _, i := range providerPup.Manifest.Interfaces {
-		if i.Name == iface {
-			for _, pg := range i.PermissionGroups {
-				for _, r := range pg.Routes {
-					routes = append(routes, r)
-				}
-			}
-		}
+	// Only the permission groups the pup asked for, and the user granted, are open to it.
+	groups := originPup.GrantedPermissionGroups(iface, providerPup)
+	if len(groups) == 0 {
+		deny(providerID, "Your pup has not been granted any permission groups for interface:", iface)
+		return
 	}
 
-	matchingRoute := ""
-	for _, route := range routes {
-		// route = strings.Split(route, "/")
-		routeSegments := strings.Split(route, "/")[1:]
-		// Check if the route and path have the same number of segments or if the route has one less (due to a wildcard)
-		if len(routeSegments) > len(pathSegments) || len(routeSegments) == len(pathSegments)-1 {
-			continue
-		}
-
-		wildcardFound := false
-		match := true
-
-		for i, segment := range routeSegments {
-			if segment == "*" {
-				if wildcardFound {
-					// More than one wildcard is not allowed
-					match = false
-					break
-				}
-				wildcardFound = true
-			} else if i >= len(pathSegments) || segment != pathSegments[i] {
-				match = false
-				break
-			}
-		}
+	// The provider might resolve them, letting /public/../admin past a /public/** route.
+	if dogeboxd.HasDotSegments(r.URL.Path) {
+		deny(providerID, "Paths with . or .. segments are not allowed")
+		return
+	}
 
-		if match {
-			matchingRoute = route
-			break
-		}
+	group, ok := t.permissionRoutes.get(providerPup).match(iface, groups, r.Method, "/"+strings.Join(pathSegments, "/"))
+	if !ok {
+		deny(providerID, "No matching route available")
+		return
 	}
+	access.PermissionGroup = group.Name
 
-	if matchingRoute == "" {
-		forbidden(w, "No matching route available")
+	// Hold the pup to its rate limits on this interface.
+	release, throttled := t.limiter.acquire(originPup.ID, iface, originPup.RateLimit(iface, providerPup))
+	if throttled != "" {
+		t.pm.RecordRouterThrottle(providerID, originPup.ID)
+		t.routerLog.deny(RouterDenial{OriginIP: getOriginIP(r), OriginPupID: originPup.ID, ProviderPupID: providerID, Interface: iface, Method: r.Method, Path: r.URL.Path, Reason: throttled})
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, throttled, http.StatusTooManyRequests)
 		return
 	}
+	defer release()
 
 	// Rewrite the request and proxy
 	host := providerPup.IP
-	port := 0
-	// find the port the interface is listening on
-	for _, exp := range providerPup.Manifest.Container.Exposes {
-		for _, i := range exp.Interfaces {
-			if iface == i {
-				port = exp.Port
+	port := group.Port
+	if port == 0 {
+		// find the port the interface is listening on
+		for _, exp := range providerPup.Manifest.Container.Exposes {
+			for _, i := range exp.Interfaces {
+				if iface == i {
+					port = exp.Port
+				}
 			}
 		}
 	}
-	targetURL, err := url.Parse(fmt.Sprintf("http://%s:%d/%s", host, port, strings.Join(pathSegments, "/")))
-	if err != nil {
-		http.Error(w, "Failed to parse URL", http.StatusInternalServerError)
-		return
+	// Built rather than parsed: the segments are decoded, a %3F in them is not a query.
+	targetURL := &url.URL{
+		Scheme:   "http",
+		Host:     fmt.Sprintf("%s:%d", host, port),
+		Path:     "/" + strings.Join(pathSegments, "/"),
+		RawQuery: r.URL.RawQuery, // Copy the original request's query parameters
 	}
 
-	// Copy the original request's query parameters
-	targetURL.RawQuery = r.URL.RawQuery
+	// Track the request while it's in flight, so it can be closed if the grant it used is revoked.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer t.streams.add(routerStream{originPupID: originPup.ID, providerPupID: providerID, iface: iface, group: group.Name, cancel: cancel})()
 
 	// Create a new request with the modified URL
-	proxyReq := new(http.Request)
-	*proxyReq = *r
+	proxyReq := r.WithContext(ctx)
 	proxyReq.URL = targetURL
-	proxyReq.Host = targetURL.Host
 
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-
-	fmt.Printf("[router: %s -> %s] %s\n", originPup.Manifest.Meta.Name, providerPup.Manifest.Meta.Name, targetURL.Path)
 	// Serve the request to the proxy
-	proxy.ServeHTTP(w, proxyReq)
+	t.proxy.ServeHTTP(w, proxyReq)
 }
 
 func forbidden(w http.ResponseWriter, reasons ...string) {
@@ -203,12 +255,20 @@ func forbidden(w http.ResponseWriter, reasons ...string) {
 	w.Write([]byte(reason))
 }
 
-func (t InternalRouter) getOriginPup(r *http.Request) (dogeboxd.PupState, bool) {
-	var originIsPup bool = false
-	originIP := getOriginIP(r)
-	originPup, _, err := t.pm.FindPupByIP(originIP)
-	if err == nil {
-		originIsPup = true
+/* getOriginPup works out which pup a request is from. It must
+ * come from the pup's own IP and carry the pup's token (see
+ * dogeboxd.PUP_TOKEN_FILE) in the dogeboxd.PUP_TOKEN_HEADER.
+ */
+func (t InternalRouter) getOriginPup(r *http.Request) (dogeboxd.PupState, error) {
+	originPup, _, err := t.pm.FindPupByIP(getOriginIP(r))
+	if err != nil {
+		return dogeboxd.PupState{}, errors.New("not a pup")
 	}
-	return originPup, originIsPup
+
+	token := r.Header.Get(dogeboxd.PUP_TOKEN_HEADER)
+	if !originPup.CheckToken(token) {
+		return originPup, errors.New("missing or invalid pup token")
+	}
+
+	return originPup, nil
 }
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"log"
+	"sync"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* pupRoutes holds each provider's permission group routes,
+ * compiled when the pup changes rather than on every request
+ * through the internal router.
+ */
+type pupRoutes struct {
+	mu     sync.Mutex
+	tables map[string]pupRouteTable
+}
+
+type pupRouteTable struct {
+	version    string                               // the manifest version this was compiled from
+	interfaces map[string][]compiledPermissionGroup // by interface name
+}
+
+type compiledPermissionGroup struct {
+	group  dogeboxd.PupManifestPermissionGroup
+	routes []dogeboxd.PermissionRoute
+}
+
+func newPupRoutes() *pupRoutes {
+	return &pupRoutes{tables: map[string]pupRouteTable{}}
+}
+
+func (t *pupRoutes) update(p dogeboxd.Pupdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch p.State.Installation {
+	case dogeboxd.STATE_UNINSTALLED, dogeboxd.STATE_PURGING:
+		delete(t.tables, p.ID)
+	default:
+		t.tables[p.ID] = compilePupRoutes(p.State)
+	}
+}
+
+// get returns a provider's routes, compiling them if we missed its pupdate.
+func (t *pupRoutes) get(p dogeboxd.PupState) pupRouteTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	table, ok := t.tables[p.ID]
+	if !ok || table.version != p.Manifest.Meta.Version {
+		table = compilePupRoutes(p)
+		t.tables[p.ID] = table
+	}
+	return table
+}
+
+func compilePupRoutes(p dogeboxd.PupState) pupRouteTable {
+	table := pupRouteTable{
+		version:    p.Manifest.Meta.Version,
+		interfaces: map[string][]compiledPermissionGroup{},
+	}
+
+	for _, iface := range p.Manifest.Interfaces {
+		for _, pg := range iface.PermissionGroups {
+			compiled := compiledPermissionGroup{group: pg}
+			for _, route := range pg.Routes {
+				r, err := dogeboxd.ParsePermissionRoute(route)
+				if err != nil {
+					// A route we can't understand never matches.
+					log.Printf("[router] pup %s, permission group %s: %v", p.ID, pg.Name, err)
+					continue
+				}
+				compiled.routes = append(compiled.routes, r)
+			}
+			table.interfaces[iface.Name] = append(table.interfaces[iface.Name], compiled)
+		}
+	}
+
+	return table
+}
+
+// match finds the first of the granted groups with a route matching the request.
+func (t pupRouteTable) match(iface string, granted []dogeboxd.PupManifestPermissionGroup, method, path string) (dogeboxd.PupManifestPermissionGroup, bool) {
+	for _, compiled := range t.interfaces[iface] {
+		if !isGranted(granted, compiled.group.Name) {
+			continue
+		}
+		for _, route := range compiled.routes {
+			if _, ok := route.Match(method, path); ok {
+				return compiled.group, true
+			}
+		}
+	}
+	return dogeboxd.PupManifestPermissionGroup{}, false
+}
+
+func isGranted(granted []dogeboxd.PupManifestPermissionGroup, name string) bool {
+	for _, pg := range granted {
+		if pg.Name == name {
+			return true
+		}
+	}
+	return false
+}
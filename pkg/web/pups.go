@@ -4,7 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 )
@@ -58,11 +63,177 @@ func (t api) updateProviders(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, map[string]string{"id": id})
 }
 
+// What a pup asks for on one of its dependencies, and what it has been granted.
+type PupGrantReport struct {
+	Interface string                           `json:"interface"`
+	Provider  string                           `json:"provider"`
+	Requested []dogeboxd.PupPermissionSeverity `json:"requested"`
+	Granted   []string                         `json:"granted"`
+}
+
+func (t api) getPupGrants(w http.ResponseWriter, r *http.Request) {
+	pup, _, err := t.pups.GetPup(r.PathValue("ID"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	grants := []PupGrantReport{}
+	for _, dep := range pup.Manifest.Dependencies {
+		report := PupGrantReport{
+			Interface: dep.InterfaceName,
+			Provider:  pup.Providers[dep.InterfaceName],
+			Requested: []dogeboxd.PupPermissionSeverity{},
+			Granted:   pup.Grants[dep.InterfaceName],
+		}
+		if report.Granted == nil {
+			report.Granted = []string{}
+		}
+
+		// Without a provider we don't know what the groups are.
+		if provider, _, err := t.pups.GetPup(report.Provider); err == nil {
+			report.Requested = pup.PermissionRequests(dep.InterfaceName, provider.Manifest)
+		}
+
+		grants = append(grants, report)
+	}
+	sendResponse(w, grants)
+}
+
+type PupGrantsRequest struct {
+	PermissionGroups []string `json:"permissionGroups"`
+}
+
+func (t api) grantPupPermissions(w http.ResponseWriter, r *http.Request) {
+	pupID := r.PathValue("ID")
+	iface := r.PathValue("interface")
+
+	var req PupGrantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	pup, _, err := t.pups.GetPup(pupID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	// Only what the pup asked for can be granted.
+	requested := pup.RequestedPermissionGroups(iface)
+	for _, group := range req.PermissionGroups {
+		if !slices.Contains(requested, group) {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Pup has not requested permission group %s on %s", group, iface))
+			return
+		}
+	}
+
+	id := t.dbx.AddAction(dogeboxd.UpdatePupGrants{PupID: pupID, Interface: iface, PermissionGroups: req.PermissionGroups})
+	sendResponse(w, map[string]string{"id": id})
+}
+
+// Revokes the permission groups given as ?group=, or every group on the interface.
+func (t api) revokePupPermissions(w http.ResponseWriter, r *http.Request) {
+	pupID := r.PathValue("ID")
+
+	if _, _, err := t.pups.GetPup(pupID); err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.UpdatePupGrants{
+		PupID:            pupID,
+		Interface:        r.PathValue("interface"),
+		PermissionGroups: r.URL.Query()["group"],
+		Revoke:           true,
+	})
+	sendResponse(w, map[string]string{"id": id})
+}
+
+// The rate limits a pup is held to on one of its dependencies.
+type PupRateLimitReport struct {
+	Interface string                 `json:"interface"`
+	Provider  string                 `json:"provider"`
+	Default   dogeboxd.PupRateLimit  `json:"default"`  // from the granted permission groups
+	Override  *dogeboxd.PupRateLimit `json:"override"` // set by the user
+	Effective dogeboxd.PupRateLimit  `json:"effective"`
+}
+
+func (t api) getPupRateLimits(w http.ResponseWriter, r *http.Request) {
+	pup, _, err := t.pups.GetPup(r.PathValue("ID"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	limits := []PupRateLimitReport{}
+	for _, dep := range pup.Manifest.Dependencies {
+		report := PupRateLimitReport{
+			Interface: dep.InterfaceName,
+			Provider:  pup.Providers[dep.InterfaceName],
+		}
+
+		provider, _, _ := t.pups.GetPup(report.Provider)
+		report.Effective = pup.RateLimit(dep.InterfaceName, provider)
+
+		if override, ok := pup.RateLimits[dep.InterfaceName]; ok {
+			report.Override = &override
+			withoutOverride := pup
+			withoutOverride.RateLimits = nil
+			report.Default = withoutOverride.RateLimit(dep.InterfaceName, provider)
+		} else {
+			report.Default = report.Effective
+		}
+
+		limits = append(limits, report)
+	}
+	sendResponse(w, limits)
+}
+
+func (t api) setPupRateLimit(w http.ResponseWriter, r *http.Request) {
+	pupID := r.PathValue("ID")
+
+	var limit dogeboxd.PupRateLimit
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error unmarshalling JSON")
+		return
+	}
+
+	if limit.RequestsPerSecond < 0 || limit.Burst < 0 || limit.MaxConcurrent < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Rate limits can't be negative")
+		return
+	}
+
+	if _, _, err := t.pups.GetPup(pupID); err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.UpdatePupRateLimit{PupID: pupID, Interface: r.PathValue("interface"), Limit: &limit})
+	sendResponse(w, map[string]string{"id": id})
+}
+
+// Drops the user's override, going back to the permission group defaults.
+func (t api) resetPupRateLimit(w http.ResponseWriter, r *http.Request) {
+	pupID := r.PathValue("ID")
+
+	if _, _, err := t.pups.GetPup(pupID); err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Cannot find pup")
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.UpdatePupRateLimit{PupID: pupID, Interface: r.PathValue("interface")})
+	sendResponse(w, map[string]string{"id": id})
+}
+
 type InstallPupRequest struct {
-	PupName      string `json:"pupName"`
-	PupVersion   string `json:"pupVersion"`
-	SourceId     string `json:"sourceId"`
-	SessionToken string
+	PupName             string `json:"pupName"`
+	PupVersion          string `json:"pupVersion"`
+	SourceId            string `json:"sourceId"`
+	SessionToken        string
+	AcceptSourceChanges bool                `json:"acceptSourceChanges"`
+	Grants              map[string][]string `json:"grants"`
 }
 
 func (t api) installPup(w http.ResponseWriter, r *http.Request) {
@@ -91,6 +262,84 @@ func (t api) installPup(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, map[string]string{"id": id})
 }
 
+// Pups are mostly nix expressions and small binaries, this is plenty.
+const maxSideloadArchiveSize = 512 << 20
+
+type SideloadPupRequest struct {
+	// Path to an archive already on this machine, eg: a mounted USB stick.
+	Path string `json:"path"`
+}
+
+/* sideloadPup accepts a pup archive as the raw request body, as an
+ * "archive" multipart file upload, or as a JSON path to an archive
+ * on local storage. The pup is added to the sideloaded source and
+ * installed through a normal InstallPup job.
+ */
+func (t api) sideloadPup(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSideloadArchiveSize)
+	defer r.Body.Close()
+
+	var archive io.Reader = r.Body
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch contentType {
+	case "multipart/form-data":
+		file, _, err := r.FormFile("archive")
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing archive upload")
+			return
+		}
+		defer file.Close()
+		archive = file
+
+	case "application/json":
+		var req SideloadPupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+			return
+		}
+
+		if !filepath.IsAbs(req.Path) {
+			sendErrorResponse(w, http.StatusBadRequest, "Archive path must be absolute")
+			return
+		}
+
+		file, err := os.Open(req.Path)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Error opening archive: "+err.Error())
+			return
+		}
+		defer file.Close()
+		archive = file
+	}
+
+	pup, err := t.sources.SideloadPup(archive)
+	if err != nil {
+		log.Printf("Error sideloading pup: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, "Error sideloading pup: "+err.Error())
+		return
+	}
+
+	session, sessionOK := getSession(r, getBearerToken)
+	if !sessionOK {
+		sendErrorResponse(w, http.StatusBadRequest, "Failed to fetch session")
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.InstallPup{
+		PupName:      pup.Name,
+		PupVersion:   pup.Version,
+		SourceId:     dogeboxd.SOURCE_ID_SIDELOADED,
+		SessionToken: session.DKM_TOKEN,
+	})
+
+	sendResponse(w, map[string]string{
+		"id":         id,
+		"pupName":    pup.Name,
+		"pupVersion": pup.Version,
+	})
+}
+
 func (t api) pupAction(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("ID")
 	action := r.PathValue("action")
@@ -115,6 +364,21 @@ func (t api) pupAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("dryRun") == "true" {
+		options := dogeboxd.NixPatchDryRunOptions{
+			Evaluate: r.URL.Query().Get("evaluate") == "true",
+		}
+
+		result, err := t.dbx.SystemUpdater.DryRunAction(a, options, dogeboxd.NewConsoleSubLogger(id, "dry-run"))
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to dry run pup action %s: %v", action, err))
+			return
+		}
+
+		sendResponse(w, result)
+		return
+	}
+
 	sendResponse(w, map[string]string{"id": t.dbx.AddAction(a)})
 }
 
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+/* routerLimiter enforces dogeboxd.PupRateLimit on the internal
+ * router, with a token bucket and an in-flight count for every
+ * consumer pup and interface it uses.
+ */
+type routerLimiter struct {
+	mu    sync.Mutex
+	pairs map[string]*pairLimit
+}
+
+type pairLimit struct {
+	limit    dogeboxd.PupRateLimit
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+func newRouterLimiter() *routerLimiter {
+	return &routerLimiter{pairs: map[string]*pairLimit{}}
+}
+
+/* acquire lets a request through if limit allows it. The returned
+ * release must be called once the request is done. If the request
+ * is refused, reason says why.
+ */
+func (l *routerLimiter) acquire(consumerID, iface string, limit dogeboxd.PupRateLimit) (release func(), reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := consumerID + "/" + iface
+	now := time.Now()
+
+	pair, ok := l.pairs[key]
+	if !ok {
+		pair = &pairLimit{}
+		l.pairs[key] = pair
+	}
+	if !ok || pair.limit != limit {
+		// new, or the limits changed: start with a full bucket
+		pair.limit = limit
+		pair.tokens = burstSize(limit)
+		pair.last = now
+	}
+
+	if limit.MaxConcurrent > 0 && pair.inFlight >= limit.MaxConcurrent {
+		return nil, "Too many concurrent requests"
+	}
+
+	if limit.RequestsPerSecond > 0 {
+		pair.tokens = math.Min(burstSize(limit), pair.tokens+now.Sub(pair.last).Seconds()*limit.RequestsPerSecond)
+		pair.last = now
+		if pair.tokens < 1 {
+			return nil, "Rate limit exceeded"
+		}
+		pair.tokens--
+	}
+
+	pair.inFlight++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		pair.inFlight--
+	}, ""
+}
+
+func burstSize(limit dogeboxd.PupRateLimit) float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	return math.Max(1, math.Ceil(limit.RequestsPerSecond))
+}
@@ -23,6 +23,7 @@ func RESTAPI(
 	nix dogeboxd.NixManager,
 	dkm dogeboxd.DKMManager,
 	ws WSRelay,
+	routerLog *RouterLog,
 ) conductor.Service {
 	sessions = []Session{}
 
@@ -53,6 +54,7 @@ func RESTAPI(
 		lifecycle: lifecycle,
 		nix:       nix,
 		sources:   sources,
+		routerLog: routerLog,
 	}
 
 	routes := map[string]http.HandlerFunc{}
@@ -84,24 +86,68 @@ func RESTAPI(
 		"GET /system/ssh/keys":        a.listSSHKeys,
 		"PUT /system/ssh/key":         a.addSSHKey,
 		"DELETE /system/ssh/key/{id}": a.removeSSHKey,
-		"/ws/state/":                  a.getUpdateSocket,
+
+		// Recovery mode can switch custom nix modules off if one breaks the system.
+		"GET /system/nix/modules/state": a.getCustomNixModulesState,
+		"PUT /system/nix/modules/state": a.setCustomNixModulesState,
+		"GET /system/nix/modules":       a.listCustomNixModules,
+
+		"/ws/state/": a.getUpdateSocket,
 	}
 
 	// Normal routes are used when we are not in recovery mode.
 	// nb. These are used in _addition_ to recovery routes.
 	normalRoutes := map[string]http.HandlerFunc{
-		"GET /pup/{ID}/metrics":   a.getPupMetrics,
-		"POST /pup/{ID}/{action}": a.pupAction,
-		"PUT /pup":                a.installPup,
-		"POST /config/{PupID}":    a.updateConfig,
-		"POST /providers/{PupID}": a.updateProviders,
-		"GET /providers/{PupID}":  a.getPupProviders,
-		"POST /hooks/{PupID}":     a.updateHooks,
-		"GET /sources":            a.getSources,
-		"PUT /source":             a.createSource,
-		"GET /sources/store":      a.getStoreList,
-		"DELETE /source/{id}":     a.deleteSource,
-		"/ws/log/{PupID}":         a.getLogSocket,
+		"GET /pup/{ID}/metrics":          a.getPupMetrics,
+		"GET /pup/{ID}/router/denials":   a.getPupRouterDenials,
+		"GET /pup/{ID}/traffic":          a.getPupTraffic,
+		"GET /pup/{ID}/grants":           a.getPupGrants,
+		"GET /pup/{ID}/rate-limits":      a.getPupRateLimits,
+		"POST /pup/{ID}/{action}":        a.pupAction,
+		"PUT /pup":                       a.installPup,
+		"POST /pup/sideload":             a.sideloadPup,
+		"POST /config/{PupID}":           a.updateConfig,
+		"POST /providers/{PupID}":        a.updateProviders,
+		"GET /providers/{PupID}":         a.getPupProviders,
+		"POST /hooks/{PupID}":            a.updateHooks,
+		"GET /sources":                   a.getSources,
+		"PUT /source":                    a.createSource,
+		"GET /sources/store":             a.getStoreList,
+		"GET /sources/store/search":      a.queryStore,
+		"DELETE /source/{id}":            a.deleteSource,
+		"PUT /source/{id}/signing":       a.setSourceSigning,
+		"POST /source/credential":        a.createSourceCredential,
+		"GET /source/credentials":        a.getSourceCredentials,
+		"DELETE /source/credential/{id}": a.deleteSourceCredential,
+		"POST /source/{id}/mirror":       a.exportSourceMirror,
+		"POST /source/mirror/import":     a.importSourceMirror,
+		"/ws/log/{PupID}":                a.getLogSocket,
+
+		"GET /sources/store/{source}/{name}/{version}": a.getStorePup,
+
+		"PUT /pup/{ID}/grants/{interface}":    a.grantPupPermissions,
+		"DELETE /pup/{ID}/grants/{interface}": a.revokePupPermissions,
+
+		"PUT /pup/{ID}/rate-limits/{interface}":    a.setPupRateLimit,
+		"DELETE /pup/{ID}/rate-limits/{interface}": a.resetPupRateLimit,
+
+		"GET /sources/refresh":      a.getSourceRefresh,
+		"PUT /sources/refresh":      a.setSourceRefresh,
+		"GET /sources/dev-channels": a.getDevChannels,
+		"PUT /sources/dev-channels": a.setDevChannels,
+		"PUT /source/{id}/channel":  a.setSourceChannel,
+
+		"GET /system/nix/generations":                  a.listNixGenerations,
+		"GET /system/nix/generations/{from}/diff/{to}": a.diffNixGenerations,
+		"POST /system/nix/generations/{id}/rollback":   a.rollbackNixGeneration,
+
+		"GET /system/nix/module/{name}":    a.getCustomNixModule,
+		"PUT /system/nix/module/{name}":    a.writeCustomNixModule,
+		"DELETE /system/nix/module/{name}": a.removeCustomNixModule,
+
+		"POST /system/nix/gc":         a.collectGarbage,
+		"GET /system/nix/gc/schedule": a.getGarbageCollectionSchedule,
+		"PUT /system/nix/gc/schedule": a.setGarbageCollectionSchedule,
 	}
 
 	// We always want to load recovery routes.
@@ -137,6 +183,7 @@ type api struct {
 	lifecycle dogeboxd.LifecycleManager
 	nix       dogeboxd.NixManager
 	ws        WSRelay
+	routerLog *RouterLog
 }
 
 func (t api) Run(started, stopped chan bool, stop chan context.Context) error {
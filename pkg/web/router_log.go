@@ -0,0 +1,96 @@
+package web
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+)
+
+// How many denied requests the internal router remembers.
+const routerLogSize = 500
+
+// How many requests the internal router keeps access records for.
+const routerAccessLogSize = 2000
+
+// RouterDenial is a request the internal router refused to forward.
+type RouterDenial struct {
+	Time          time.Time `json:"time"`
+	OriginIP      string    `json:"originIp"`
+	OriginPupID   string    `json:"originPupId,omitempty"`
+	ProviderPupID string    `json:"providerPupId,omitempty"`
+	Interface     string    `json:"interface"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Reason        string    `json:"reason"`
+}
+
+/* RouterLog keeps the most recent requests through the internal
+ * router, and separately the ones it denied, so a pup developer
+ * can see what their pup talks to and why it can't reach a
+ * provider. It is shared between the internal router, which
+ * writes to it, and the REST API, which serves it.
+ */
+type RouterLog struct {
+	mu       sync.Mutex
+	denials  []RouterDenial
+	accesses []dogeboxd.RouterAccess
+}
+
+func NewRouterLog() *RouterLog {
+	return &RouterLog{denials: []RouterDenial{}, accesses: []dogeboxd.RouterAccess{}}
+}
+
+func (l *RouterLog) deny(d RouterDenial) {
+	d.Time = time.Now()
+	log.Printf("[router: %s %s] denied %s %s on %s: %s", d.OriginIP, d.OriginPupID, d.Method, d.Path, d.Interface, d.Reason)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.denials = append(l.denials, d)
+	if len(l.denials) > routerLogSize {
+		l.denials = l.denials[len(l.denials)-routerLogSize:]
+	}
+}
+
+// Denials returns denied requests to or from a pup, newest first.
+func (l *RouterLog) Denials(pupID string) []RouterDenial {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	denials := []RouterDenial{}
+	for i := len(l.denials) - 1; i >= 0; i-- {
+		d := l.denials[i]
+		if d.OriginPupID == pupID || d.ProviderPupID == pupID {
+			denials = append(denials, d)
+		}
+	}
+	return denials
+}
+
+func (l *RouterLog) record(a dogeboxd.RouterAccess) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.accesses = append(l.accesses, a)
+	if len(l.accesses) > routerAccessLogSize {
+		l.accesses = l.accesses[len(l.accesses)-routerAccessLogSize:]
+	}
+}
+
+// Traffic returns up to limit requests to or from a pup, newest first.
+func (l *RouterLog) Traffic(pupID string, limit int) []dogeboxd.RouterAccess {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	traffic := []dogeboxd.RouterAccess{}
+	for i := len(l.accesses) - 1; i >= 0 && len(traffic) < limit; i-- {
+		a := l.accesses[i]
+		if a.OriginPupID == pupID || a.ProviderPupID == pupID {
+			traffic = append(traffic, a)
+		}
+	}
+	return traffic
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
+	"golang.org/x/net/http2"
+)
+
+/* newRouterProxy returns the reverse proxy the internal router
+ * sends every granted request through. Requests arrive with their
+ * URL already pointing at the provider. It streams: responses are
+ * flushed as they arrive (server-sent events), upgrades are passed
+ * through (WebSockets), and HTTP/2 requests go to the provider as
+ * h2c, so gRPC works end to end.
+ */
+func newRouterProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.Host = pr.Out.URL.Host
+			// the provider has no business seeing the origin pup's token
+			pr.Out.Header.Del(dogeboxd.PUP_TOKEN_HEADER)
+		},
+		Transport:     routerTransport{},
+		FlushInterval: -1,
+	}
+}
+
+var (
+	routerHTTP1Transport = http.DefaultTransport.(*http.Transport).Clone()
+	routerH2CTransport   = &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+)
+
+// routerTransport talks to providers in whichever HTTP version the origin pup used.
+type routerTransport struct{}
+
+func (routerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.ProtoMajor == 2 {
+		return routerH2CTransport.RoundTrip(r)
+	}
+	return routerHTTP1Transport.RoundTrip(r)
+}
+
+/* routerStreams tracks requests in flight through the internal
+ * router, so that long-lived ones (WebSockets, event streams, gRPC
+ * streams) can be cut off when the pup loses access to them.
+ */
+type routerStreams struct {
+	mu      sync.Mutex
+	next    int
+	streams map[int]routerStream
+}
+
+type routerStream struct {
+	originPupID   string
+	providerPupID string
+	iface         string
+	group         string
+	cancel        context.CancelFunc
+}
+
+func newRouterStreams() *routerStreams {
+	return &routerStreams{streams: map[int]routerStream{}}
+}
+
+// add tracks a stream until the returned func is called.
+func (t *routerStreams) add(s routerStream) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.next
+	t.next++
+	t.streams[id] = s
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.streams, id)
+	}
+}
+
+/* revalidate closes streams whose access has since been taken
+ * away: those from a pup that no longer has the permission group
+ * they were let in on, or that has moved to another provider, and
+ * those to a provider that is no longer installed. It checks the
+ * current state of both pups, so it doesn't matter what changed or
+ * whether we were told about it.
+ */
+func (t *routerStreams) revalidate(pm dogeboxd.PupManager) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, s := range t.streams {
+		reason := ""
+		origin, _, originErr := pm.GetPup(s.originPupID)
+		provider, _, providerErr := pm.GetPup(s.providerPupID)
+		switch {
+		case originErr != nil:
+			reason = "origin pup is gone"
+
+		case providerErr != nil:
+			reason = "provider is gone"
+
+		case provider.Installation != dogeboxd.STATE_READY:
+			reason = "provider is " + provider.Installation
+
+		case origin.Providers[s.iface] != s.providerPupID:
+			reason = "provider changed"
+
+		case !isGranted(origin.GrantedPermissionGroups(s.iface, provider), s.group):
+			reason = "permission group " + s.group + " revoked"
+		}
+
+		if reason != "" {
+			log.Printf("[router: %s -> %s] closing %s stream: %s", s.originPupID, s.providerPupID, s.iface, reason)
+			s.cancel()
+			delete(t.streams, id)
+		}
+	}
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
+
+	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
 )
 
 type CreateSourceRequest struct {
-	Location string `json:"location"`
+	Location     string `json:"location"`
+	CredentialID string `json:"credentialId"`
 }
 
 func (t api) createSource(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +31,7 @@ func (t api) createSource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := t.sources.AddSource(req.Location); err != nil {
+	if _, err := t.sources.AddSource(req.Location, req.CredentialID); err != nil {
 		log.Printf("Error adding source: %v", err)
 		sendErrorResponse(w, http.StatusInternalServerError, "Error adding source")
 		return
@@ -53,3 +59,266 @@ func (t api) deleteSource(w http.ResponseWriter, r *http.Request) {
 		"success": true,
 	})
 }
+
+type CreateSourceCredentialRequest struct {
+	Type     string `json:"type"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func (t api) createSourceCredential(w http.ResponseWriter, r *http.Request) {
+	var req CreateSourceCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	credential, err := t.sources.CreateSourceCredential(req.Type, req.Username, req.Token)
+	if err != nil {
+		log.Printf("Error creating source credential: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// For ssh this includes the public key to add as a deploy key.
+	sendResponse(w, credential)
+}
+
+func (t api) getSourceCredentials(w http.ResponseWriter, r *http.Request) {
+	credentials, err := t.sources.ListSourceCredentials()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error listing source credentials")
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"credentials": credentials,
+	})
+}
+
+func (t api) deleteSourceCredential(w http.ResponseWriter, r *http.Request) {
+	if err := t.sources.DeleteSourceCredential(r.PathValue("id")); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"success": true,
+	})
+}
+
+type SetSourceSigningRequest struct {
+	SigningKeys     []string `json:"signingKeys"`
+	SignaturePolicy string   `json:"signaturePolicy"`
+}
+
+func (t api) setSourceSigning(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error reading request body")
+		return
+	}
+	defer r.Body.Close()
+
+	var req SetSourceSigningRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	if err := t.sources.SetSourceSigning(id, req.SigningKeys, req.SignaturePolicy); err != nil {
+		log.Printf("Error setting source signing: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"success": true,
+	})
+}
+
+type SetSourceChannelRequest struct {
+	Channel string `json:"channel"`
+}
+
+func (t api) setSourceChannel(w http.ResponseWriter, r *http.Request) {
+	var req SetSourceChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	if err := t.sources.SetSourceChannel(r.PathValue("id"), req.Channel); err != nil {
+		log.Printf("Error setting source channel: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"success": true,
+	})
+}
+
+type DevChannelsRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (t api) getDevChannels(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, DevChannelsRequest{Enabled: t.sm.Get().Sources.DevChannels})
+}
+
+func (t api) setDevChannels(w http.ResponseWriter, r *http.Request) {
+	var req DevChannelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	if err := t.sources.SetDevChannels(req.Enabled); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error saving dev channel setting")
+		return
+	}
+
+	sendResponse(w, req)
+}
+
+func (t api) getSourceRefresh(w http.ResponseWriter, r *http.Request) {
+	state := t.sm.Get().Sources
+
+	sendResponse(w, map[string]any{
+		"refresh": state.Refresh,
+		"status":  state.Status,
+	})
+}
+
+func (t api) setSourceRefresh(w http.ResponseWriter, r *http.Request) {
+	var req dogeboxd.SourceRefreshConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	if req.IntervalMinutes < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "intervalMinutes must not be negative")
+		return
+	}
+
+	state := t.sm.Get().Sources
+	state.Refresh = req
+
+	if err := t.sm.SetSources(state); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error saving source refresh settings")
+		return
+	}
+
+	sendResponse(w, state.Refresh)
+}
+
+type ExportSourceMirrorRequest struct {
+	Pups     map[string][]string `json:"pups"`
+	Path     string              `json:"path"`
+	Closures bool                `json:"closures"`
+	Archive  bool                `json:"archive"`
+}
+
+func (t api) exportSourceMirror(w http.ResponseWriter, r *http.Request) {
+	var req ExportSourceMirrorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+		return
+	}
+
+	if !filepath.IsAbs(req.Path) {
+		sendErrorResponse(w, http.StatusBadRequest, "Mirror path must be absolute")
+		return
+	}
+
+	if _, err := t.sources.GetSource(r.PathValue("id")); err != nil {
+		sendErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	id := t.dbx.AddAction(dogeboxd.ExportSourceMirror{
+		SourceID: r.PathValue("id"),
+		Pups:     req.Pups,
+		Path:     req.Path,
+		Closures: req.Closures,
+		Archive:  req.Archive,
+	})
+	sendResponse(w, map[string]string{"id": id})
+}
+
+type ImportSourceMirrorRequest struct {
+	Path string `json:"path"`
+}
+
+// Mirrors with nix closures in them get big.
+const maxMirrorArchiveSize = 32 << 30
+
+func (t api) importSourceMirror(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMirrorArchiveSize)
+	defer r.Body.Close()
+
+	var source dogeboxd.ManifestSource
+	var err error
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch contentType {
+	case "application/json":
+		// A bundle directory (or archive) already on the box, ie. a mounted USB stick.
+		var req ImportSourceMirrorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Error parsing payload")
+			return
+		}
+
+		if !filepath.IsAbs(req.Path) {
+			sendErrorResponse(w, http.StatusBadRequest, "Mirror path must be absolute")
+			return
+		}
+
+		info, statErr := os.Stat(req.Path)
+		if statErr != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Error opening mirror: "+statErr.Error())
+			return
+		}
+
+		if info.IsDir() {
+			source, err = t.sources.ImportMirror(req.Path)
+		} else {
+			file, openErr := os.Open(req.Path)
+			if openErr != nil {
+				sendErrorResponse(w, http.StatusBadRequest, "Error opening mirror: "+openErr.Error())
+				return
+			}
+			defer file.Close()
+			source, err = t.sources.ImportMirrorArchive(file)
+		}
+
+	case "multipart/form-data":
+		file, _, formErr := r.FormFile("archive")
+		if formErr != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing archive upload")
+			return
+		}
+		defer file.Close()
+		source, err = t.sources.ImportMirrorArchive(file)
+
+	default:
+		source, err = t.sources.ImportMirrorArchive(r.Body)
+	}
+
+	if err != nil {
+		log.Printf("Error importing mirror: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, "Error importing mirror: "+err.Error())
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"success": true,
+		"source":  source.Config(),
+	})
+}
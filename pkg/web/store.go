@@ -3,6 +3,7 @@ package web
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	dogeboxd "github.com/dogeorg/dogeboxd/pkg"
@@ -13,6 +14,9 @@ type StoreListSourceEntryPup struct {
 	LatestVersion string                          `json:"latestVersion"`
 	LogoBase64    string                          `json:"logoBase64"`
 	Versions      map[string]dogeboxd.PupManifest `json:"versions"`
+	Signatures    map[string]string               `json:"signatures,omitempty"`
+	// Versions we have installed that the source has changed since.
+	SourceChanged []string `json:"sourceChanged,omitempty"`
 }
 
 type StoreListSourceEntry struct {
@@ -21,6 +25,7 @@ type StoreListSourceEntry struct {
 	Location    string                             `json:"location"`
 	Type        string                             `json:"type"`
 	LastChecked string                             `json:"lastChecked"`
+	Stale       bool                               `json:"stale"`
 	Pups        map[string]StoreListSourceEntryPup `json:"pups"`
 }
 
@@ -48,12 +53,19 @@ func (t api) getStoreList(w http.ResponseWriter, r *http.Request) {
 					LatestVersion: availablePup.Version,
 					LogoBase64:    availablePup.LogoBase64,
 					Versions:      versions,
+					Signatures:    map[string]string{},
 				}
 			}
 
 			// Retrieve the struct, modify it, and store it back in the map
 			pupEntry := pups[availablePup.Name]
 			pupEntry.Versions[availablePup.Version] = availablePup.Manifest
+			if availablePup.SignatureStatus != "" {
+				pupEntry.Signatures[availablePup.Version] = availablePup.SignatureStatus
+			}
+			if availablePup.SourceChanged {
+				pupEntry.SourceChanged = append(pupEntry.SourceChanged, availablePup.Version)
+			}
 
 			if semver.Compare("v"+availablePup.Version, "v"+pupEntry.LatestVersion) > 0 {
 				pupEntry.LatestVersion = availablePup.Version
@@ -84,9 +96,71 @@ func (t api) getStoreList(w http.ResponseWriter, r *http.Request) {
 			Location:    entry.Config.Location,
 			Type:        entry.Config.Type,
 			LastChecked: entry.LastChecked.Format(time.RFC3339),
+			Stale:       entry.Stale,
 			Pups:        pups,
 		}
 	}
 
 	sendResponse(w, response)
 }
+
+/* queryStore searches the store index. Query parameters:
+ * q, provides, requires, source, installed (true/false),
+ * versions (latest/all), offset and limit.
+ */
+func (t api) queryStore(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	q := dogeboxd.StoreQuery{
+		Text:        params.Get("q"),
+		Provides:    params.Get("provides"),
+		Requires:    params.Get("requires"),
+		SourceID:    params.Get("source"),
+		AllVersions: params.Get("versions") == "all",
+	}
+
+	if v := params.Get("installed"); v != "" {
+		installed, err := strconv.ParseBool(v)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "installed must be true or false")
+			return
+		}
+		q.Installed = &installed
+	}
+
+	for name, dest := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				sendErrorResponse(w, http.StatusBadRequest, name+" must be a positive number")
+				return
+			}
+			*dest = n
+		}
+	}
+
+	result, err := t.sources.QueryStore(q)
+	if err != nil {
+		log.Println("Error querying store:", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Error querying store")
+		return
+	}
+
+	sendResponse(w, result)
+}
+
+// getStorePup returns the full manifest for one version of a pup from the store.
+func (t api) getStorePup(w http.ResponseWriter, r *http.Request) {
+	pup, err := t.sources.GetSourcePup(r.PathValue("source"), r.PathValue("name"), r.PathValue("version"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	sendResponse(w, map[string]any{
+		"manifest":        pup.Manifest,
+		"logoBase64":      pup.LogoBase64,
+		"signatureStatus": pup.SignatureStatus,
+		"sourceChanged":   pup.SourceChanged,
+	})
+}